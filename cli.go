@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"ponder/pkg/generations"
+	"ponder/pkg/models"
+	"strconv"
+	"syscall"
+)
+
+// runDiff implements "ponder diff", which prints the changes between two
+// generations of a project.
+//
+// Usage: ponder diff [-project name] [-kind new] [-min-shift 1000] [-details] <to> [from]
+//
+// Args:
+// args ([]string): The command line arguments after "diff"
+//
+// Returns:
+// int: The exit code
+func runDiff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	projectName := flags.String("project", models.DefaultProjectName, "project to compare generations of")
+	kind := flags.String("kind", generations.ChangeNew, "changes to print: new, dropped, moved or all")
+	minShift := flags.Int("min-shift", generations.DefaultMinShift, "ranks a line has to move to count as moved")
+	details := flags.Bool("details", false, "print kind, old rank and new rank before every line")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ponder diff [flags] <to> [from]")
+		fmt.Fprintln(flags.Output(), "Compares generation <to> with [from] or the generation before it.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return 2
+	}
+
+	project, err := models.GetProject(*projectName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v: %s\n", err, *projectName)
+		return 1
+	}
+
+	toID, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid generation: %s\n", flags.Arg(0))
+		return 2
+	}
+	var fromID int
+	if flags.NArg() == 2 {
+		fromID, err = strconv.Atoi(flags.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid generation: %s\n", flags.Arg(1))
+			return 2
+		}
+	} else {
+		previous, err := generations.Previous(project, toID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: no generation before %d\n", toID)
+			return 1
+		}
+		fromID = previous.ID
+	}
+
+	// A closed pipe, such as piping into head, is reported as an error so
+	// the temporary files of the comparison are still removed
+	signal.Ignore(syscall.SIGPIPE)
+	writer := bufio.NewWriter(os.Stdout)
+
+	err = generations.Diff(context.Background(), project, fromID, toID, *kind, *minShift, func(change generations.Change) error {
+		var err error
+		if *details {
+			_, err = fmt.Fprintf(writer, "%s\t%d\t%d\t%s\n", change.Kind, change.OldRank, change.NewRank, change.Line)
+		} else {
+			_, err = writer.WriteString(change.Line + "\n")
+		}
+		return err
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if errors.Is(err, syscall.EPIPE) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
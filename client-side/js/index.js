@@ -11,6 +11,76 @@ document.addEventListener("DOMContentLoaded", function() {
     const importForm = document.getElementById("import-form");
     const importButton = document.getElementById("import-button");
     const importStatus = document.getElementById("import-status");
+    const loginForm = document.getElementById("login-form");
+    const loginStatus = document.getElementById("login-status");
+    const sessionInfo = document.getElementById("session-info");
+    const sessionStatus = document.getElementById("session-status");
+    const logoutButton = document.getElementById("logout-button");
+    const generateForm = document.getElementById("generate-form");
+    const cancelGenerateButton = document.getElementById("cancel-generate-button");
+    const generateStatus = document.getElementById("generate-status");
+    const generateProgress = document.getElementById("generate-progress");
+    let logStream = null;
+    const uploadChunkSize = 8 * 1024 * 1024;
+    const maxUploadRetries = 5;
+    let progressTimeout = null;
+
+    function showSession(session) {
+        const loggedIn = session !== null;
+        loginForm.hidden = loggedIn;
+        sessionInfo.hidden = !loggedIn;
+        document.querySelectorAll(".requires-login").forEach(section => {
+            section.hidden = !loggedIn;
+        });
+
+        if (loggedIn) {
+            sessionStatus.textContent = `Logged in as ${session.name} (${session.scopes.join(", ")})`;
+            if (logStream === null) {
+                fetchLogEntries();
+            }
+            pollProgress();
+        } else if (logStream !== null) {
+            logStream.close();
+            logStream = null;
+        }
+    }
+
+    function checkSession() {
+        fetch("/api/session").then(response => {
+            if (!response.ok) {
+                showSession(null);
+                return;
+            }
+            return response.json().then(data => showSession(data));
+        }).catch(error => {
+            showSession(null);
+        });
+    }
+
+    loginForm.addEventListener("submit", function(event) {
+        event.preventDefault();
+        const formData = new FormData();
+        formData.append("token", document.getElementById("login-token").value);
+
+        fetch("/api/login", {
+            method: "POST",
+            body: formData
+        }).then(response => response.json().then(data => {
+            if (!response.ok) {
+                loginStatus.textContent = "Login failed.";
+                return;
+            }
+            document.getElementById("login-token").value = "";
+            loginStatus.textContent = "";
+            showSession(data);
+        })).catch(error => {
+            loginStatus.textContent = "Login failed.";
+        });
+    });
+
+    logoutButton.addEventListener("click", function() {
+        fetch("/api/logout", { method: "POST" }).then(() => showSession(null));
+    });
 
 
     uploadForm.addEventListener("submit", function(event) {
@@ -22,18 +92,21 @@ document.addEventListener("DOMContentLoaded", function() {
             return;
         }
 
-        const formData = new FormData();
-        formData.append("file", file);
+        const format = document.getElementById("upload-format").value;
+        const username = document.getElementById("upload-username").checked;
+        const tags = document.getElementById("upload-tags").value;
 
         uploadButton.textContent = "Uploading...";
-        fetch("/api/upload", {
-            method: "POST",
-            body: formData
+        resumableUpload(file, format, username, tags).then(location => {
+            return fetch(`${location}/finalize`, { method: "POST" });
         }).then(response => response.json()).then(data => {
-            uploadStatus.textContent = `Message: ${data.message}, Duration: ${data.duration}`;
+            uploadStatus.textContent = `Message: ${data.message || data.error}, Duration: ${data.duration}`;
             uploadButton.textContent = "Upload";
+            if (data.job) {
+                pollJob(data.job, uploadStatus);
+            }
         }).catch(error => {
-            uploadStatus.textContent = "Upload failed.";
+            uploadStatus.textContent = "Upload failed. Upload the same file again to resume.";
             uploadButton.textContent = "Upload";
         });
     });
@@ -108,14 +181,22 @@ document.addEventListener("DOMContentLoaded", function() {
 
     importForm.addEventListener("submit", function(event) {
         event.preventDefault();
+        const formData = new FormData();
+        formData.append("format", document.getElementById("import-format").value);
+        formData.append("username", document.getElementById("import-username").checked);
+
         importButton.textContent = "Importing...";
         fetch("/api/import", {
-            method: "POST"
+            method: "POST",
+            body: formData
         })
         .then(response => response.json())
         .then(data => {
             importStatus.textContent = `Message: ${data.message}, Duration: ${data.duration}`;
             importButton.textContent = "Import";
+            if (data.job) {
+                pollJob(data.job, importStatus);
+            }
         })
         .catch(error => {
             importStatus.textContent = "Import failed.";
@@ -123,22 +204,165 @@ document.addEventListener("DOMContentLoaded", function() {
         });
     });
 
+    generateForm.addEventListener("submit", function(event) {
+        event.preventDefault();
+        fetch("/api/generate", { method: "POST" }).then(response => response.json()).then(data => {
+            generateStatus.textContent = data.job ? `Generation started as job ${data.job}.` : data.error;
+            pollProgress();
+        }).catch(error => {
+            generateStatus.textContent = "Failed to start generation.";
+        });
+    });
+
+    cancelGenerateButton.addEventListener("click", function() {
+        fetch("/api/generate", { method: "DELETE" }).then(response => response.json()).then(data => {
+            generateStatus.textContent = data.job ? `Cancelling job ${data.job}.` : data.error;
+        }).catch(error => {
+            generateStatus.textContent = "Failed to cancel generation.";
+        });
+    });
+
+    function pollProgress() {
+        if (progressTimeout !== null) {
+            clearTimeout(progressTimeout);
+            progressTimeout = null;
+        }
+        fetch("/api/ping").then(response => response.json()).then(data => {
+            const progress = data.progress;
+            if (!progress || !progress.running) {
+                generateProgress.textContent = `Last updated: ${data["last-updated"]}`;
+                return;
+            }
+            generateProgress.textContent = `Phase: ${progress.phase}, ${progress.percent.toFixed(1)}% of source processed, ` +
+                `${progress.chunks_written} chunks, ${progress.runs_written} runs, ${progress.lines_written} lines written`;
+            progressTimeout = setTimeout(pollProgress, 2000);
+        }).catch(error => {
+            generateProgress.textContent = "Failed to check generation progress.";
+        });
+    }
+
+    // Uploads are sent in chunks so a dropped connection only loses the
+    // chunk in flight. The upload URL is remembered per file so uploading the
+    // same file again, even after a reload, continues where it stopped.
+    async function resumableUpload(file, format, username, tags) {
+        const key = `upload:${file.name}:${file.size}:${file.lastModified}:${format}:${username}`;
+        let location = localStorage.getItem(key);
+        let offset = location ? await uploadOffset(location) : null;
+
+        if (offset === null) {
+            const params = new URLSearchParams({ format: format, username: username, filename: file.name, tags: tags });
+            const response = await fetch(`/api/uploads?${params}`, {
+                method: "POST",
+                headers: { "Upload-Length": String(file.size) }
+            });
+            if (!response.ok) {
+                throw new Error(`Creating the upload failed with status ${response.status}`);
+            }
+            location = response.headers.get("Location");
+            localStorage.setItem(key, location);
+            offset = 0;
+        }
+
+        let retries = 0;
+        while (offset < file.size) {
+            uploadStatus.textContent = `Uploaded ${Math.floor(offset / file.size * 100)}% of ${file.name}`;
+            try {
+                const response = await fetch(location, {
+                    method: "PATCH",
+                    headers: {
+                        "Content-Type": "application/offset+octet-stream",
+                        "Upload-Offset": String(offset)
+                    },
+                    body: file.slice(offset, offset + uploadChunkSize)
+                });
+                if (!response.ok && response.status !== 409) {
+                    throw new Error(`Uploading failed with status ${response.status}`);
+                }
+                offset = Number(response.headers.get("Upload-Offset"));
+                retries = 0;
+            } catch (error) {
+                if (++retries > maxUploadRetries) {
+                    throw error;
+                }
+                await new Promise(resolve => setTimeout(resolve, 2000 * retries));
+                const staged = await uploadOffset(location);
+                if (staged === null) {
+                    localStorage.removeItem(key);
+                    throw error;
+                }
+                offset = staged;
+            }
+        }
+
+        localStorage.removeItem(key);
+        return location;
+    }
+
+    // Resolves to null when the upload no longer exists and rejects when the
+    // server cannot be reached, so the upload can still be resumed later
+    function uploadOffset(location) {
+        return fetch(location, { method: "HEAD" }).then(response => {
+            if (response.status === 404) {
+                return null;
+            }
+            if (!response.ok) {
+                throw new Error(`Checking the upload failed with status ${response.status}`);
+            }
+            return Number(response.headers.get("Upload-Offset"));
+        });
+    }
+
+    function pollJob(id, status) {
+        fetch(`/api/jobs/${id}`).then(response => response.json()).then(data => {
+            const job = data.job;
+            if (!job) {
+                status.textContent = `Job ${id}: ${data.error}`;
+                return;
+            }
+            status.textContent = `Job ${job.id}: ${job.state}, ${job.lines_accepted} lines accepted, ${job.lines_rejected} rejected`;
+            if (job.lines_known) {
+                status.textContent += `, ${job.lines_known} already known`;
+            }
+            if (job.error) {
+                status.textContent += `, Error: ${job.error}`;
+            }
+            if (job.state === "queued" || job.state === "running") {
+                setTimeout(() => pollJob(id, status), 2000);
+            }
+        }).catch(error => {
+            status.textContent = `Failed to check job ${id}.`;
+        });
+    }
+
     function fetchLogEntries() {
-        fetch("/api/event-log").then(response => response.json()).then(data => {
+        fetch("/api/event-log?n=250").then(response => response.json()).then(data => {
             logEntries.innerHTML = "";
-            const entries = data.log_entries.slice(-250).reverse();
-            entries.forEach((entry, index) => {
-                const opacity = 1 - (index / 250);
-                const logEntry = document.createElement("div");
-                logEntry.style.opacity = opacity;
-                logEntry.textContent = `${entry.time} - ${entry.event}: ${entry.message}`;
-                logEntries.appendChild(logEntry);
-            });
+            (data.log_entries || []).forEach(addLogEntry);
+            streamLogEntries(data.cursor);
         }).catch(error => {
             logEntries.textContent = "Failed to load log entries.";
         });
     }
 
-    fetchLogEntries();
-    setInterval(fetchLogEntries, 600000);
+    function streamLogEntries(cursor) {
+        // EventSource reconnects on its own and resumes from the last entry
+        logStream = new EventSource(`/api/event-log/stream?since=${cursor}`);
+        logStream.addEventListener("log", event => {
+            addLogEntry(JSON.parse(event.data));
+        });
+    }
+
+    function addLogEntry(entry) {
+        const logEntry = document.createElement("div");
+        logEntry.textContent = `${entry.time} - [${entry.level}] ${entry.event}: ${entry.message}`;
+        logEntries.prepend(logEntry);
+        while (logEntries.children.length > 250) {
+            logEntries.lastChild.remove();
+        }
+        Array.from(logEntries.children).forEach((child, index) => {
+            child.style.opacity = 1 - (index / 250);
+        });
+    }
+
+    checkSession();
 });
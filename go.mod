@@ -4,6 +4,7 @@ go 1.24.5
 
 require (
 	github.com/gin-gonic/gin v1.10.0
+	github.com/klauspost/compress v1.18.0
 	golang.org/x/net v0.25.0
 	golang.org/x/text v0.15.0
 )
@@ -2,11 +2,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"ponder/pkg/api"
+	"ponder/pkg/auth"
 	"ponder/pkg/clientside"
 	"ponder/pkg/generate"
+	"ponder/pkg/jobs"
 	"ponder/pkg/models"
+	"ponder/pkg/state"
 	"ponder/pkg/utils"
 	"time"
 
@@ -15,41 +20,83 @@ import (
 
 func init() {
 	_, err := models.LoadConfig()
-	if err != nil {
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println(fmt.Errorf("Error loading config, using defaults: %v", err))
+	} else if err != nil {
 		fmt.Println(fmt.Errorf("Error loading config: %v", err))
+		os.Exit(1)
 	}
 
-	utils.LogInternalEvent("Server started", "Performing initial setup.")
 	utils.MakeFileIfNotExist(models.SourceWordlist)
 	utils.MakeFileIfNotExist(models.WizardWordlist)
+	utils.MakeFileIfNotExist(models.WizardCounts)
+
+	if err := models.LoadProjects(); err != nil {
+		fmt.Println(fmt.Errorf("Error loading projects: %v", err))
+		os.Exit(1)
+	}
+
+	// Uploads that were not generated before a restart are picked up by the
+	// scheduler through the restored timestamps
+	for _, project := range models.ListProjects() {
+		if err := state.Load(project); err != nil {
+			fmt.Println(fmt.Errorf("Error loading state of project %s: %v", project.Name, err))
+		}
+	}
+}
+
+// startServer loads the API tokens and starts the job workers and the
+// generation scheduler.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func startServer() {
+	utils.LogInternalEvent("Server started", "Performing initial setup.")
+
+	bootstrapToken, err := auth.LoadTokens()
+	if err != nil {
+		fmt.Println(fmt.Errorf("Error loading API tokens: %v", err))
+		os.Exit(1)
+	}
+	if bootstrapToken != "" {
+		// The secret is only shown once, the token store keeps its hash
+		fmt.Printf("Created admin API token: %s\n", bootstrapToken)
+		utils.LogInternalEvent("Bootstrap token created", "An admin API token was created and printed to stdout.")
+	}
 
-	waitTime := 15 * time.Minute
-	ticker := time.NewTicker(waitTime)
+	jobs.StartWorkers(models.CurrentConfig.JobWorkers)
+	api.ResumePendingJobs()
+	api.ExpireUploads()
+
+	// Projects are checked every minute and regenerated once their own
+	// interval has passed and there has been an upload since the last update.
+	// Expired resumable uploads are discarded on every check.
+	ticker := time.NewTicker(time.Minute)
 	go func() {
 		time.Sleep(15 * time.Second)
-		utils.LogInternalEvent("Starting updater", fmt.Sprintf("Starting the updater with a %v interval.", waitTime))
+		utils.LogInternalEvent("Starting updater", fmt.Sprintf("Starting the updater for %d projects.", len(models.ListProjects())))
 		for {
 			select {
 			case <-ticker.C:
-				// If there has been an update since the last time the wordlist
-				// was updated, update the wordlist
-				if models.LastUploaded.After(models.LastUpdated) {
-					overallStartTime := time.Now()
-					currentProcessStartTime := time.Now()
-					overallEndTime := time.Time{}
-					currentProcessEndTime := time.Time{}
-
-					utils.LogInternalEvent("Starting a wordlist update", fmt.Sprintf("Last uploaded %v.", models.LastUploaded))
-					api.Mu.Lock()
-					currentProcessStartTime = time.Now()
-					utils.LogInternalEvent("Creating wizard wordlist", fmt.Sprintf("Generating %v.", models.WizardWordlist))
-					generate.CreateWizardWordlist(models.SourceWordlist, models.WizardWordlist)
-					currentProcessEndTime = time.Now()
-					utils.LogInternalEvent("Wizard wordlist created", fmt.Sprintf("Duration: %v.", currentProcessEndTime.Sub(currentProcessStartTime)))
-					api.Mu.Unlock()
-					models.LastUpdated = time.Now()
-					overallEndTime = time.Now()
-					utils.LogInternalEvent("Wordlist update complete", fmt.Sprintf("Duration: %v.", overallEndTime.Sub(overallStartTime)))
+				api.ExpireUploads()
+				for _, project := range models.ListProjects() {
+					if time.Since(project.LastChecked()) < project.Interval() {
+						continue
+					}
+					project.SetLastChecked(time.Now())
+
+					// If there has been an update since the last time the
+					// wordlist was updated, update the wordlist
+					if project.LastUploaded().After(project.LastUpdated()) {
+						// A generation started through the API is left to
+						// finish and the project is checked again next cycle
+						if err := generate.RunScheduled(project); errors.Is(err, generate.ErrGenerationRunning) {
+							project.SetLastChecked(time.Time{})
+						}
+					}
 				}
 			}
 		}
@@ -57,6 +104,12 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiff(os.Args[2:]))
+	}
+
+	startServer()
+
 	ginRouter := gin.Default()
 	ginRouter.SetTrustedProxies([]string{""})
 	ginRouter.Static("/static/css", "/etc/ponder/static/css")
@@ -66,13 +119,65 @@ func main() {
 
 	public := ginRouter.Group("/")
 	publicAPI := ginRouter.Group("/api")
+	readAPI := publicAPI.Group("", auth.Require(auth.ScopeRead))
+	writeAPI := publicAPI.Group("", auth.Require(auth.ScopeWrite))
+	adminAPI := publicAPI.Group("", auth.Require(auth.ScopeAdmin))
 
 	public.GET("/", clientside.ClientIndexHandler)
+	public.GET("/metrics", auth.Require(auth.ScopeRead), api.MetricsHandler)
 	publicAPI.GET("/ping", api.PingHandler)
-	publicAPI.GET("/event-log", api.EventLogHandler)
-	publicAPI.POST("/upload", api.UploadHandler)
-	publicAPI.GET("/download/:n", api.DownloadHandler)
-	publicAPI.POST("/import", api.ImportHandler)
+	publicAPI.POST("/login", api.LoginHandler)
+	publicAPI.POST("/logout", api.LogoutHandler)
+	publicAPI.GET("/session", api.SessionHandler)
+
+	readAPI.GET("/event-log", api.EventLogHandler)
+	readAPI.GET("/event-log/stream", api.EventLogStreamHandler)
+	readAPI.GET("/download/:n", api.DownloadHandler)
+	readAPI.GET("/rules/:n", api.RulesHandler)
+	readAPI.GET("/masks/:n", api.MasksHandler)
+	readAPI.GET("/jobs", api.JobsHandler)
+	readAPI.GET("/jobs/:id", api.JobHandler)
+	readAPI.GET("/projects", api.ProjectsHandler)
+	readAPI.GET("/projects/:name", api.ProjectHandler)
+	readAPI.GET("/projects/:name/download/:n", api.DownloadHandler)
+	readAPI.GET("/projects/:name/rules/:n", api.RulesHandler)
+	readAPI.GET("/projects/:name/masks/:n", api.MasksHandler)
+	readAPI.GET("/generations", api.GenerationsHandler)
+	readAPI.GET("/projects/:name/generations", api.GenerationsHandler)
+	readAPI.GET("/generations/:id/diff", api.GenerationDiffHandler)
+	readAPI.GET("/projects/:name/generations/:id/diff", api.GenerationDiffHandler)
+	readAPI.GET("/sources", api.SourcesHandler)
+	readAPI.GET("/sources/:id", api.SourceHandler)
+	readAPI.GET("/projects/:name/sources", api.SourcesHandler)
+	readAPI.GET("/projects/:name/sources/:id", api.SourceHandler)
+
+	writeAPI.POST("/upload", api.UploadHandler)
+	writeAPI.POST("/import", api.ImportHandler)
+	writeAPI.POST("/projects/:name/upload", api.UploadHandler)
+	writeAPI.POST("/projects/:name/import", api.ImportHandler)
+	writeAPI.POST("/uploads", api.CreateUploadHandler)
+	writeAPI.HEAD("/uploads/:id", api.UploadOffsetHandler)
+	writeAPI.PATCH("/uploads/:id", api.PatchUploadHandler)
+	writeAPI.POST("/uploads/:id/finalize", api.FinalizeUploadHandler)
+	writeAPI.DELETE("/uploads/:id", api.DeleteUploadHandler)
+	writeAPI.POST("/projects/:name/uploads", api.CreateUploadHandler)
+	writeAPI.HEAD("/projects/:name/uploads/:id", api.UploadOffsetHandler)
+	writeAPI.PATCH("/projects/:name/uploads/:id", api.PatchUploadHandler)
+	writeAPI.POST("/projects/:name/uploads/:id/finalize", api.FinalizeUploadHandler)
+	writeAPI.DELETE("/projects/:name/uploads/:id", api.DeleteUploadHandler)
+	writeAPI.POST("/generate", api.GenerateHandler)
+	writeAPI.DELETE("/generate", api.CancelGenerateHandler)
+	writeAPI.POST("/projects/:name/generate", api.GenerateHandler)
+	writeAPI.DELETE("/projects/:name/generate", api.CancelGenerateHandler)
+
+	adminAPI.POST("/projects/:name", api.CreateProjectHandler)
+	adminAPI.POST("/generations/:id/rollback", api.RollbackHandler)
+	adminAPI.POST("/projects/:name/generations/:id/rollback", api.RollbackHandler)
+	adminAPI.DELETE("/sources/:id", api.DeleteSourceHandler)
+	adminAPI.DELETE("/projects/:name/sources/:id", api.DeleteSourceHandler)
+	adminAPI.GET("/tokens", api.TokensHandler)
+	adminAPI.POST("/tokens", api.CreateTokenHandler)
+	adminAPI.DELETE("/tokens/:id", api.DeleteTokenHandler)
 
 	err := ginRouter.Run(":8080")
 	if err != nil {
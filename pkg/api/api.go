@@ -2,23 +2,31 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
+	"ponder/pkg/auth"
+	"ponder/pkg/generations"
+	"ponder/pkg/ingest"
+	"ponder/pkg/jobs"
+	"ponder/pkg/masks"
+	"ponder/pkg/metrics"
 	"ponder/pkg/models"
+	"ponder/pkg/rules"
+	"ponder/pkg/sources"
+	"ponder/pkg/state"
 	"ponder/pkg/utils"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// Mu is a mutex for synchronizing file writes
-var Mu sync.Mutex
-
 // PingHandler is a handler for GET /api/ping
 //
 // Args:
@@ -29,13 +37,32 @@ var Mu sync.Mutex
 func PingHandler(c *gin.Context) {
 	startTime := time.Now()
 
+	project, err := models.GetProject(models.DefaultProjectName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"duration":     time.Since(startTime).String(),
-		"last-updated": models.LastUpdated,
+		"last-updated": project.LastUpdated(),
+		"progress":     project.Progress.Snapshot(),
 	})
 }
 
-// UploadHandler is a handler for POST /api/upload
+// UploadHandler is a handler for POST /api/upload and
+// POST /api/projects/:name/upload
+//
+// Accepts one or more files in the "file" field of a multipart form, or a
+// text/plain or application/octet-stream request body, which is streamed to
+// disk as it arrives. Every file is staged and processed by its own
+// background job. The response holds the job IDs to poll with
+// GET /api/jobs/:id. Every file is recorded as a source with the optional
+// comma separated "tags" value, and a request body with the optional
+// "filename" value as its name.
 //
 // Args:
 // c (gin.Context): Gin context
@@ -45,105 +72,210 @@ func PingHandler(c *gin.Context) {
 func UploadHandler(c *gin.Context) {
 	startTime := time.Now()
 
-	Mu.Lock()
-	defer Mu.Unlock()
-
-	file, _, err := c.Request.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":    "Bad Request",
-			"duration": time.Since(startTime).String(),
-		})
-		return
-	}
-	defer file.Close()
-
-	targetFile, err := os.OpenFile(models.SourceWordlist, os.O_APPEND|os.O_WRONLY, os.ModeAppend)
-	if err != nil {
-		utils.LogInternalEvent("Error opening file in upload handler", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":    "Internal Server Error",
-			"duration": time.Since(startTime).String(),
-		})
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
 		return
 	}
-	defer targetFile.Close()
 
-	fileInfo, err := targetFile.Stat()
+	format, err := ingestFormatFromRequest(c)
 	if err != nil {
-		utils.LogInternalEvent("Error getting file info in upload handler", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":    "Internal Server Error",
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    err.Error(),
 			"duration": time.Since(startTime).String(),
 		})
 		return
 	}
 
-	if fileInfo.Size() > 0 {
-		if _, err := targetFile.Write([]byte("\n")); err != nil {
-			utils.LogInternalEvent("Error writing to file in upload handler", err.Error())
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":    "Internal Server Error",
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	switch mediaType {
+	case "text/plain", "application/octet-stream":
+		job, err := queueUpload(project, c.Request.Body, pendingUpload(c, uploadName(c, "request body"), format))
+		if errors.Is(err, jobs.ErrQueueFull) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":    "Job queue is full",
 				"duration": time.Since(startTime).String(),
 			})
 			return
 		}
-	}
-
-	buffer := make([]byte, 4*1024*1024)
-
-	for {
-		n, err := file.Read(buffer)
-		if err != nil && err != io.EOF {
-			utils.LogInternalEvent("Error reading file in upload handler", err.Error())
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error":    "Internal Server Error",
 				"duration": time.Since(startTime).String(),
 			})
 			return
 		}
-		if n == 0 {
-			break
-		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":  "File queued for processing",
+			"job":      job.ID(),
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	case "multipart/form-data":
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":    "Unsupported Media Type",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
 
-		content := string(buffer[:n])
-		lines := strings.Split(content, "\n")
-		var transformedLines []string
-		for _, line := range lines {
-			convertedLine, err := models.ConvertHexToPlaintext(line)
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["file"]) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Bad Request",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	// Every file is reported separately so one failure does not hide the
+	// files that were queued
+	files := make([]gin.H, 0, len(form.File["file"]))
+	var queued []string
+	queueFull := false
+	for _, header := range form.File["file"] {
+		result := gin.H{"file": header.Filename}
+		file, err := header.Open()
+		if err == nil {
+			var job *jobs.Job
+			pending := pendingUpload(c, header.Filename, format)
+			if len(form.File["file"]) > 1 {
+				// A single "source" value cannot identify several files
+				pending.Key = header.Filename
+			}
+			job, err = queueUpload(project, file, pending)
+			file.Close()
 			if err == nil {
-				if utils.IsAllDigitsOrSpecialChars(convertedLine) || utils.ContainsOnlyASCII(convertedLine) == false || utils.LikelyContainsWords(convertedLine) == false || utils.IsQualityCandidateCheck(convertedLine) == false {
-					continue
-				}
-				transformedLines = append(transformedLines, strings.TrimSpace(strings.ToLower(convertedLine)))
-			} else {
-				if utils.IsAllDigitsOrSpecialChars(line) || utils.ContainsOnlyASCII(line) == false || utils.LikelyContainsWords(line) == false || utils.IsQualityCandidateCheck(line) == false {
-					continue
-				}
-				transformedLines = append(transformedLines, strings.TrimSpace(strings.ToLower(line)))
+				result["job"] = job.ID()
+				queued = append(queued, job.ID())
 			}
 		}
-		updatedContent := strings.Join(transformedLines, "\n")
-
-		if _, err := targetFile.Write([]byte(updatedContent)); err != nil {
-			utils.LogInternalEvent("Error writing to file in upload handler", err.Error())
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":    "Internal Server Error",
-				"duration": time.Since(startTime).String(),
-			})
-			return
+		if errors.Is(err, jobs.ErrQueueFull) {
+			result["error"] = "Job queue is full"
+			queueFull = true
+		} else if err != nil {
+			result["error"] = "Internal Server Error"
 		}
+		files = append(files, result)
 	}
 
-	utils.LogInternalEvent("File uploaded successfully", fmt.Sprintf("Duration: %s", time.Since(startTime).String()))
-	models.LastUploaded = time.Now()
-	c.JSON(http.StatusOK, gin.H{
-		"message":  "File uploaded successfully",
+	if len(queued) == 0 && queueFull {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":    "Job queue is full",
+			"files":    files,
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+	if len(queued) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"files":    files,
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	response := gin.H{
+		"message":  "File queued for processing",
+		"files":    files,
 		"duration": time.Since(startTime).String(),
+	}
+	if len(files) == 1 {
+		response["job"] = queued[0]
+	} else {
+		response["message"] = fmt.Sprintf("%d of %d files queued for processing", len(queued), len(files))
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+// queueUpload stages an uploaded file and queues the job that processes it.
+// The file is not staged when the queue is already full.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// file (io.Reader): The uploaded file
+// pending (state.PendingJob): The format and provenance of the upload
+//
+// Returns:
+// (*jobs.Job): The queued job
+// error: jobs.ErrQueueFull if the queue has no free slot, or an error if the
+// file could not be staged
+func queueUpload(project *models.Project, file io.Reader, pending state.PendingJob) (*jobs.Job, error) {
+	if jobs.QueueFull() {
+		return nil, jobs.ErrQueueFull
+	}
+
+	stagedPath, size, err := stageUpload(project, file)
+	if err != nil {
+		utils.LogInternalError("Error staging file in upload handler", fmt.Sprintf("Project: %s, File: %s, Error: %v", project.Name, pending.Name, err))
+		return nil, err
+	}
+
+	pending.StagedPath = stagedPath
+	pending.Size = size
+	job, err := enqueuePendingJob(project, pending)
+	if err != nil {
+		os.Remove(stagedPath)
+		return nil, err
+	}
+
+	utils.LogEvent(models.LogLevelInfo, "File staged for processing", fmt.Sprintf("Project: %s, File: %s, Job: %s", project.Name, pending.Name, job.ID()), models.LogFields{
+		"project": project.Name,
+		"file":    pending.Name,
+		"job":     job.ID(),
 	})
+	return job, nil
+}
+
+// pendingUpload describes an upload of the request as a pending job with the
+// requested format, the uploader, the tags from the optional "tags" value,
+// and the dedup key from the optional "source" value, which defaults to the
+// name of the file.
+//
+// Args:
+// c (gin.Context): Gin context
+// name (string): The name of the uploaded file
+// format (ingest.Format): The layout of the lines in the file
+//
+// Returns:
+// state.PendingJob: The pending job without a staged file
+func pendingUpload(c *gin.Context, name string, format ingest.Format) state.PendingJob {
+	pending := state.PendingJob{
+		Kind:         jobs.KindUpload,
+		Format:       format.Name,
+		FormatFields: format.Fields,
+		Name:         name,
+		Tags:         sources.ParseTags(c.Request.FormValue("tags")),
+		Key:          c.Request.FormValue("source"),
+	}
+	if pending.Key == "" {
+		pending.Key = name
+	}
+	if token, ok := auth.FromContext(c); ok {
+		pending.Uploader = token.Name
+	}
+	return pending
+}
+
+// uploadName returns the file name from the optional "filename" value of a
+// request that does not carry one itself.
+//
+// Args:
+// c (gin.Context): Gin context
+// fallback (string): The name used when none is given
+//
+// Returns:
+// string: The name of the uploaded file
+func uploadName(c *gin.Context, fallback string) string {
+	if name := c.Request.FormValue("filename"); name != "" {
+		return name
+	}
+	return fallback
 }
 
-// DownloadHandler is a handler for GET /api/download/:n
+// DownloadHandler is a handler for GET /api/download/:n and
+// GET /api/projects/:name/download/:n
 //
 // Args:
 // c (gin.Context): Gin context
@@ -153,6 +285,11 @@ func UploadHandler(c *gin.Context) {
 func DownloadHandler(c *gin.Context) {
 	startTime := time.Now()
 
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
 	n := c.Param("n")
 	if n == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -172,12 +309,47 @@ func DownloadHandler(c *gin.Context) {
 	}
 
 	substring := c.Query("substring")
+	includeCounts := c.Query("counts") == "true"
+
+	minCount := 0
+	if c.Query("min-count") != "" {
+		minCount, err = strconv.Atoi(c.Query("min-count"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":    "Bad Request",
+				"duration": time.Since(startTime).String(),
+			})
+			return
+		}
+	}
+
+	wordlistPATH, countsPATH := project.WizardWordlist, project.WizardCounts
+	if c.Query("generation") != "" {
+		id, err := strconv.Atoi(c.Query("generation"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":    "Bad Request",
+				"duration": time.Since(startTime).String(),
+			})
+			return
+		}
+		wordlistPATH, countsPATH, err = generations.Files(project, id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":    "Not Found",
+				"duration": time.Since(startTime).String(),
+			})
+			return
+		}
+	}
 
 	var lines []string
-	if substring != "" {
-		lines, err = utils.GetFirstNLines(models.WizardWordlist, numberofLines, substring)
+	if minCount > 0 || includeCounts {
+		lines, err = utils.GetFirstNCountedLines(countsPATH, numberofLines, minCount, includeCounts, substring)
+	} else if substring != "" {
+		lines, err = utils.GetFirstNLines(wordlistPATH, numberofLines, substring)
 	} else {
-		lines, err = utils.GetFirstNLines(models.WizardWordlist, numberofLines)
+		lines, err = utils.GetFirstNLines(wordlistPATH, numberofLines)
 	}
 
 	if err != nil {
@@ -196,27 +368,14 @@ func DownloadHandler(c *gin.Context) {
 		return
 	}
 
-	joinedLines := strings.Join(lines, "\n")
-	reader := strings.NewReader(joinedLines)
-
-	c.Header("Content-Type", "text/plain")
-	c.Header("Transfer-Encoding", "chunked")
-	c.Status(http.StatusOK)
-
-	buffer := make([]byte, 1024)
-	for {
-		n, err := reader.Read(buffer)
-		if err != nil && err != io.EOF {
-			return
-		}
-		if n == 0 {
-			break
+	if project.Config.Characters.HexEncodeOutput {
+		for i, line := range lines {
+			lines[i] = hexEncodeDownloadLine(line, includeCounts)
 		}
+	}
 
-		if _, err := c.Writer.Write(buffer[:n]); err != nil {
-			return
-		}
-		c.Writer.Flush()
+	if err := streamLines(c, "wordlist", lines); err != nil {
+		return
 	}
 
 	duration := time.Since(startTime).String()
@@ -225,15 +384,30 @@ func DownloadHandler(c *gin.Context) {
 
 // EventLogHandler is a handler for GET /api/event-log
 //
+// The entries can be filtered with the "level" (minimum level), "event"
+// (substring of the event), "start" and "end" (RFC 3339 times), and "n"
+// (latest entries) query parameters.
+//
 // Args:
 // c (gin.Context): Gin context
 //
 // Returns:
 // None
 func EventLogHandler(c *gin.Context) {
-	entries, err := utils.ReadLogEntries()
+	filter, err := logFilterFromQuery(c)
 	if err != nil {
-		utils.LogInternalEvent("Error reading log entries in event log handler", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Bad Request",
+		})
+		return
+	}
+
+	// The cursor is taken first so a stream started from it may repeat an
+	// entry but never misses one
+	cursor := utils.LastLogEntryID()
+	entries, err := utils.ReadLogEntries(filter)
+	if err != nil {
+		utils.LogInternalError("Error reading log entries in event log handler", err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Internal Server Error",
 		})
@@ -242,141 +416,334 @@ func EventLogHandler(c *gin.Context) {
 	c.JSON(http.StatusOK,
 		gin.H{
 			"log_entries": entries,
+			"cursor":      cursor,
 		})
 }
 
-// ImportHandler is a handler for POST /api/import
-//
-// This handler imports all .txt files from the import directory
-// and adds their contents to the source wordlist just like the upload handler.
+// logFilterFromQuery reads the event log filter from the query parameters.
 //
 // Args:
 // c (gin.Context): Gin context
 //
 // Returns:
-// None
-func ImportHandler(c *gin.Context) {
-	startTime := time.Now()
+// utils.LogFilter: The filter
+// error: An error if a parameter is invalid
+func logFilterFromQuery(c *gin.Context) (utils.LogFilter, error) {
+	filter := utils.LogFilter{
+		Level: c.Query("level"),
+		Event: c.Query("event"),
+	}
+	if filter.Level != "" && !utils.ValidLogLevel(filter.Level) {
+		return filter, fmt.Errorf("invalid level: %s", filter.Level)
+	}
 
-	Mu.Lock()
-	defer Mu.Unlock()
+	var err error
+	if c.Query("start") != "" {
+		if filter.Start, err = time.Parse(time.RFC3339, c.Query("start")); err != nil {
+			return filter, err
+		}
+	}
+	if c.Query("end") != "" {
+		if filter.End, err = time.Parse(time.RFC3339, c.Query("end")); err != nil {
+			return filter, err
+		}
+	}
+	if c.Query("n") != "" {
+		filter.Limit, err = strconv.Atoi(c.Query("n"))
+		if err != nil || filter.Limit < 1 {
+			return filter, fmt.Errorf("invalid n: %s", c.Query("n"))
+		}
+	}
+	return filter, nil
+}
 
-	// Ensure the import directory exists and create it if it does not
-	if _, err := os.Stat(models.ImportDirectory); os.IsNotExist(err) {
-		if err := os.MkdirAll(models.ImportDirectory, os.ModePerm); err != nil {
-			utils.LogInternalEvent("Error creating import directory", err.Error())
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":    "Internal Server Error",
-				"duration": time.Since(startTime).String(),
+// EventLogStreamHandler is a handler for GET /api/event-log/stream
+//
+// Log entries are sent as server-sent events as they are written. The entries
+// after the Last-Event-ID header sent by a reconnecting EventSource, or else
+// the "since" cursor, are sent first, reading them from the log files when
+// they are no longer kept in memory. A "reset" event is sent first instead
+// when they were rotated out of the log files, so the client reloads the log.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func EventLogStreamHandler(c *gin.Context) {
+	cursor := c.GetHeader("Last-Event-ID")
+	if cursor == "" {
+		cursor = c.Query("since")
+	}
+	var since int64
+	if cursor != "" {
+		var err error
+		since, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Bad Request",
 			})
 			return
 		}
 	}
 
-	files, err := os.ReadDir(models.ImportDirectory)
-	if err != nil {
-		utils.LogInternalEvent("Error reading import directory", err.Error())
+	backlog, entries, unsubscribe, err := utils.SubscribeLogEntries(since)
+	if err != nil && !errors.Is(err, utils.ErrLogCursorTooOld) {
+		utils.LogInternalError("Error reading log entries in event log stream handler", err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":    "Internal Server Error",
-			"duration": time.Since(startTime).String(),
+			"error": "Internal Server Error",
 		})
 		return
 	}
+	defer unsubscribe()
 
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".txt") {
-			// ensure the file is not the source wordlist or the wizard wordlist
-			if file.Name() == models.SourceWordlist || file.Name() == models.WizardWordlist {
-				continue
-			}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stops reverse proxies from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if errors.Is(err, utils.ErrLogCursorTooOld) {
+		if _, err := io.WriteString(c.Writer, "event: reset\ndata: {\"error\":\"Cursor too old\"}\n\n"); err != nil {
+			return
+		}
+	}
+	// The subscription may repeat entries of the backlog
+	var lastSent int64
+	for _, entry := range backlog {
+		if err := writeLogEvent(c, entry); err != nil {
+			return
+		}
+		lastSent = entry.ID
+	}
+	c.Writer.Flush()
+
+	// Comments keep idle connections from being closed by proxies
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
 
-			filePath := fmt.Sprintf("%s/%s", models.ImportDirectory, file.Name())
-			err = appendFileToWordlist(filePath, models.SourceWordlist)
-			if err != nil {
-				utils.LogInternalEvent("Error appending file to wordlist in import handler", err.Error())
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error":    "Internal Server Error",
-					"duration": time.Since(startTime).String(),
-				})
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(c.Writer, ": heartbeat\n\n"); err != nil {
 				return
 			}
-			// Remove the file after processing
-			if err := os.Remove(fmt.Sprintf("%s/%s", models.ImportDirectory, file.Name())); err != nil {
-				utils.LogInternalEvent("Error removing file after import", err.Error())
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error":    "Internal Server Error",
-					"duration": time.Since(startTime).String(),
-				})
+		case entry, ok := <-entries:
+			if !ok {
+				return
+			}
+			if entry.ID <= lastSent {
+				continue
+			}
+			if err := writeLogEvent(c, entry); err != nil {
 				return
 			}
+			lastSent = entry.ID
 		}
+		c.Writer.Flush()
 	}
+}
 
-	models.LastUploaded = time.Now()
-	utils.LogInternalEvent("Files imported successfully", fmt.Sprintf("Duration: %s", time.Since(startTime).String()))
-	c.JSON(http.StatusOK, gin.H{
-		"message":  "Files imported successfully",
+// writeLogEvent writes a log entry as a server-sent event.
+//
+// Args:
+// c (gin.Context): Gin context
+// entry (models.LogEntry): The entry to write
+//
+// Returns:
+// error: An error if the client could not be written to
+func writeLogEvent(c *gin.Context, entry models.LogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: log\ndata: %s\n\n", entry.ID, data)
+	return err
+}
+
+// ImportHandler is a handler for POST /api/import and
+// POST /api/projects/:name/import
+//
+// This handler queues a background job that imports all files from the
+// import directory and adds their contents to the source wordlist just like
+// the upload handler. Gzip, bzip2, zstd and zip files are detected by their
+// magic bytes and decompressed while streaming.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func ImportHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	format, err := ingestFormatFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    err.Error(),
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	// Ensure the import directory exists and create it if it does not
+	if _, err := os.Stat(project.ImportDirectory); os.IsNotExist(err) {
+		if err := os.MkdirAll(project.ImportDirectory, os.ModePerm); err != nil {
+			utils.LogInternalError("Error creating import directory", err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":    "Internal Server Error",
+				"duration": time.Since(startTime).String(),
+			})
+			return
+		}
+	}
+
+	pending := pendingUpload(c, "", format)
+	pending.Kind = jobs.KindImport
+	// Every imported file is keyed by its own name
+	pending.Key = ""
+	job, err := enqueuePendingJob(project, pending)
+	if errors.Is(err, jobs.ErrQueueFull) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":    "Job queue is full",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "Import queued for processing",
+		"job":      job.ID(),
 		"duration": time.Since(startTime).String(),
 	})
 }
 
 // appendFileToWordlist appends the contents of a file to the source wordlist
-// It reads the file, processes its contents, and writes them to the target
-// file in a manner similar to the UploadHandler.
+// It streams the file through the same decompression and ingest filters as the
+// UploadHandler.
 //
 // Args:
 // filePath (string): Path to the source file to be appended
 // targetFilePath (string): Path to the target wordlist file
+// opts (ingest.Options): The ingest options
 //
 // Returns:
 // error: An error if any occurs during the process
-func appendFileToWordlist(filePath, targetFilePath string) error {
+func appendFileToWordlist(filePath, targetFilePath string, opts ingest.Options) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("error opening file %s: %w", filePath, err)
 	}
 	defer file.Close()
 
-	targetFile, err := os.OpenFile(targetFilePath, os.O_APPEND|os.O_WRONLY, os.ModeAppend)
+	fileInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("error opening target file %s: %w", targetFilePath, err)
+		return fmt.Errorf("error getting file info %s: %w", filePath, err)
 	}
-	defer targetFile.Close()
 
-	buffer := make([]byte, 4*1024*1024)
+	return ingest.AppendFileToWordlist(file, fileInfo.Size(), targetFilePath, opts)
+}
 
+// ingestOptions builds the ingest options for a project with the analyzers
+// that observe every uploaded plaintext.
+//
+// Args:
+// project (*models.Project): The project receiving the data
+// format (ingest.Format): The requested ingest format
+//
+// Returns:
+// ingest.Options: The ingest options
+func ingestOptions(project *models.Project, format ingest.Format) ingest.Options {
+	return ingest.Options{
+		Format: format,
+		Config: project.Config,
+		Analyzers: []ingest.Analyzer{
+			rules.NewCollector(project.RulesSource),
+			masks.NewCollector(project.MasksSource),
+		},
+		Project: project.Name,
+	}
+}
+
+// streamLines writes the lines as a chunked plain text response.
+//
+// Args:
+// c (gin.Context): Gin context
+// kind (string): The kind of download counted in the download metrics
+// lines ([]string): The lines to write
+//
+// Returns:
+// error: An error if the client could not be written to
+func streamLines(c *gin.Context, kind string, lines []string) error {
+	joinedLines := strings.Join(lines, "\n")
+	reader := strings.NewReader(joinedLines)
+
+	c.Header("Content-Type", "text/plain")
+	c.Header("Transfer-Encoding", "chunked")
+	c.Status(http.StatusOK)
+
+	buffer := make([]byte, 1024)
 	for {
-		n, err := file.Read(buffer)
+		n, err := reader.Read(buffer)
 		if err != nil && err != io.EOF {
-			return fmt.Errorf("error reading file %s: %w", filePath, err)
+			return err
 		}
 		if n == 0 {
 			break
 		}
 
-		content := string(buffer[:n])
-		lines := strings.Split(content, "\n")
-		var transformedLines []string
-		for _, line := range lines {
-			convertedLine, err := models.ConvertHexToPlaintext(line)
-			if err == nil {
-				if utils.IsAllDigitsOrSpecialChars(convertedLine) || utils.ContainsOnlyASCII(convertedLine) == false || utils.LikelyContainsWords(convertedLine) == false || utils.IsQualityCandidateCheck(convertedLine) == false {
-					continue
-				}
-				transformedLines = append(transformedLines, strings.TrimSpace(strings.ToLower(convertedLine)))
-			} else {
-				if utils.IsAllDigitsOrSpecialChars(line) || utils.ContainsOnlyASCII(line) == false || utils.LikelyContainsWords(line) == false || utils.IsQualityCandidateCheck(line) == false {
-					continue
-				}
-				transformedLines = append(transformedLines, strings.TrimSpace(strings.ToLower(line)))
-			}
+		written, err := c.Writer.Write(buffer[:n])
+		metrics.DownloadBytes.With(kind).Add(float64(written))
+		if err != nil {
+			return err
 		}
-		updatedContent := strings.Join(transformedLines, "\n")
+		c.Writer.Flush()
+	}
 
-		if _, err := targetFile.Write([]byte(updatedContent)); err != nil {
-			return fmt.Errorf("error writing to target file %s: %w", targetFilePath, err)
+	return nil
+}
+
+// ingestFormatFromRequest reads the optional "format" and "username" values
+// from the query string or form body.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// ingest.Format: The requested ingest format
+// error: An error if the format is unknown
+func ingestFormatFromRequest(c *gin.Context) (ingest.Format, error) {
+	return ingest.ParseFormat(c.Request.FormValue("format"), c.Request.FormValue("username") == "true")
+}
+
+// hexEncodeDownloadLine encodes the candidate in a download line as $HEX[]
+// when hashcat would not read it reliably as plain text.
+//
+// Args:
+// line (string): The download line
+// includeCounts (bool): True if the line is a "count\tcandidate" record
+//
+// Returns:
+// string: The line with the candidate encoded if needed
+func hexEncodeDownloadLine(line string, includeCounts bool) string {
+	if includeCounts {
+		count, candidate, found := strings.Cut(line, "\t")
+		if found && models.NeedsHexEncoding(candidate) {
+			return count + "\t" + models.ConvertPlaintextToHex(candidate)
 		}
+		return line
 	}
 
-	return nil
+	if models.NeedsHexEncoding(line) {
+		return models.ConvertPlaintextToHex(line)
+	}
+	return line
 }
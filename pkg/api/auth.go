@@ -0,0 +1,211 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"ponder/pkg/auth"
+	"ponder/pkg/models"
+	"ponder/pkg/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionLifetime is how long the login cookie is kept by the browser
+const sessionLifetime = 30 * 24 * time.Hour
+
+// LoginHandler is a handler for POST /api/login
+//
+// The "token" form value is checked and stored in an HttpOnly cookie so the
+// browser sends it with every following request.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func LoginHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	secret := c.Request.FormValue("token")
+	token, ok := auth.Authenticate(secret)
+	if !ok {
+		utils.LogEvent(models.LogLevelWarn, "Failed login", fmt.Sprintf("Client: %s", c.ClientIP()), models.LogFields{
+			"client": c.ClientIP(),
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":    "Unauthorized",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	setSessionCookie(c, secret, int(sessionLifetime.Seconds()))
+	utils.LogInternalEvent("Login", fmt.Sprintf("Token: %s", token.Name))
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Logged in",
+		"name":     token.Name,
+		"scopes":   token.Scopes,
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// LogoutHandler is a handler for POST /api/logout
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func LogoutHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	setSessionCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Logged out",
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// SessionHandler is a handler for GET /api/session
+//
+// It reports the name and scopes of the token sent with the request so the
+// client can decide whether to show the login form.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func SessionHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	token, ok := auth.Authenticate(auth.SecretFromRequest(c))
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":    "Unauthorized",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":     token.Name,
+		"scopes":   token.Scopes,
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// TokensHandler is a handler for GET /api/tokens
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func TokensHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens":   auth.ListTokens(),
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// CreateTokenHandler is a handler for POST /api/tokens
+//
+// The JSON body holds the "name" and "scopes" of the token. The secret is
+// only returned in this response.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func CreateTokenHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	var request struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Bad Request",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	secret, token, err := auth.CreateToken(request.Name, request.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    err.Error(),
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	utils.LogInternalEvent("Token created", fmt.Sprintf("Token: %s, Scopes: %v", token.Name, token.Scopes))
+	c.JSON(http.StatusCreated, gin.H{
+		"token":    token,
+		"secret":   secret,
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// DeleteTokenHandler is a handler for DELETE /api/tokens/:id
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func DeleteTokenHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	err := auth.DeleteToken(c.Param("id"))
+	if errors.Is(err, auth.ErrTokenNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":    "Not Found",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+	if err != nil {
+		utils.LogInternalError("Error deleting token", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	utils.LogInternalEvent("Token deleted", fmt.Sprintf("Token ID: %s", c.Param("id")))
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Token deleted",
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// setSessionCookie writes the login cookie. A negative maxAge removes it.
+//
+// Args:
+// c (gin.Context): Gin context
+// secret (string): The token secret
+// maxAge (int): The lifetime of the cookie in seconds
+//
+// Returns:
+// None
+func setSessionCookie(c *gin.Context, secret string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     auth.CookieName,
+		Value:    secret,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"ponder/pkg/generate"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GenerateHandler is a handler for POST /api/generate and
+// POST /api/projects/:name/generate
+//
+// The generation runs as a background job. The response holds the job ID to
+// poll with GET /api/jobs/:id while GET /api/ping reports its progress.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func GenerateHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	jobID, err := generate.Start(project)
+	if errors.Is(err, generate.ErrGenerationRunning) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    "Generation already running",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "Generation started",
+		"job":      jobID,
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// CancelGenerateHandler is a handler for DELETE /api/generate and
+// DELETE /api/projects/:name/generate
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func CancelGenerateHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	jobID, err := generate.Cancel(project)
+	if errors.Is(err, generate.ErrGenerationNotRunning) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    "No generation running",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "Generation cancelled",
+		"job":      jobID,
+		"duration": time.Since(startTime).String(),
+	})
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"ponder/pkg/generate"
+	"ponder/pkg/generations"
+	"ponder/pkg/metrics"
+	"ponder/pkg/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GenerationsHandler is a handler for GET /api/generations and
+// GET /api/projects/:name/generations
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func GenerationsHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	list, err := generations.List(project)
+	if err != nil {
+		utils.LogInternalError("Error listing generations", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"generations": list,
+		"duration":    time.Since(startTime).String(),
+	})
+}
+
+// RollbackHandler is a handler for POST /api/generations/:id/rollback and
+// POST /api/projects/:name/generations/:id/rollback
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func RollbackHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Bad Request",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	err = generate.Rollback(project, id)
+	if errors.Is(err, generations.ErrGenerationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":    "Not Found",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+	if errors.Is(err, generate.ErrGenerationRunning) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    "Generation already running",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+	if err != nil {
+		utils.LogInternalError("Error rolling back generation", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Generation published",
+		"id":       id,
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// errDiffLimit stops a diff once the requested number of lines is written
+var errDiffLimit = errors.New("diff limit reached")
+
+// GenerationDiffHandler is a handler for GET /api/generations/:id/diff and
+// GET /api/projects/:name/generations/:id/diff
+//
+// The optional "from" value is the generation to compare against and
+// defaults to the previous generation. "kind" selects new, dropped, moved, or
+// all changes and defaults to new, "min-shift" is the number of ranks a line
+// has to move to count as moved, and "n" limits the number of lines. With
+// "details=true" every line is written as "kind<TAB>old rank<TAB>new
+// rank<TAB>line" instead of the bare line.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func GenerationDiffHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	badRequest := func() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Bad Request",
+			"duration": time.Since(startTime).String(),
+		})
+	}
+	notFound := func() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":    "Not Found",
+			"duration": time.Since(startTime).String(),
+		})
+	}
+
+	toID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		badRequest()
+		return
+	}
+
+	var fromID int
+	if c.Query("from") != "" {
+		fromID, err = strconv.Atoi(c.Query("from"))
+		if err != nil {
+			badRequest()
+			return
+		}
+	} else {
+		previous, err := generations.Previous(project, toID)
+		if err != nil {
+			notFound()
+			return
+		}
+		fromID = previous.ID
+	}
+
+	kind := c.DefaultQuery("kind", generations.ChangeNew)
+	switch kind {
+	case generations.ChangeNew, generations.ChangeDropped, generations.ChangeMoved, generations.ChangeAll:
+	default:
+		badRequest()
+		return
+	}
+
+	minShift := generations.DefaultMinShift
+	if c.Query("min-shift") != "" {
+		minShift, err = strconv.Atoi(c.Query("min-shift"))
+		if err != nil || minShift < 1 {
+			badRequest()
+			return
+		}
+	}
+
+	limit := 0
+	if c.Query("n") != "" {
+		limit, err = strconv.Atoi(c.Query("n"))
+		if err != nil || limit < 1 {
+			badRequest()
+			return
+		}
+	}
+	details := c.Query("details") == "true"
+
+	for _, id := range []int{fromID, toID} {
+		if _, err := generations.Get(project, id); err != nil {
+			notFound()
+			return
+		}
+	}
+
+	// The response is only started once the first change is known, so
+	// errors before then can still be reported as JSON
+	var writer *bufio.Writer
+	written := 0
+	err = generations.Diff(c.Request.Context(), project, fromID, toID, kind, minShift, func(change generations.Change) error {
+		if writer == nil {
+			c.Header("Content-Type", "text/plain")
+			c.Status(http.StatusOK)
+			writer = bufio.NewWriter(c.Writer)
+		}
+
+		line := change.Line
+		if project.Config.Characters.HexEncodeOutput {
+			line = hexEncodeDownloadLine(line, false)
+		}
+		if details {
+			line = fmt.Sprintf("%s\t%d\t%d\t%s", change.Kind, change.OldRank, change.NewRank, line)
+		}
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			return err
+		}
+
+		written++
+		if limit > 0 && written >= limit {
+			return errDiffLimit
+		}
+		return nil
+	})
+	if writer != nil {
+		writer.Flush()
+		metrics.DownloadBytes.With("diff").Add(float64(c.Writer.Size()))
+	}
+	if err != nil && !errors.Is(err, errDiffLimit) {
+		utils.LogInternalError("Error comparing generations", fmt.Sprintf("Project: %s, From: %d, To: %d, Error: %v", project.Name, fromID, toID, err))
+		if writer == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":    "Internal Server Error",
+				"duration": time.Since(startTime).String(),
+			})
+		}
+		return
+	}
+	if writer == nil {
+		c.Header("Content-Type", "text/plain")
+		c.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,709 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"ponder/pkg/dedup"
+	"ponder/pkg/generate"
+	"ponder/pkg/ingest"
+	"ponder/pkg/jobs"
+	"ponder/pkg/models"
+	"ponder/pkg/sources"
+	"ponder/pkg/state"
+	"ponder/pkg/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobsHandler is a handler for GET /api/jobs
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func JobsHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":     jobs.List(),
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// JobHandler is a handler for GET /api/jobs/:id
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func JobHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	status, err := jobs.Get(c.Param("id"))
+	if errors.Is(err, jobs.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":    "Not Found",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job":      status,
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// enqueuePendingJob records an upload or import as pending and queues it. The
+// record is removed once the job finishes, so a job interrupted by a restart
+// is queued again by ResumePendingJobs. A new record is removed again when
+// the queue is full, while the record of a resumed job is kept for the next
+// restart.
+//
+// Args:
+// project (*models.Project): The project the job belongs to
+// pending (state.PendingJob): The job to queue, with the ID of its record if
+// it is being resumed
+//
+// Returns:
+// (*jobs.Job): The queued job
+// (error): jobs.ErrQueueFull if the queue has no free slot
+func enqueuePendingJob(project *models.Project, pending state.PendingJob) (*jobs.Job, error) {
+	resumed := pending.ID != ""
+	if !resumed {
+		id, err := state.AddPendingJob(project, pending)
+		if err != nil {
+			utils.LogInternalError("Error recording pending job", fmt.Sprintf("Project: %s, Kind: %s, Error: %v", project.Name, pending.Kind, err))
+		}
+		pending.ID = id
+	}
+
+	job, err := jobs.Enqueue(pending.Kind, project.Name, pending.Size, func(job *jobs.Job) error {
+		defer func() {
+			if pending.ID == "" {
+				return
+			}
+			if err := state.RemovePendingJob(project, pending.ID); err != nil {
+				utils.LogInternalError("Error removing pending job", fmt.Sprintf("Project: %s, Kind: %s, Error: %v", project.Name, pending.Kind, err))
+			}
+		}()
+
+		if pending.Kind == jobs.KindImport {
+			return processImport(project, pending, job)
+		}
+		return processUpload(project, pending, job)
+	})
+	if err != nil && !resumed && pending.ID != "" {
+		if removeErr := state.RemovePendingJob(project, pending.ID); removeErr != nil {
+			utils.LogInternalError("Error removing pending job", fmt.Sprintf("Project: %s, Kind: %s, Error: %v", project.Name, pending.Kind, removeErr))
+		}
+	}
+	return job, err
+}
+
+// ResumePendingJobs queues the uploads and imports and restarts the
+// generations that were unfinished when the server stopped.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func ResumePendingJobs() {
+	for _, project := range models.ListProjects() {
+		pendingJobs, err := state.PendingJobs(project)
+		if err != nil {
+			utils.LogInternalError("Error reading pending jobs", fmt.Sprintf("Project: %s, Error: %v", project.Name, err))
+			continue
+		}
+
+		// Removals and appends cut short by the restart are completed or
+		// rolled back before anything is queued, so no other job appends
+		// after them first
+		project.SourceMu.Lock()
+		if err := completeSourceRemoval(project); err != nil {
+			utils.LogInternalError("Error completing source removal", fmt.Sprintf("Project: %s, Error: %v", project.Name, err))
+		}
+		for i := range pendingJobs {
+			if pendingJobs[i].Checkpoint != nil {
+				rollBackInterruptedAppend(project, &pendingJobs[i])
+			}
+		}
+		project.SourceMu.Unlock()
+
+		for _, pending := range pendingJobs {
+			utils.LogEvent(models.LogLevelInfo, "Resuming pending job", fmt.Sprintf("Project: %s, Kind: %s, Queued: %v", project.Name, pending.Kind, pending.Queued), models.LogFields{
+				"project": project.Name,
+				"kind":    pending.Kind,
+			})
+
+			if pending.Kind != jobs.KindGenerate {
+				if _, err := enqueuePendingJob(project, pending); err != nil {
+					utils.LogInternalError("Error resuming pending job", fmt.Sprintf("Project: %s, Kind: %s, Error: %v", project.Name, pending.Kind, err))
+				}
+				continue
+			}
+
+			// The generation records itself as pending again once started
+			if err := state.RemovePendingJob(project, pending.ID); err != nil {
+				utils.LogInternalError("Error removing pending job", fmt.Sprintf("Project: %s, Kind: %s, Error: %v", project.Name, pending.Kind, err))
+			}
+			if _, err := generate.Start(project); err != nil && !errors.Is(err, generate.ErrGenerationRunning) {
+				utils.LogInternalError("Error resuming generation", fmt.Sprintf("Project: %s, Error: %v", project.Name, err))
+			}
+		}
+	}
+}
+
+// stageUpload copies an uploaded file into the staging directory of the
+// project so it outlives the request.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// file (io.Reader): The uploaded file
+//
+// Returns:
+// string: The path to the staged file
+// int64: The size of the staged file
+// error: An error if one occurred
+func stageUpload(project *models.Project, file io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(project.StagingDirectory, 0755); err != nil {
+		return "", 0, fmt.Errorf("error creating staging directory: %w", err)
+	}
+
+	staged, err := os.CreateTemp(project.StagingDirectory, "upload-")
+	if err != nil {
+		return "", 0, fmt.Errorf("error creating staged file: %w", err)
+	}
+
+	size, err := io.Copy(staged, file)
+	if closeErr := staged.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(staged.Name())
+		return "", 0, fmt.Errorf("error writing staged file: %w", err)
+	}
+
+	return staged.Name(), size, nil
+}
+
+// processUpload appends a staged upload to the source wordlist of the project,
+// records it as a source, and removes the staged file. The pending record of
+// the upload is removed as soon as the append is complete, before the staged
+// file, so the upload is never appended twice.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// pending (state.PendingJob): The staged file, its format, and its provenance
+// job (*jobs.Job): The job tracking the progress
+//
+// Returns:
+// error: An error if one occurred
+func processUpload(project *models.Project, pending state.PendingJob, job *jobs.Job) error {
+	startTime := time.Now()
+	stagedPath := pending.StagedPath
+	defer os.Remove(stagedPath)
+
+	project.SourceMu.Lock()
+	defer project.SourceMu.Unlock()
+
+	sum, err := sources.HashFile(stagedPath)
+	if err != nil {
+		utils.LogInternalError("Error hashing file in upload job", err.Error())
+		return err
+	}
+
+	// Jobs queued before sources were recorded have no name
+	name := pending.Name
+	if name == "" {
+		name = filepath.Base(stagedPath)
+	}
+	// Jobs queued before keys were recorded are keyed by the name
+	if pending.Key == "" {
+		pending.Key = name
+	}
+
+	format := ingest.Format{Name: pending.Format, Fields: pending.FormatFields}
+	opts := ingestOptions(project, format)
+	opts.Stats = job.Stats()
+	filter, err := openDedupFilter(project, &opts)
+	if err != nil {
+		utils.LogInternalError("Error opening dedup filter in upload job", err.Error())
+		return err
+	}
+	if filter != nil {
+		defer filter.Close()
+		filter.SetSource(pending.Key)
+	}
+	checkpoint, err := beginAppend(project, &pending)
+	if err != nil {
+		utils.LogInternalError("Error recording checkpoint in upload job", err.Error())
+		return err
+	}
+	if err := appendFileToWordlist(stagedPath, project.SourceWordlist, opts); err != nil {
+		utils.LogInternalError("Error appending file to wordlist in upload job", err.Error())
+		rollBackAppend(project, checkpoint)
+		return err
+	}
+	if err := ingest.FlushAnalyzers(opts.Analyzers); err != nil {
+		utils.LogInternalError("Error writing upload analysis in upload job", err.Error())
+	}
+	recordSource(project, pending, name, pending.Key, sum, job, ingestCounts{}, checkpoint)
+	if pending.ID != "" {
+		if err := state.RemovePendingJob(project, pending.ID); err != nil {
+			utils.LogInternalError("Error removing pending job", fmt.Sprintf("Project: %s, Kind: %s, Error: %v", project.Name, pending.Kind, err))
+		}
+	}
+	commitDedupFilter(project, filter)
+
+	project.SetLastUploaded(time.Now())
+	if err := state.Save(project); err != nil {
+		utils.LogInternalError("Error saving project state", fmt.Sprintf("Project: %s, Error: %v", project.Name, err))
+	}
+	duration := time.Since(startTime).String()
+	utils.LogEvent(models.LogLevelInfo, "File uploaded successfully", fmt.Sprintf("Project: %s, Job: %s, Duration: %s, Known lines: %d", project.Name, job.ID(), duration, opts.Stats.LinesKnown.Load()), models.LogFields{
+		"project":     project.Name,
+		"job":         job.ID(),
+		"duration":    duration,
+		"lines_known": opts.Stats.LinesKnown.Load(),
+	})
+	return nil
+}
+
+// processImport appends every file in the import directory of the project to
+// its source wordlist, records each file as a source, and removes the
+// imported files. Every file is marked as imported in the pending record of
+// the import before it is removed, so a resumed import skips it.
+//
+// Args:
+// project (*models.Project): The project receiving the import
+// pending (state.PendingJob): The format and provenance of the files
+// job (*jobs.Job): The job tracking the progress
+//
+// Returns:
+// error: An error if one occurred
+func processImport(project *models.Project, pending state.PendingJob, job *jobs.Job) error {
+	startTime := time.Now()
+
+	project.SourceMu.Lock()
+	defer project.SourceMu.Unlock()
+
+	files, err := os.ReadDir(project.ImportDirectory)
+	if err != nil {
+		utils.LogInternalError("Error reading import directory", err.Error())
+		return err
+	}
+
+	format := ingest.Format{Name: pending.Format, Fields: pending.FormatFields}
+	opts := ingestOptions(project, format)
+	opts.Stats = job.Stats()
+	filter, err := openDedupFilter(project, &opts)
+	if err != nil {
+		utils.LogInternalError("Error opening dedup filter in import job", err.Error())
+		return err
+	}
+	// The files that were already appended are kept when a later file fails
+	// and only the data of the failed file is rolled back. The filter keeps
+	// the candidates of every file once it has been appended.
+	if filter != nil {
+		defer filter.Close()
+	}
+	imported := false
+	defer func() {
+		if imported {
+			project.SetLastUploaded(time.Now())
+			if err := state.Save(project); err != nil {
+				utils.LogInternalError("Error saving project state", fmt.Sprintf("Project: %s, Error: %v", project.Name, err))
+			}
+		}
+	}()
+
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
+		filePath := fmt.Sprintf("%s/%s", project.ImportDirectory, file.Name())
+		if slices.Contains(pending.Imported, file.Name()) {
+			// The file was appended before a restart but not removed
+			if err := os.Remove(filePath); err != nil {
+				utils.LogInternalError("Error removing file after import", err.Error())
+				return err
+			}
+			continue
+		}
+
+		sum, err := sources.HashFile(filePath)
+		if err != nil {
+			utils.LogInternalError("Error hashing file in import job", err.Error())
+			return err
+		}
+		if filter != nil {
+			filter.SetSource(file.Name())
+		}
+		before := countsOf(opts.Stats)
+		checkpoint, err := beginAppend(project, &pending)
+		if err != nil {
+			utils.LogInternalError("Error recording checkpoint in import job", err.Error())
+			return err
+		}
+		if err := appendFileToWordlist(filePath, project.SourceWordlist, opts); err != nil {
+			utils.LogInternalError("Error appending file to wordlist in import job", err.Error())
+			rollBackAppend(project, checkpoint)
+			return err
+		}
+		imported = true
+		if err := ingest.FlushAnalyzers(opts.Analyzers); err != nil {
+			utils.LogInternalError("Error writing upload analysis in import job", err.Error())
+		}
+		recordSource(project, pending, file.Name(), file.Name(), sum, job, before, checkpoint)
+
+		pending.Checkpoint = nil
+		pending.Imported = append(pending.Imported, file.Name())
+		if pending.ID != "" {
+			if err := state.UpdatePendingJob(project, pending); err != nil {
+				utils.LogInternalError("Error updating pending job", fmt.Sprintf("Project: %s, Kind: %s, Error: %v", project.Name, pending.Kind, err))
+				return err
+			}
+		}
+		commitDedupFilter(project, filter)
+
+		// Remove the file after processing
+		if err := os.Remove(filePath); err != nil {
+			utils.LogInternalError("Error removing file after import", err.Error())
+			return err
+		}
+	}
+
+	duration := time.Since(startTime).String()
+	utils.LogEvent(models.LogLevelInfo, "Files imported successfully", fmt.Sprintf("Project: %s, Job: %s, Duration: %s, Known lines: %d", project.Name, job.ID(), duration, opts.Stats.LinesKnown.Load()), models.LogFields{
+		"project":     project.Name,
+		"job":         job.ID(),
+		"duration":    duration,
+		"lines_known": opts.Stats.LinesKnown.Load(),
+	})
+	return nil
+}
+
+// beginAppend records the sizes of the files an ingest appends to in the
+// pending record of the job, so the append can be rolled back if it fails or
+// the server stops before it completes. The SourceMu of the project must be
+// held until the append is complete.
+//
+// Args:
+// project (*models.Project): The project receiving the data
+// pending (*state.PendingJob): The job, which is given the checkpoint
+//
+// Returns:
+// (state.Checkpoint): The checkpoint
+// error: An error if one occurred
+func beginAppend(project *models.Project, pending *state.PendingJob) (state.Checkpoint, error) {
+	var checkpoint state.Checkpoint
+	// A removal relies on the sizes of the files it cuts, so it is completed
+	// before anything is appended
+	if err := completeSourceRemoval(project); err != nil {
+		return checkpoint, err
+	}
+
+	var err error
+	if checkpoint.SourceSize, err = fileSize(project.SourceWordlist); err != nil {
+		return checkpoint, err
+	}
+	if checkpoint.RulesSize, err = fileSize(project.RulesSource); err != nil {
+		return checkpoint, err
+	}
+	if checkpoint.MasksSize, err = fileSize(project.MasksSource); err != nil {
+		return checkpoint, err
+	}
+	list, err := sources.List(project)
+	if err != nil {
+		return checkpoint, err
+	}
+	checkpoint.Sources = len(list)
+
+	// A job without a pending record cannot be resumed, so it needs no
+	// checkpoint on disk
+	if pending.ID == "" {
+		return checkpoint, nil
+	}
+	pending.Checkpoint = &checkpoint
+	return checkpoint, state.UpdatePendingJob(project, *pending)
+}
+
+// completeSourceRemoval completes a source removal of the project that was
+// interrupted by a restart or failed part way. The SourceMu of the project
+// must be held.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// error: An error if one occurred
+func completeSourceRemoval(project *models.Project) error {
+	source, err := sources.CompleteRemoval(project)
+	if err != nil || source.ID == "" {
+		return err
+	}
+
+	utils.LogEvent(models.LogLevelWarn, "Source removal completed", fmt.Sprintf("Project: %s, Source: %s, File: %s, Bytes: %d.", project.Name, source.ID, source.Name, source.Length), models.LogFields{
+		"project": project.Name,
+		"source":  source.ID,
+		"file":    source.Name,
+		"bytes":   source.Length,
+	})
+	return nil
+}
+
+// rollBackAppend cuts the source wordlist and the collected rules and masks
+// back to a checkpoint and forgets the sources recorded since. A failure is
+// logged, the data that was appended is then kept. The SourceMu of the
+// project must be held.
+//
+// Args:
+// project (*models.Project): The project
+// checkpoint (state.Checkpoint): The checkpoint taken before the append
+//
+// Returns:
+// error: An error if one occurred
+func rollBackAppend(project *models.Project, checkpoint state.Checkpoint) error {
+	err := truncateFile(project.SourceWordlist, checkpoint.SourceSize)
+	if err == nil {
+		err = truncateFile(project.RulesSource, checkpoint.RulesSize)
+	}
+	if err == nil {
+		err = truncateFile(project.MasksSource, checkpoint.MasksSize)
+	}
+	if err == nil {
+		_, err = sources.Truncate(project, checkpoint.Sources)
+	}
+	if err != nil {
+		utils.LogInternalError("Error rolling back append", fmt.Sprintf("Project: %s, Offset: %d, Error: %v", project.Name, checkpoint.SourceSize, err))
+	}
+	return err
+}
+
+// rollBackInterruptedAppend rolls back the append of a pending job that was
+// interrupted by a restart and clears its checkpoint, so the job starts over
+// from the original data. An upload whose staged file is gone had completed
+// its append and is left alone. The SourceMu of the project must be held.
+//
+// Args:
+// project (*models.Project): The project
+// pending (*state.PendingJob): The job with a checkpoint
+//
+// Returns:
+// None
+func rollBackInterruptedAppend(project *models.Project, pending *state.PendingJob) {
+	checkpoint := *pending.Checkpoint
+	if pending.Kind == jobs.KindUpload {
+		if _, err := os.Stat(pending.StagedPath); os.IsNotExist(err) {
+			return
+		}
+	}
+
+	size, err := fileSize(project.SourceWordlist)
+	if err != nil {
+		utils.LogInternalError("Error rolling back append", fmt.Sprintf("Project: %s, Error: %v", project.Name, err))
+		return
+	}
+	if err := rollBackAppend(project, checkpoint); err != nil {
+		return
+	}
+	utils.LogEvent(models.LogLevelWarn, "Interrupted append rolled back", fmt.Sprintf("Project: %s, Kind: %s, Offset: %d, Bytes: %d", project.Name, pending.Kind, checkpoint.SourceSize, size-checkpoint.SourceSize), models.LogFields{
+		"project": project.Name,
+		"kind":    pending.Kind,
+		"offset":  checkpoint.SourceSize,
+		"bytes":   size - checkpoint.SourceSize,
+	})
+
+	pending.Checkpoint = nil
+	if err := state.UpdatePendingJob(project, *pending); err != nil {
+		utils.LogInternalError("Error updating pending job", fmt.Sprintf("Project: %s, Kind: %s, Error: %v", project.Name, pending.Kind, err))
+	}
+}
+
+// fileSize returns the size of a file or 0 if it does not exist.
+//
+// Args:
+// path (string): The path to the file
+//
+// Returns:
+// int64: The size in bytes
+// error: An error if one occurred
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// truncateFile cuts a file back to a size if it has grown past it.
+//
+// Args:
+// path (string): The path to the file
+// size (int64): The size to cut the file back to
+//
+// Returns:
+// error: An error if one occurred
+func truncateFile(path string, size int64) error {
+	current, err := fileSize(path)
+	if err != nil || current <= size {
+		return err
+	}
+	return os.Truncate(path, size)
+}
+
+// openDedupFilter opens the dedup filter of a project if the dedup stage is
+// enabled and hands it to the ingest options. The SourceMu of the project
+// must be held.
+//
+// Args:
+// project (*models.Project): The project receiving the data
+// opts (*ingest.Options): The ingest options
+//
+// Returns:
+// (*dedup.Filter): The filter, which must be closed, or nil if the dedup
+// stage is disabled
+// error: An error if one occurred
+func openDedupFilter(project *models.Project, opts *ingest.Options) (*dedup.Filter, error) {
+	if !project.Config.Dedup.Enabled {
+		return nil, nil
+	}
+
+	filter, err := dedup.Open(project)
+	if err != nil {
+		return nil, err
+	}
+	opts.Known = filter
+	opts.SkipKnown = project.Config.Dedup.Mode == models.DedupIgnore
+	return filter, nil
+}
+
+// commitDedupFilter keeps the candidates added to the dedup filter once they
+// have been appended to the source wordlist and the ingest can no longer be
+// rolled back. A failure only means later repeats of these candidates are not
+// recognized, so it is logged and the ingest still succeeds.
+//
+// Args:
+// project (*models.Project): The project receiving the data
+// filter (*dedup.Filter): The filter or nil if the dedup stage is disabled
+//
+// Returns:
+// None
+func commitDedupFilter(project *models.Project, filter *dedup.Filter) {
+	if filter == nil {
+		return
+	}
+	if err := filter.Commit(); err != nil {
+		utils.LogInternalError("Error saving dedup filter", fmt.Sprintf("Project: %s, Error: %v", project.Name, err))
+	}
+}
+
+// ingestCounts is a snapshot of the counters of an ingest
+type ingestCounts struct {
+	linesAccepted     int64
+	linesRejected     int64
+	linesKnown        int64
+	candidatesWritten int64
+	bytesWritten      int64
+}
+
+// countsOf takes a snapshot of the counters of an ingest, so the share of one
+// file of a job can be told apart.
+//
+// Args:
+// stats (*ingest.Stats): The counters
+//
+// Returns:
+// (ingestCounts): The snapshot
+func countsOf(stats *ingest.Stats) ingestCounts {
+	return ingestCounts{
+		linesAccepted:     stats.LinesAccepted.Load(),
+		linesRejected:     stats.LinesRejected.Load(),
+		linesKnown:        stats.LinesKnown.Load(),
+		candidatesWritten: stats.CandidatesWritten.Load(),
+		bytesWritten:      stats.BytesWritten.Load(),
+	}
+}
+
+// recordSource adds a file that was just appended to the source wordlist to
+// the sources of the project. Its segment is the data appended since the
+// snapshot of the job counters, which ends at the end of the source wordlist
+// because appends are serialized by SourceMu. Its rules and masks are the
+// data appended to the rules and masks sources since the checkpoint, so the
+// analyzers must be flushed first. A failure only loses the provenance of the
+// file, so it is logged and the ingest still succeeds. The SourceMu of the
+// project must be held.
+//
+// Args:
+// project (*models.Project): The project receiving the file
+// pending (state.PendingJob): The job with the uploader and tags
+// name (string): The name of the file
+// key (string): The dedup key of the file
+// sum (string): The SHA-256 checksum of the file
+// job (*jobs.Job): The job that ingested the file
+// before (ingestCounts): The job counters before the file was ingested
+// checkpoint (state.Checkpoint): The checkpoint taken before the file was ingested
+//
+// Returns:
+// None
+func recordSource(project *models.Project, pending state.PendingJob, name string, key string, sum string, job *jobs.Job, before ingestCounts, checkpoint state.Checkpoint) {
+	after := countsOf(job.Stats())
+	info, err := os.Stat(project.SourceWordlist)
+	var rulesSize, masksSize int64
+	if err == nil {
+		rulesSize, err = fileSize(project.RulesSource)
+	}
+	if err == nil {
+		masksSize, err = fileSize(project.MasksSource)
+	}
+	if err != nil {
+		utils.LogInternalError("Error recording source", fmt.Sprintf("Project: %s, File: %s, Error: %v", project.Name, name, err))
+		return
+	}
+
+	length := after.bytesWritten - before.bytesWritten
+	source, err := sources.Add(project, sources.Source{
+		Name:          name,
+		Uploader:      pending.Uploader,
+		Key:           key,
+		SHA256:        sum,
+		Tags:          pending.Tags,
+		Job:           job.ID(),
+		LinesAccepted: after.linesAccepted - before.linesAccepted,
+		LinesRejected: after.linesRejected - before.linesRejected,
+		LinesKnown:    after.linesKnown - before.linesKnown,
+		Candidates:    after.candidatesWritten - before.candidatesWritten,
+		Offset:        info.Size() - length,
+		Length:        length,
+		RulesOffset:   checkpoint.RulesSize,
+		RulesLength:   rulesSize - checkpoint.RulesSize,
+		MasksOffset:   checkpoint.MasksSize,
+		MasksLength:   masksSize - checkpoint.MasksSize,
+	})
+	if err != nil {
+		utils.LogInternalError("Error recording source", fmt.Sprintf("Project: %s, File: %s, Error: %v", project.Name, name, err))
+		return
+	}
+
+	utils.LogEvent(models.LogLevelInfo, "Source recorded", fmt.Sprintf("Project: %s, Source: %s, File: %s, SHA-256: %s", project.Name, source.ID, name, sum), models.LogFields{
+		"project": project.Name,
+		"source":  source.ID,
+		"file":    name,
+		"job":     job.ID(),
+	})
+}
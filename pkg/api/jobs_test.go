@@ -0,0 +1,374 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"ponder/pkg/dedup"
+	"ponder/pkg/generate"
+	"ponder/pkg/jobs"
+	"ponder/pkg/models"
+	"ponder/pkg/sources"
+	"ponder/pkg/state"
+	"ponder/pkg/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testProject returns a project in a new directory whose source wordlist
+// holds one line.
+func testProject(t *testing.T) *models.Project {
+	t.Helper()
+
+	dir := t.TempDir()
+	logFile := models.LogFile
+	models.LogFile = filepath.Join(dir, "log.txt")
+	sourceDirectory := models.SourceDirectory
+	models.SourceDirectory = dir
+	t.Cleanup(func() {
+		models.LogFile = logFile
+		models.SourceDirectory = sourceDirectory
+	})
+
+	project := &models.Project{
+		Name:                 "test",
+		Directory:            dir,
+		ImportDirectory:      filepath.Join(dir, "import"),
+		StagingDirectory:     filepath.Join(dir, "staging"),
+		SourceWordlist:       filepath.Join(dir, "source-wordlist.txt"),
+		GenerationsDirectory: filepath.Join(dir, "generations"),
+		WizardWordlist:       filepath.Join(dir, "wizard-wordlist.txt"),
+		WizardCounts:         filepath.Join(dir, "wizard-wordlist.counts"),
+		WizardOffset:         filepath.Join(dir, "wizard-wordlist.offset"),
+		RulesSource:          filepath.Join(dir, "rules-source.counts"),
+		RulesList:            filepath.Join(dir, "wizard.rule"),
+		RulesCounts:          filepath.Join(dir, "wizard-rule.counts"),
+		MasksSource:          filepath.Join(dir, "masks-source.counts"),
+		MasksList:            filepath.Join(dir, "wizard.hcmask"),
+		MasksCounts:          filepath.Join(dir, "wizard-mask.counts"),
+		DedupFilter:          filepath.Join(dir, "dedup.bloom"),
+		Config:               models.DefaultConfig(),
+		Progress:             &models.GenerationProgress{},
+	}
+	for _, path := range []string{project.ImportDirectory, project.StagingDirectory} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, project.SourceWordlist, "existing\n")
+	return project
+}
+
+// writeFile writes a file or fails the test.
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile reads a file or fails the test.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// appendFile appends to a file or fails the test.
+func appendFile(t *testing.T, path string, data string) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInterruptedUploadIsAppendedOnce(t *testing.T) {
+	project := testProject(t)
+	stagedPath := filepath.Join(project.StagingDirectory, "upload")
+	writeFile(t, stagedPath, "sunshine\ndragonfly\n")
+	rules := readFileOrEmpty(t, project.RulesSource)
+
+	pending := state.PendingJob{Kind: jobs.KindUpload, StagedPath: stagedPath, Format: "raw", Name: "upload.txt"}
+	id, err := state.AddPendingJob(project, pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending.ID = id
+
+	// The server stops after part of the upload and its source were written
+	if _, err := beginAppend(project, &pending); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, project.SourceWordlist, "sunshine\ndrag")
+	appendFile(t, project.RulesSource, "1\t:\n")
+	if _, err := sources.Add(project, sources.Source{Name: "upload.txt", Offset: 9, Length: 13}); err != nil {
+		t.Fatal(err)
+	}
+
+	pendingJobs, err := state.PendingJobs(project)
+	if err != nil || len(pendingJobs) != 1 || pendingJobs[0].Checkpoint == nil {
+		t.Fatalf("got pending jobs %+v and error %v, want one with a checkpoint", pendingJobs, err)
+	}
+	rollBackInterruptedAppend(project, &pendingJobs[0])
+	if got := readFile(t, project.SourceWordlist); got != "existing\n" {
+		t.Fatalf("source wordlist after rollback is %q", got)
+	}
+	if got := readFileOrEmpty(t, project.RulesSource); got != rules {
+		t.Errorf("collected rules after rollback are %q, want %q", got, rules)
+	}
+
+	job := jobs.Run(jobs.KindUpload, project.Name, func(job *jobs.Job) error {
+		return processUpload(project, pendingJobs[0], job)
+	})
+	if status := job.Status(); status.State != jobs.StateCompleted {
+		t.Fatalf("upload job %s: %s", status.State, status.Error)
+	}
+
+	if got := readFile(t, project.SourceWordlist); got != "existing\nsunshine\ndragonfly\n" {
+		t.Errorf("source wordlist is %q", got)
+	}
+	list, err := sources.List(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Offset != 9 || list[0].Length != 19 {
+		t.Errorf("got sources %+v, want one of 19 bytes at offset 9", list)
+	}
+	if pendingJobs, err := state.PendingJobs(project); err != nil || len(pendingJobs) != 0 {
+		t.Errorf("got pending jobs %+v and error %v, want none", pendingJobs, err)
+	}
+}
+
+func TestResumedImportSkipsImportedFiles(t *testing.T) {
+	project := testProject(t)
+	writeFile(t, filepath.Join(project.ImportDirectory, "first.txt"), "sunshine\n")
+	writeFile(t, filepath.Join(project.ImportDirectory, "second.txt"), "dragonfly\n")
+
+	// first.txt was appended before the restart but not removed yet
+	pending := state.PendingJob{Kind: jobs.KindImport, Format: "raw", Imported: []string{"first.txt"}}
+	job := jobs.Run(jobs.KindImport, project.Name, func(job *jobs.Job) error {
+		return processImport(project, pending, job)
+	})
+	if status := job.Status(); status.State != jobs.StateCompleted {
+		t.Fatalf("import job %s: %s", status.State, status.Error)
+	}
+
+	if got := readFile(t, project.SourceWordlist); got != "existing\ndragonfly\n" {
+		t.Errorf("source wordlist is %q", got)
+	}
+	entries, err := os.ReadDir(project.ImportDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d files were left in the import directory", len(entries))
+	}
+}
+
+func TestUploadRecordsRulesAndMasksSegments(t *testing.T) {
+	project := testProject(t)
+	writeFile(t, project.RulesSource, "1\t:\n")
+	stagedPath := filepath.Join(project.StagingDirectory, "upload")
+	writeFile(t, stagedPath, "Sunshine1\ndragonfly\n")
+
+	pending := state.PendingJob{Kind: jobs.KindUpload, StagedPath: stagedPath, Format: "raw", Name: "upload.txt"}
+	job := jobs.Run(jobs.KindUpload, project.Name, func(job *jobs.Job) error {
+		return processUpload(project, pending, job)
+	})
+	if status := job.Status(); status.State != jobs.StateCompleted {
+		t.Fatalf("upload job %s: %s", status.State, status.Error)
+	}
+
+	list, err := sources.List(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := readFile(t, project.RulesSource)
+	masks := readFile(t, project.MasksSource)
+	if len(list) != 1 || list[0].RulesOffset != 4 || list[0].RulesLength != int64(len(rules))-4 || list[0].MasksOffset != 0 || list[0].MasksLength != int64(len(masks)) {
+		t.Errorf("got sources %+v for %d bytes of rules and %d bytes of masks", list, len(rules), len(masks))
+	}
+	if list[0].RulesLength == 0 || list[0].MasksLength == 0 {
+		t.Errorf("the upload collected no rules or masks: %+v", list)
+	}
+}
+
+func TestRemovingSourceAfterGenerationRemovesItsRulesAndMasks(t *testing.T) {
+	project := testProject(t)
+	uploadFile(t, project, "first.txt", "Sunshine1\np@ssword\n")
+	rules := readFileOrEmpty(t, project.RulesSource)
+	masks := readFileOrEmpty(t, project.MasksSource)
+	uploadFile(t, project, "second.txt", "dragonfly2023\n")
+	secondRules := strings.TrimPrefix(readFile(t, project.RulesSource), rules)
+	secondMasks := strings.TrimPrefix(readFile(t, project.MasksSource), masks)
+	if rules == "" || masks == "" || secondRules == "" || secondMasks == "" {
+		t.Fatalf("the uploads collected rules %q and %q, masks %q and %q", rules, secondRules, masks, secondMasks)
+	}
+
+	if err := generate.GenerateProject(context.Background(), project); err != nil {
+		t.Fatal(err)
+	}
+	list, err := sources.List(project)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("got sources %+v and error %v, want two", list, err)
+	}
+	if _, err := generate.RemoveSource(project, list[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := generate.GenerateProject(context.Background(), project); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, project.RulesSource); got != secondRules {
+		t.Errorf("rules source is %q, want %q", got, secondRules)
+	}
+	if got := readFile(t, project.MasksSource); got != secondMasks {
+		t.Errorf("masks source is %q, want %q", got, secondMasks)
+	}
+	for _, tc := range []struct {
+		countsPATH string
+		collected  string
+	}{
+		{project.RulesCounts, secondRules},
+		{project.MasksCounts, secondMasks},
+	} {
+		if got, want := countedKeys(t, readFile(t, tc.countsPATH)), countedKeys(t, tc.collected); !slices.Equal(got, want) {
+			t.Errorf("%s ranks %q, want %q", tc.countsPATH, got, want)
+		}
+	}
+}
+
+// uploadFile stages a file and appends it as an upload or fails the test.
+func uploadFile(t *testing.T, project *models.Project, name string, data string) {
+	t.Helper()
+
+	uploadKeyed(t, project, name, "", data)
+}
+
+// countedKeys returns the sorted keys of "count\tkey" records and fails the
+// test on a broken record.
+func countedKeys(t *testing.T, records string) []string {
+	t.Helper()
+
+	var keys []string
+	for _, record := range strings.Split(strings.TrimSuffix(records, "\n"), "\n") {
+		count, key, ok := strings.Cut(record, "\t")
+		if _, err := strconv.Atoi(count); !ok || err != nil {
+			t.Fatalf("broken record %q", record)
+		}
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+func TestLinesOfMaxLineLengthPassTheWholePipeline(t *testing.T) {
+	project := testProject(t)
+	project.Config.Pipeline.LengthRange.Max = utils.MaxLineLength
+	long := strings.Repeat("dragonfly", utils.MaxLineLength/len("dragonfly")+1)[:utils.MaxLineLength]
+	uploadFile(t, project, "long.txt", "sunshine\n"+long+"\nmeadowlark\n")
+	if got := readFile(t, project.SourceWordlist); !strings.Contains(got, "\n"+long+"\n") {
+		t.Fatal("the line of MaxLineLength was not appended to the source wordlist")
+	}
+
+	if err := generate.GenerateProject(context.Background(), project); err != nil {
+		t.Fatal(err)
+	}
+	wordlist := strings.Split(readFile(t, project.WizardWordlist), "\n")
+	for _, want := range []string{"sunshine", long, "meadowlark"} {
+		if !slices.Contains(wordlist, want) {
+			t.Errorf("a line of %d bytes is missing from the wizard wordlist", len(want))
+		}
+	}
+	counts, err := utils.GetFirstNCountedLines(project.WizardCounts, 10, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(counts, long) {
+		t.Errorf("the line of MaxLineLength is missing from the counts file")
+	}
+}
+
+func TestIgnoreModeOnlyDropsRepeatsOfTheSameSource(t *testing.T) {
+	project := testProject(t)
+	project.Config.Dedup = models.DedupConfig{Enabled: true, Mode: models.DedupIgnore, ExpectedLines: 1000, FalsePositiveRate: 0.001}
+
+	uploadKeyed(t, project, "rockyou.txt", "", "sunshine\ndragonfly\n")
+	uploadKeyed(t, project, "copy.txt", "rockyou.txt", "sunshine\ndragonfly\n")
+	if got := readFile(t, project.SourceWordlist); got != "existing\nsunshine\ndragonfly\n" {
+		t.Errorf("source wordlist after uploading the same source twice is %q", got)
+	}
+
+	uploadKeyed(t, project, "second.txt", "", "sunshine\nmeadowlark\n")
+	if got := readFile(t, project.SourceWordlist); got != "existing\nsunshine\ndragonfly\nsunshine\nmeadowlark\n" {
+		t.Errorf("source wordlist after uploading a different source is %q", got)
+	}
+}
+
+func TestIgnoreModeOnlyAppendsNewLinesOfAGrownFile(t *testing.T) {
+	project := testProject(t)
+	project.Config.Dedup = models.DedupConfig{Enabled: true, Mode: models.DedupIgnore, ExpectedLines: 1000, FalsePositiveRate: 0.001}
+
+	uploadKeyed(t, project, "hashcat.potfile", "", "sunshine\ndragonfly\n")
+	uploadKeyed(t, project, "hashcat.potfile", "", "sunshine\ndragonfly\nmeadowlark\n")
+	if got := readFile(t, project.SourceWordlist); got != "existing\nsunshine\ndragonfly\nmeadowlark\n" {
+		t.Errorf("source wordlist after uploading a grown potfile is %q", got)
+	}
+
+	// A filter built again from the recorded sources keeps their keys
+	if err := dedup.Remove(project); err != nil {
+		t.Fatal(err)
+	}
+	uploadKeyed(t, project, "hashcat.potfile", "", "sunshine\ndragonfly\nmeadowlark\nkestrel\n")
+	if got := readFile(t, project.SourceWordlist); got != "existing\nsunshine\ndragonfly\nmeadowlark\nkestrel\n" {
+		t.Errorf("source wordlist after rebuilding the filter and uploading the potfile again is %q", got)
+	}
+
+	recorded, err := sources.List(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 3 || recorded[2].Key != "hashcat.potfile" || recorded[2].Candidates != 1 {
+		t.Errorf("recorded sources are %+v", recorded)
+	}
+}
+
+// uploadKeyed appends a file to the source wordlist through an upload job
+// with the given dedup key, which defaults to the name of the file.
+func uploadKeyed(t *testing.T, project *models.Project, name string, key string, data string) {
+	t.Helper()
+
+	stagedPath := filepath.Join(project.StagingDirectory, name)
+	writeFile(t, stagedPath, data)
+	pending := state.PendingJob{Kind: jobs.KindUpload, StagedPath: stagedPath, Format: "raw", Name: name, Key: key}
+	job := jobs.Run(jobs.KindUpload, project.Name, func(job *jobs.Job) error {
+		return processUpload(project, pending, job)
+	})
+	if status := job.Status(); status.State != jobs.StateCompleted {
+		t.Fatalf("upload job %s: %s", status.State, status.Error)
+	}
+}
+
+// readFileOrEmpty reads a file that may not exist.
+func readFileOrEmpty(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
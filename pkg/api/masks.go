@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"ponder/pkg/masks"
+	"ponder/pkg/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MasksHandler is a handler for GET /api/masks/:n and
+// GET /api/projects/:name/masks/:n
+//
+// The response is the top n masks ranked by how many uploaded plaintexts they
+// matched as a hashcat .hcmask file. With counts=true every line is
+// "count\tkeyspace\tmask" instead, min-count drops masks that matched fewer
+// plaintexts, and max-keyspace drops masks with a larger keyspace.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func MasksHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	numberofLines, err := strconv.Atoi(c.Param("n"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Bad Request",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	includeCounts := c.Query("counts") == "true"
+	minCount := 0
+	if c.Query("min-count") != "" {
+		minCount, err = strconv.Atoi(c.Query("min-count"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":    "Bad Request",
+				"duration": time.Since(startTime).String(),
+			})
+			return
+		}
+	}
+
+	var maxKeyspace *big.Int
+	if c.Query("max-keyspace") != "" {
+		var valid bool
+		maxKeyspace, valid = new(big.Int).SetString(c.Query("max-keyspace"), 10)
+		if !valid {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":    "Bad Request",
+				"duration": time.Since(startTime).String(),
+			})
+			return
+		}
+	}
+
+	ranked, err := masks.TopMasks(project.MasksCounts, numberofLines, minCount, maxKeyspace)
+	if err != nil {
+		utils.LogInternalError("Error reading masks in masks handler", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	lines := make([]string, 0, len(ranked))
+	for _, mask := range ranked {
+		if includeCounts {
+			lines = append(lines, fmt.Sprintf("%d\t%s\t%s", mask.Count, mask.Keyspace.String(), mask.Mask))
+		} else {
+			lines = append(lines, mask.Mask)
+		}
+	}
+
+	if err := streamLines(c, "masks", lines); err != nil {
+		return
+	}
+
+	utils.LogInternalEvent("Masks downloaded successfully", fmt.Sprintf("Project: %s, Duration: %s", project.Name, time.Since(startTime).String()))
+}
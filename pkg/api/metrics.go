@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"ponder/pkg/metrics"
+	"ponder/pkg/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// init registers the file size gauge, which is read from disk on every scrape
+// instead of being tracked by every writer
+func init() {
+	metrics.NewGaugeFunc("ponder_file_size_bytes", "Size of the source and generated files of each project.", []string{"project", "file"}, func(emit func(value float64, values ...string)) {
+		for _, project := range models.ListProjects() {
+			for _, file := range []struct {
+				name string
+				path string
+			}{
+				{"source", project.SourceWordlist},
+				{"wizard", project.WizardWordlist},
+				{"wizard_counts", project.WizardCounts},
+				{"rules", project.RulesList},
+				{"masks", project.MasksList},
+				{"dedup", project.DedupFilter},
+			} {
+				info, err := os.Stat(file.path)
+				if err != nil {
+					continue
+				}
+				emit(float64(info.Size()), project.Name, file.name)
+			}
+		}
+	})
+}
+
+// MetricsHandler is a handler for GET /metrics
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func MetricsHandler(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metrics.Write(c.Writer)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"ponder/pkg/models"
+	"ponder/pkg/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProjectsHandler is a handler for GET /api/projects
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func ProjectsHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	c.JSON(http.StatusOK, gin.H{
+		"projects": models.ListProjects(),
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// ProjectHandler is a handler for GET /api/projects/:name
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func ProjectHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project":  project,
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// CreateProjectHandler is a handler for POST /api/projects/:name
+//
+// The optional JSON body holds configuration overrides for the project, such
+// as "generation_interval_minutes", "character_policy", or "pipeline".
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func CreateProjectHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	overrides, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Bad Request",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	project, err := models.CreateProject(c.Param("name"), overrides)
+	if errors.Is(err, models.ErrProjectExists) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    err.Error(),
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+	if err != nil {
+		utils.LogInternalError("Error creating project", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    err.Error(),
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	utils.LogInternalEvent("Project created", fmt.Sprintf("Project: %s", project.Name))
+	c.JSON(http.StatusCreated, gin.H{
+		"project":  project,
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// projectFromContext resolves the project named in the route or the default
+// project for the top level routes. A 404 response is written when the named
+// project does not exist.
+//
+// Args:
+// c (gin.Context): Gin context
+// startTime (time.Time): The start of the request used for the duration
+//
+// Returns:
+// *models.Project: The project
+// bool: False if a response has already been written
+func projectFromContext(c *gin.Context, startTime time.Time) (*models.Project, bool) {
+	name := c.Param("name")
+	if name == "" {
+		name = models.DefaultProjectName
+	}
+
+	project, err := models.GetProject(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":    "Not Found",
+			"duration": time.Since(startTime).String(),
+		})
+		return nil, false
+	}
+
+	return project, true
+}
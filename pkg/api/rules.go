@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"ponder/pkg/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RulesHandler is a handler for GET /api/rules/:n and
+// GET /api/projects/:name/rules/:n
+//
+// The response is the top n hashcat rules ranked by how often they were
+// observed in uploads. With counts=true every rule is prefixed by its count
+// and min-count drops rules that were observed fewer times.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func RulesHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	numberofLines, err := strconv.Atoi(c.Param("n"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Bad Request",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	includeCounts := c.Query("counts") == "true"
+	minCount := 0
+	if c.Query("min-count") != "" {
+		minCount, err = strconv.Atoi(c.Query("min-count"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":    "Bad Request",
+				"duration": time.Since(startTime).String(),
+			})
+			return
+		}
+	}
+
+	var lines []string
+	if minCount > 0 || includeCounts {
+		lines, err = utils.GetFirstNCountedLines(project.RulesCounts, numberofLines, minCount, includeCounts)
+	} else {
+		lines, err = utils.GetFirstNLines(project.RulesList, numberofLines)
+	}
+	if err != nil {
+		utils.LogInternalError("Error reading rules in rules handler", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	if err := streamLines(c, "rules", lines); err != nil {
+		return
+	}
+
+	utils.LogInternalEvent("Rules downloaded successfully", fmt.Sprintf("Project: %s, Duration: %s", project.Name, time.Since(startTime).String()))
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"ponder/pkg/generate"
+	"ponder/pkg/sources"
+	"ponder/pkg/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SourcesHandler is a handler for GET /api/sources and
+// GET /api/projects/:name/sources
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func SourcesHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	list, err := sources.List(project)
+	if err != nil {
+		utils.LogInternalError("Error listing sources", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sources":  list,
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// SourceHandler is a handler for GET /api/sources/:id and
+// GET /api/projects/:name/sources/:id
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func SourceHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	source, err := sources.Get(project, c.Param("id"))
+	if errors.Is(err, sources.ErrSourceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":    "Not Found",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+	if err != nil {
+		utils.LogInternalError("Error reading source", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"source":   source,
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// DeleteSourceHandler is a handler for DELETE /api/sources/:id and
+// DELETE /api/projects/:name/sources/:id
+//
+// Removes the data of a source from the source wordlist and starts a full
+// generation without it. The response holds the ID of the generation job.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func DeleteSourceHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	source, err := generate.RemoveSource(project, c.Param("id"))
+	if errors.Is(err, sources.ErrSourceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":    "Not Found",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+	if errors.Is(err, generate.ErrGenerationRunning) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    "Generation already running",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+	if err != nil {
+		utils.LogInternalError("Error removing source", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	// A generation that started in the meantime already sees the reset
+	// offset and rebuilds from the remaining data
+	jobID, err := generate.Start(project)
+	response := gin.H{
+		"message":  "Source removed",
+		"source":   source,
+		"duration": time.Since(startTime).String(),
+	}
+	if err == nil {
+		response["job"] = jobID
+	}
+	c.JSON(http.StatusAccepted, response)
+}
@@ -0,0 +1,377 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"ponder/pkg/jobs"
+	"ponder/pkg/models"
+	"ponder/pkg/state"
+	"ponder/pkg/uploads"
+	"ponder/pkg/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chunkContentType is the content type of the chunks of a resumable upload
+const chunkContentType = "application/offset+octet-stream"
+
+// CreateUploadHandler is a handler for POST /api/uploads and
+// POST /api/projects/:name/uploads
+//
+// Starts a resumable upload of the length given in the Upload-Length header.
+// The data is sent with PATCH requests and handed to the ingest pipeline by
+// FinalizeUploadHandler. The optional "filename" and "tags" values are
+// recorded with the source.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func CreateUploadHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Bad Request",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	format, err := ingestFormatFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    err.Error(),
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	pending := pendingUpload(c, uploadName(c, "resumable upload"), format)
+	upload, err := uploads.Create(project, uploads.Upload{
+		Length:       length,
+		Format:       pending.Format,
+		FormatFields: pending.FormatFields,
+		Name:         pending.Name,
+		Uploader:     pending.Uploader,
+		Tags:         pending.Tags,
+		Key:          pending.Key,
+	})
+	if err != nil {
+		utils.LogInternalError("Error creating resumable upload", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	c.Header("Location", uploadLocation(c, upload.ID))
+	c.Header("Upload-Offset", "0")
+	c.JSON(http.StatusCreated, gin.H{
+		"upload":   upload,
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// UploadOffsetHandler is a handler for HEAD /api/uploads/:id and
+// HEAD /api/projects/:name/uploads/:id
+//
+// Reports how much of a resumable upload has been staged in the
+// Upload-Offset and Upload-Length headers.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func UploadOffsetHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	upload, err := uploads.Get(project, c.Param("id"))
+	if errors.Is(err, uploads.ErrUploadNotFound) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.LogInternalError("Error reading resumable upload", err.Error())
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	setUploadHeaders(c, upload)
+	c.Status(http.StatusOK)
+}
+
+// PatchUploadHandler is a handler for PATCH /api/uploads/:id and
+// PATCH /api/projects/:name/uploads/:id
+//
+// Appends the request body to a resumable upload. The Upload-Offset header
+// must match the staged size, which is returned in the Upload-Offset header
+// of the response.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func PatchUploadHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type")); mediaType != chunkContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":    "Unsupported Media Type",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Bad Request",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	upload, err := uploads.Append(project, c.Param("id"), offset, c.Request.Body)
+	switch {
+	case err == nil:
+		setUploadHeaders(c, upload)
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, uploads.ErrUploadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":    "Not Found",
+			"duration": time.Since(startTime).String(),
+		})
+	case errors.Is(err, uploads.ErrOffsetMismatch):
+		setUploadHeaders(c, upload)
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    "Upload-Offset does not match the staged size",
+			"duration": time.Since(startTime).String(),
+		})
+	case errors.Is(err, uploads.ErrUploadTooLarge):
+		setUploadHeaders(c, upload)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":    "Request Entity Too Large",
+			"duration": time.Since(startTime).String(),
+		})
+	default:
+		// A dropped connection keeps the data received so far, which the
+		// client picks up with a HEAD request
+		utils.LogInternalError("Error staging resumable upload chunk", fmt.Sprintf("Project: %s, Upload: %s, Offset: %d, Error: %v", project.Name, c.Param("id"), upload.Offset, err))
+		setUploadHeaders(c, upload)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+	}
+}
+
+// FinalizeUploadHandler is a handler for POST /api/uploads/:id/finalize and
+// POST /api/projects/:name/uploads/:id/finalize
+//
+// Queues a complete resumable upload for processing like UploadHandler. The
+// upload is kept when the queue is full, so the request can be retried.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func FinalizeUploadHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	if jobs.QueueFull() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":    "Job queue is full",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	upload, stagedPath, err := uploads.Finalize(project, c.Param("id"))
+	switch {
+	case errors.Is(err, uploads.ErrUploadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":    "Not Found",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	case errors.Is(err, uploads.ErrUploadIncomplete):
+		setUploadHeaders(c, upload)
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    "Upload incomplete",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	case err != nil:
+		utils.LogInternalError("Error finalizing resumable upload", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	job, err := enqueuePendingJob(project, state.PendingJob{
+		Kind:         jobs.KindUpload,
+		StagedPath:   stagedPath,
+		Size:         upload.Length,
+		Format:       upload.Format,
+		FormatFields: upload.FormatFields,
+		Name:         upload.Name,
+		Uploader:     upload.Uploader,
+		Tags:         upload.Tags,
+		Key:          upload.Key,
+	})
+	if err != nil {
+		// The queue filled up since the check above, the upload is restored
+		// so finalizing it can be retried
+		if restoreErr := uploads.Restore(project, upload, stagedPath); restoreErr != nil {
+			utils.LogInternalError("Error restoring resumable upload", fmt.Sprintf("Project: %s, Upload: %s, Error: %v", project.Name, upload.ID, restoreErr))
+			os.Remove(stagedPath)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":    "Internal Server Error",
+				"duration": time.Since(startTime).String(),
+			})
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":    "Job queue is full",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	utils.LogEvent(models.LogLevelInfo, "File staged for processing", fmt.Sprintf("Project: %s, Upload: %s, Job: %s", project.Name, upload.ID, job.ID()), models.LogFields{
+		"project": project.Name,
+		"upload":  upload.ID,
+		"job":     job.ID(),
+	})
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "File queued for processing",
+		"job":      job.ID(),
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// DeleteUploadHandler is a handler for DELETE /api/uploads/:id and
+// DELETE /api/projects/:name/uploads/:id
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// None
+func DeleteUploadHandler(c *gin.Context) {
+	startTime := time.Now()
+
+	project, ok := projectFromContext(c, startTime)
+	if !ok {
+		return
+	}
+
+	err := uploads.Abort(project, c.Param("id"))
+	if errors.Is(err, uploads.ErrUploadNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":    "Not Found",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+	if err != nil {
+		utils.LogInternalError("Error removing resumable upload", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Internal Server Error",
+			"duration": time.Since(startTime).String(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Upload removed",
+		"duration": time.Since(startTime).String(),
+	})
+}
+
+// setUploadHeaders writes the offset and length of an upload to the response
+// headers.
+//
+// Args:
+// c (gin.Context): Gin context
+// upload (uploads.Upload): The upload
+//
+// Returns:
+// None
+func setUploadHeaders(c *gin.Context, upload uploads.Upload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+}
+
+// uploadLocation returns the URL of an upload for the Location header.
+//
+// Args:
+// c (gin.Context): Gin context
+// id (string): The ID of the upload
+//
+// Returns:
+// string: The URL path
+func uploadLocation(c *gin.Context, id string) string {
+	if name := c.Param("name"); name != "" {
+		return fmt.Sprintf("/api/projects/%s/uploads/%s", name, id)
+	}
+	return fmt.Sprintf("/api/uploads/%s", id)
+}
+
+// ExpireUploads discards the resumable uploads of every project that have
+// not received data within the upload expiry of the project.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func ExpireUploads() {
+	for _, project := range models.ListProjects() {
+		expired, err := uploads.Expire(project, time.Duration(project.Config.UploadExpiryHours)*time.Hour)
+		if err != nil {
+			utils.LogInternalError("Error expiring resumable uploads", fmt.Sprintf("Project: %s, Error: %v", project.Name, err))
+		}
+		for _, upload := range expired {
+			utils.LogEvent(models.LogLevelInfo, "Resumable upload expired", fmt.Sprintf("Project: %s, Upload: %s, Created: %v, Updated: %v, Offset: %d, Length: %d", project.Name, upload.ID, upload.Created, upload.Updated, upload.Offset, upload.Length), models.LogFields{
+				"project": project.Name,
+				"upload":  upload.ID,
+			})
+		}
+	}
+}
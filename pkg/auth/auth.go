@@ -0,0 +1,387 @@
+// Package auth contains the API token store and the middleware that guards
+// the API routes
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"ponder/pkg/models"
+	"ponder/pkg/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Token scopes. Admin tokens are allowed everything.
+const (
+	// ScopeRead allows downloading wordlists, rules, masks, and logs
+	ScopeRead = "read"
+	// ScopeWrite allows uploading and importing data
+	ScopeWrite = "write"
+	// ScopeAdmin allows creating projects, rolling back generations, and managing
+	// tokens
+	ScopeAdmin = "admin"
+)
+
+// CookieName is the name of the cookie set by the login flow
+const CookieName = "ponder_token"
+
+// tokenPrefix marks Ponder API tokens so they are easy to recognize
+const tokenPrefix = "ponder_"
+
+// contextKey is the gin context key holding the authenticated token
+const contextKey = "auth_token"
+
+// ErrTokenNotFound is returned when deleting a token that does not exist
+var ErrTokenNotFound = errors.New("token not found")
+
+// knownScopes is the set of valid scope names
+var knownScopes = map[string]bool{
+	ScopeRead:  true,
+	ScopeWrite: true,
+	ScopeAdmin: true,
+}
+
+// Token is a stored API token. Only the SHA-256 hash of the secret is kept.
+type Token struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash,omitempty"`
+	Scopes  []string  `json:"scopes"`
+	Created time.Time `json:"created"`
+}
+
+// HasScope checks if the token grants the scope.
+//
+// Args:
+// scope (string): The required scope
+//
+// Returns:
+// (bool): True if the token has the scope or is an admin token
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// tokensFile is the layout of the token store on disk
+type tokensFile struct {
+	Tokens []*Token `json:"tokens"`
+}
+
+// tokensMu guards the token store
+var tokensMu sync.RWMutex
+
+// tokens holds every stored token by hash
+var tokens = map[string]*Token{}
+
+// TokensFile returns the path to the token store.
+//
+// Args:
+// None
+//
+// Returns:
+// (string): The path to the token store
+func TokensFile() string {
+	return fmt.Sprintf("%s/tokens.json", models.SourceDirectory)
+}
+
+// LoadTokens reads the token store. When the store is empty an admin token is
+// created and returned so it can be shown to the operator once.
+//
+// Args:
+// None
+//
+// Returns:
+// (string): The secret of a newly created bootstrap token or empty
+// (error): Any error that occurred
+func LoadTokens() (string, error) {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+
+	tokens = map[string]*Token{}
+	data, err := os.ReadFile(TokensFile())
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err == nil {
+		var stored tokensFile
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return "", fmt.Errorf("invalid token store %s: %w", TokensFile(), err)
+		}
+		for _, token := range stored.Tokens {
+			tokens[token.Hash] = token
+		}
+	}
+
+	if len(tokens) > 0 {
+		return "", nil
+	}
+
+	secret, _, err := createToken("bootstrap", []string{ScopeAdmin})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// ListTokens returns every stored token without its hash, sorted by creation
+// time.
+//
+// Args:
+// None
+//
+// Returns:
+// ([]Token): The tokens
+func ListTokens() []Token {
+	tokensMu.RLock()
+	defer tokensMu.RUnlock()
+
+	list := make([]Token, 0, len(tokens))
+	for _, token := range tokens {
+		listed := *token
+		listed.Hash = ""
+		list = append(list, listed)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+// CreateToken creates and stores a new token.
+//
+// Args:
+// name (string): A description of who or what uses the token
+// scopes ([]string): The scopes granted to the token
+//
+// Returns:
+// (string): The secret, which is not stored and cannot be shown again
+// (Token): The stored token without its hash
+// (error): Any error that occurred
+func CreateToken(name string, scopes []string) (string, Token, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", Token{}, errors.New("token name is required")
+	}
+	if len(scopes) == 0 {
+		return "", Token{}, errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !knownScopes[scope] {
+			return "", Token{}, fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+
+	secret, token, err := createToken(name, scopes)
+	if err != nil {
+		return "", Token{}, err
+	}
+	created := *token
+	created.Hash = ""
+	return secret, created, nil
+}
+
+// DeleteToken removes a token by its ID.
+//
+// Args:
+// id (string): The ID of the token
+//
+// Returns:
+// (error): ErrTokenNotFound if there is no such token
+func DeleteToken(id string) error {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+
+	for hash, token := range tokens {
+		if token.ID == id {
+			delete(tokens, hash)
+			if err := saveTokens(); err != nil {
+				tokens[hash] = token
+				return err
+			}
+			return nil
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// Authenticate looks up the token matching a secret.
+//
+// Args:
+// secret (string): The secret presented by the client
+//
+// Returns:
+// (*Token): The matching token
+// (bool): False if the secret does not match any token
+func Authenticate(secret string) (*Token, bool) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil, false
+	}
+
+	tokensMu.RLock()
+	defer tokensMu.RUnlock()
+
+	token, ok := tokens[hashSecret(secret)]
+	return token, ok
+}
+
+// Require returns a middleware that rejects requests without a token granting
+// the scope. The token is read from a bearer Authorization header or the
+// login cookie.
+//
+// Args:
+// scope (string): The required scope
+//
+// Returns:
+// (gin.HandlerFunc): The middleware
+func Require(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now()
+
+		token, ok := Authenticate(SecretFromRequest(c))
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":    "Unauthorized",
+				"duration": time.Since(startTime).String(),
+			})
+			return
+		}
+		if !token.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":    "Forbidden",
+				"duration": time.Since(startTime).String(),
+			})
+			return
+		}
+
+		c.Set(contextKey, token)
+		c.Next()
+	}
+}
+
+// FromContext returns the token that authenticated the request.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// (*Token): The token
+// (bool): False if the route is not guarded by Require
+func FromContext(c *gin.Context) (*Token, bool) {
+	value, ok := c.Get(contextKey)
+	if !ok {
+		return nil, false
+	}
+	token, ok := value.(*Token)
+	return token, ok
+}
+
+// SecretFromRequest returns the token secret from the Authorization header or
+// the login cookie.
+//
+// Args:
+// c (gin.Context): Gin context
+//
+// Returns:
+// (string): The secret or empty if none was sent
+func SecretFromRequest(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	if cookie, err := c.Cookie(CookieName); err == nil {
+		return cookie
+	}
+	return ""
+}
+
+// createToken generates a token, adds it to the store, and saves the store.
+// tokensMu must be held.
+//
+// Args:
+// name (string): A description of who or what uses the token
+// scopes ([]string): The scopes granted to the token
+//
+// Returns:
+// (string): The secret
+// (*Token): The stored token
+// (error): Any error that occurred
+func createToken(name string, scopes []string) (string, *Token, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+
+	secret := tokenPrefix + hex.EncodeToString(random)
+	token := &Token{
+		ID:      hex.EncodeToString(id),
+		Name:    name,
+		Hash:    hashSecret(secret),
+		Scopes:  scopes,
+		Created: time.Now(),
+	}
+
+	tokens[token.Hash] = token
+	if err := saveTokens(); err != nil {
+		delete(tokens, token.Hash)
+		return "", nil, err
+	}
+	return secret, token, nil
+}
+
+// saveTokens writes the token store readable only by the owner and replaces
+// the previous store atomically so it is never left half written.
+// tokensMu must be held.
+//
+// Args:
+// None
+//
+// Returns:
+// (error): Any error that occurred
+func saveTokens() error {
+	stored := tokensFile{Tokens: make([]*Token, 0, len(tokens))}
+	for _, token := range tokens {
+		stored.Tokens = append(stored.Tokens, token)
+	}
+	sort.Slice(stored.Tokens, func(i, j int) bool {
+		return stored.Tokens[i].Created.Before(stored.Tokens[j].Created)
+	})
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(TokensFile(), data, 0600)
+}
+
+// hashSecret returns the hex encoded SHA-256 hash of a secret. Secrets are
+// 256 random bits so a fast hash is sufficient.
+//
+// Args:
+// secret (string): The secret
+//
+// Returns:
+// (string): The hash
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,631 @@
+// Package dedup remembers the candidates that were already added to the
+// source wordlist of a project in an on-disk Bloom filter, so the ingest
+// pipeline can recognize lines it has seen before without keeping them in
+// memory
+package dedup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"math"
+	mathbits "math/bits"
+	"os"
+	"ponder/pkg/models"
+	"ponder/pkg/sources"
+	"ponder/pkg/utils"
+	"syscall"
+	"time"
+)
+
+// magic identifies a filter file and the version of its layout
+var magic = []byte("PNDRBLM2")
+
+// journalMagic identifies a journal file and the version of its layout
+var journalMagic = []byte("PNDRJRN1")
+
+// headerSize is the size of the magic, the number of bits, the number of
+// hashes, the number of added candidates, and the mode at the start of the
+// filter file
+const headerSize = 40
+
+// journalHeaderSize is the size of the magic, the number of bits, and the
+// number of added candidates at the start of a journal. Every record after it
+// is the index of a 64 bit word of the filter and the new value of the word.
+const journalHeaderSize = 24
+
+// journalRecordSize is the size of one record of a journal
+const journalRecordSize = 16
+
+// scopedMode marks a filter that remembers candidates together with the key
+// of their source. Filters that used the checksum of the source instead were
+// marked with 1 and are built again.
+const scopedMode = 2
+
+// seedBufferSize is the number of bytes of the source wordlist read at a time
+// when a filter is built
+const seedBufferSize = 4 * 1024 * 1024
+
+// Filter is a Bloom filter privately mapped from the filter file of a
+// project. Candidates added to it only reach the file when Commit writes the
+// changed words back through a journal, so an ingest that fails never marks
+// lines as known that did not reach the source wordlist, and a Commit
+// interrupted by a crash is completed by the next Open. A Filter is not safe
+// for concurrent use and is only opened while the SourceMu of its project is
+// held.
+type Filter struct {
+	file *os.File
+	path string
+	data []byte
+	bits uint64
+	// hashes is the number of bits set per candidate
+	hashes uint64
+	count  uint64
+	// scoped filters remember candidates per source, so only the repeats of
+	// a source with the same key are recognized
+	scoped bool
+	source string
+	// touched has a bit for every 64 bit word changed since the last Commit
+	touched []uint64
+	hasher  hash.Hash
+	sum     []byte
+}
+
+// Size returns the number of bits and hashes of a filter that holds the
+// expected number of candidates at the given false positive rate.
+//
+// Args:
+// expected (int64): The number of distinct candidates
+// falsePositiveRate (float64): The chance of taking a new candidate for a known one
+//
+// Returns:
+// uint64: The number of bits, a multiple of 64
+// uint64: The number of hashes
+func Size(expected int64, falsePositiveRate float64) (uint64, uint64) {
+	n := float64(expected)
+	bits := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	bits = (bits + 63) / 64 * 64
+
+	hashes := uint64(math.Round(float64(bits) / n * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return bits, hashes
+}
+
+// Open maps the filter of a project. A journal left behind by an interrupted
+// Commit is replayed first. The filter is built from the source wordlist when
+// it does not exist yet or was built for a different configuration. The
+// SourceMu of the project must be held.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// *Filter: The filter, which must be closed
+// error: An error if one occurred
+func Open(project *models.Project) (*Filter, error) {
+	bits, hashes := Size(project.Config.Dedup.ExpectedLines, project.Config.Dedup.FalsePositiveRate)
+	scoped := project.Config.Dedup.Mode == models.DedupIgnore
+	size := int64(headerSize + bits/8)
+
+	utils.RemoveStaleAtomicFiles(project.DedupFilter)
+	utils.RemoveStaleAtomicFiles(journalPath(project.DedupFilter))
+	if err := replayJournal(project.DedupFilter); err != nil {
+		return nil, fmt.Errorf("error replaying dedup journal: %w", err)
+	}
+
+	usable, err := isUsable(project.DedupFilter, bits, hashes, scoped, size)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dedup filter: %w", err)
+	}
+	if !usable {
+		if err := build(project, bits, hashes, scoped, size); err != nil {
+			return nil, fmt.Errorf("error building dedup filter: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(project.DedupFilter, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening dedup filter: %w", err)
+	}
+	// Changes to a private mapping never reach the file, so only Commit
+	// writes to it
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error mapping dedup filter: %w", err)
+	}
+
+	filter := newFilter(data, bits, hashes, scoped)
+	filter.file = file
+	filter.path = project.DedupFilter
+	filter.count = binary.BigEndian.Uint64(data[24:])
+	return filter, nil
+}
+
+// Remove deletes the filter of a project and its journal, so the next Open
+// builds it again. The SourceMu of the project must be held.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// error: An error if one occurred
+func Remove(project *models.Project) error {
+	for _, path := range []string{journalPath(project.DedupFilter), project.DedupFilter} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetSource sets the key of the source whose candidates are added next. A
+// filter of the ignore mode only recognizes a candidate that was added from a
+// source with the same key.
+//
+// Args:
+// key (string): The dedup key of the source
+//
+// Returns:
+// None
+func (f *Filter) SetSource(key string) {
+	f.source = key
+}
+
+// TestAndAdd adds a candidate to the filter.
+//
+// Args:
+// candidate (string): The normalized candidate
+//
+// Returns:
+// bool: True if the candidate was probably added before
+func (f *Filter) TestAndAdd(candidate string) bool {
+	f.hasher.Reset()
+	if f.scoped {
+		io.WriteString(f.hasher, f.source)
+		f.hasher.Write([]byte{0})
+	}
+	io.WriteString(f.hasher, candidate)
+	f.sum = f.hasher.Sum(f.sum[:0])
+
+	// Every index is derived from two halves of one hash as described by
+	// Kirsch and Mitzenmacher. The halves are mixed first since FNV barely
+	// changes them for candidates that only differ in their last characters,
+	// and the step is odd so it never shares a factor of two with the number
+	// of bits.
+	high := binary.BigEndian.Uint64(f.sum[:8])
+	low := binary.BigEndian.Uint64(f.sum[8:])
+	h1 := mix(high ^ mix(low))
+	h2 := mix(low^0x9e3779b97f4a7c15) | 1
+	bits := f.data[headerSize:]
+	known := true
+	for i := uint64(0); i < f.hashes; i++ {
+		index := (h1 + i*h2) % f.bits
+		mask := byte(1) << (index % 8)
+		if bits[index/8]&mask == 0 {
+			known = false
+			bits[index/8] |= mask
+			word := index / 64
+			f.touched[word/64] |= 1 << (word % 64)
+		}
+	}
+
+	if !known {
+		f.count++
+	}
+	return known
+}
+
+// Count returns the number of distinct candidates added to the filter.
+//
+// Args:
+// None
+//
+// Returns:
+// uint64: The number of candidates
+func (f *Filter) Count() uint64 {
+	return f.count
+}
+
+// Commit writes the candidates added since Open or the last Commit to the
+// filter file. The changed words are written to a journal first, so a crash
+// while they are copied into the filter leaves a journal the next Open
+// replays. Only the changed words are written, so a small ingest does not
+// rewrite the whole filter. The filter stays open.
+//
+// Args:
+// None
+//
+// Returns:
+// error: An error if one occurred
+func (f *Filter) Commit() error {
+	journal := f.journal()
+	if len(journal) == journalHeaderSize {
+		return nil
+	}
+
+	if err := utils.WriteFileAtomic(journalPath(f.path), journal, 0644); err != nil {
+		return err
+	}
+	if err := applyJournal(f.file, journal); err != nil {
+		return err
+	}
+	clear(f.touched)
+	return os.Remove(journalPath(f.path))
+}
+
+// Close releases the filter and discards the candidates added since the last
+// Commit, so it can be deferred right after Open.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func (f *Filter) Close() {
+	if f.data != nil {
+		syscall.Munmap(f.data)
+		f.data = nil
+	}
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// journal returns a journal of the words changed since the last Commit.
+//
+// Args:
+// None
+//
+// Returns:
+// []byte: The journal
+func (f *Filter) journal() []byte {
+	journal := make([]byte, journalHeaderSize)
+	copy(journal, journalMagic)
+	binary.BigEndian.PutUint64(journal[8:], f.bits)
+	binary.BigEndian.PutUint64(journal[16:], f.count)
+	for i, touched := range f.touched {
+		for touched != 0 {
+			word := uint64(i)*64 + uint64(mathbits.TrailingZeros64(touched))
+			start := headerSize + word*8
+			journal = binary.BigEndian.AppendUint64(journal, word)
+			journal = append(journal, f.data[start:start+8]...)
+			touched &= touched - 1
+		}
+	}
+	return journal
+}
+
+// newFilter wraps mapped filter data.
+//
+// Args:
+// data ([]byte): The mapped filter file
+// bits (uint64): The number of bits of the filter
+// hashes (uint64): The number of hashes of the filter
+// scoped (bool): True if candidates are remembered per source
+//
+// Returns:
+// *Filter: The filter
+func newFilter(data []byte, bits uint64, hashes uint64, scoped bool) *Filter {
+	return &Filter{
+		data:    data,
+		bits:    bits,
+		hashes:  hashes,
+		scoped:  scoped,
+		touched: make([]uint64, (bits/64+63)/64),
+		hasher:  fnv.New128a(),
+	}
+}
+
+// build writes a new filter file seeded from the source wordlist of a
+// project.
+//
+// Args:
+// project (*models.Project): The project
+// bits (uint64): The number of bits of the configured filter
+// hashes (uint64): The number of hashes of the configured filter
+// scoped (bool): True if candidates are remembered per source
+// size (int64): The size of the configured filter file
+//
+// Returns:
+// error: An error if one occurred
+func build(project *models.Project, bits uint64, hashes uint64, scoped bool, size int64) error {
+	startTime := time.Now()
+
+	file, err := utils.CreateAtomic(project.DedupFilter, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Abort()
+
+	// Truncating a new file leaves a sparse file of zero bits
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+
+	filter := newFilter(data, bits, hashes, scoped)
+	err = filter.seed(project)
+	filter.writeHeader()
+	if unmapErr := syscall.Munmap(data); err == nil {
+		err = unmapErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := file.Commit(); err != nil {
+		return err
+	}
+
+	utils.LogEvent(models.LogLevelInfo, "Dedup filter built", fmt.Sprintf("Project: %s, Candidates: %d, Duration: %s", project.Name, filter.count, time.Since(startTime)), models.LogFields{
+		"project":    project.Name,
+		"candidates": filter.count,
+		"duration":   time.Since(startTime).String(),
+	})
+	return nil
+}
+
+// seed adds the source wordlist of a project to the filter. A scoped filter
+// is seeded from the segments of the recorded sources, since data uploaded
+// before sources were recorded has no key to be repeated under.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// error: An error if one occurred
+func (f *Filter) seed(project *models.Project) error {
+	source, err := os.Open(project.SourceWordlist)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	if !f.scoped {
+		return f.addLines(source)
+	}
+
+	recorded, err := sources.List(project)
+	if err != nil {
+		return err
+	}
+	for _, recordedSource := range recorded {
+		if recordedSource.Length == 0 {
+			continue
+		}
+		f.SetSource(recordedSource.DedupKey())
+		if err := f.addLines(io.NewSectionReader(source, recordedSource.Offset, recordedSource.Length)); err != nil {
+			return err
+		}
+	}
+	f.SetSource("")
+	return nil
+}
+
+// addLines adds every line of a reader to the filter.
+//
+// Args:
+// r (io.Reader): The lines to add
+//
+// Returns:
+// error: An error if one occurred
+func (f *Filter) addLines(r io.Reader) error {
+	return utils.ReadLineChunks(r, seedBufferSize, func(chunk []byte) error {
+		for _, line := range bytes.Split(chunk, []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			f.TestAndAdd(string(line))
+		}
+		return nil
+	})
+}
+
+// writeHeader writes the layout and the candidate count to the mapped file.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func (f *Filter) writeHeader() {
+	copy(f.data, magic)
+	binary.BigEndian.PutUint64(f.data[8:], f.bits)
+	binary.BigEndian.PutUint64(f.data[16:], f.hashes)
+	binary.BigEndian.PutUint64(f.data[24:], f.count)
+	mode := uint64(0)
+	if f.scoped {
+		mode = scopedMode
+	}
+	binary.BigEndian.PutUint64(f.data[32:], mode)
+}
+
+// isUsable checks that the filter file was built for the configured number
+// of bits, hashes, and mode.
+//
+// Args:
+// path (string): The path to the filter file
+// bits (uint64): The number of bits of the configured filter
+// hashes (uint64): The number of hashes of the configured filter
+// scoped (bool): True if candidates are remembered per source
+// size (int64): The size of the configured filter file
+//
+// Returns:
+// bool: False if there is no usable filter file and it has to be built
+// error: An error if one occurred
+func isUsable(path string, bits uint64, hashes uint64, scoped bool, size int64) (bool, error) {
+	header, fileSize, err := readHeader(path)
+	if err != nil || header == nil {
+		return false, err
+	}
+
+	mode := uint64(0)
+	if scoped {
+		mode = scopedMode
+	}
+	return fileSize == size && bytes.Equal(header[:8], magic) && binary.BigEndian.Uint64(header[8:]) == bits && binary.BigEndian.Uint64(header[16:]) == hashes && binary.BigEndian.Uint64(header[32:]) == mode, nil
+}
+
+// readHeader reads the header of a filter file.
+//
+// Args:
+// path (string): The path to the filter file
+//
+// Returns:
+// []byte: The header or nil if the file does not exist or is too short
+// int64: The size of the file
+// error: An error if one occurred
+func readHeader(path string) ([]byte, int64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(file, header); errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return nil, info.Size(), nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	return header, info.Size(), nil
+}
+
+// replayJournal completes a Commit that was interrupted by a crash. A journal
+// that does not belong to the filter file removes both, so the filter is
+// built again.
+//
+// Args:
+// path (string): The path to the filter file
+//
+// Returns:
+// error: An error if one occurred
+func replayJournal(path string) error {
+	journal, err := os.ReadFile(journalPath(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	header, size, err := readHeader(path)
+	if err != nil {
+		return err
+	}
+	// Journals are written atomically, so a mismatch means the filter file
+	// was replaced or removed since
+	if header == nil || !validJournal(journal, header, size) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Remove(journalPath(path))
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := applyJournal(file, journal); err != nil {
+		return err
+	}
+	return os.Remove(journalPath(path))
+}
+
+// validJournal checks that a journal only changes words of the filter it was
+// written for.
+//
+// Args:
+// journal ([]byte): The journal
+// header ([]byte): The header of the filter file
+// size (int64): The size of the filter file
+//
+// Returns:
+// bool: True if the journal can be applied
+func validJournal(journal []byte, header []byte, size int64) bool {
+	if len(journal) < journalHeaderSize || (len(journal)-journalHeaderSize)%journalRecordSize != 0 {
+		return false
+	}
+	bits := binary.BigEndian.Uint64(header[8:])
+	if !bytes.Equal(journal[:8], journalMagic) || !bytes.Equal(header[:8], magic) || binary.BigEndian.Uint64(journal[8:]) != bits || size != int64(headerSize+bits/8) {
+		return false
+	}
+	for record := journal[journalHeaderSize:]; len(record) > 0; record = record[journalRecordSize:] {
+		if binary.BigEndian.Uint64(record) >= bits/64 {
+			return false
+		}
+	}
+	return true
+}
+
+// applyJournal writes the words and the candidate count of a journal to the
+// filter file and syncs it. Applying a journal twice has no further effect.
+//
+// Args:
+// file (*os.File): The filter file
+// journal ([]byte): The journal
+//
+// Returns:
+// error: An error if one occurred
+func applyJournal(file *os.File, journal []byte) error {
+	for record := journal[journalHeaderSize:]; len(record) > 0; record = record[journalRecordSize:] {
+		word := binary.BigEndian.Uint64(record)
+		if _, err := file.WriteAt(record[8:journalRecordSize], int64(headerSize+word*8)); err != nil {
+			return err
+		}
+	}
+	if _, err := file.WriteAt(journal[16:journalHeaderSize], 24); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// mix spreads every bit of a hash over all bits of the result with the
+// finalizer of MurmurHash3.
+//
+// Args:
+// x (uint64): The hash
+//
+// Returns:
+// uint64: The mixed hash
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// journalPath returns the path to the journal of a filter file.
+//
+// Args:
+// path (string): The path to the filter file
+//
+// Returns:
+// string: The path to the journal
+func journalPath(path string) string {
+	return path + ".journal"
+}
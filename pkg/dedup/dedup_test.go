@@ -0,0 +1,244 @@
+package dedup
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"ponder/pkg/models"
+	"ponder/pkg/sources"
+	"ponder/pkg/utils"
+	"testing"
+)
+
+// testProject returns a project in a new directory with the dedup stage
+// enabled in the given mode.
+func testProject(t *testing.T, mode string) *models.Project {
+	t.Helper()
+
+	dir := t.TempDir()
+	logFile := models.LogFile
+	models.LogFile = filepath.Join(dir, "log.txt")
+	t.Cleanup(func() { models.LogFile = logFile })
+
+	config := models.DefaultConfig()
+	config.Dedup = models.DedupConfig{Enabled: true, Mode: mode, ExpectedLines: 1000, FalsePositiveRate: 0.01}
+	return &models.Project{
+		Name:           "test",
+		Directory:      dir,
+		SourceWordlist: filepath.Join(dir, "source-wordlist.txt"),
+		DedupFilter:    filepath.Join(dir, "dedup.bloom"),
+		Config:         config,
+	}
+}
+
+// openFilter opens the filter of a project or fails the test.
+func openFilter(t *testing.T, project *models.Project) *Filter {
+	t.Helper()
+
+	filter, err := Open(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(filter.Close)
+	return filter
+}
+
+func TestSizeMeetsFalsePositiveRate(t *testing.T) {
+	for _, tc := range []struct {
+		expected          int64
+		falsePositiveRate float64
+	}{
+		{10000000, 0.001},
+		{1000, 0.01},
+		{1, 0.5},
+	} {
+		bits, hashes := Size(tc.expected, tc.falsePositiveRate)
+		if bits == 0 || bits%64 != 0 {
+			t.Errorf("Size(%d, %g) bits = %d, want a positive multiple of 64", tc.expected, tc.falsePositiveRate, bits)
+		}
+		if hashes < 1 {
+			t.Errorf("Size(%d, %g) hashes = %d, want at least 1", tc.expected, tc.falsePositiveRate, hashes)
+		}
+		rate := math.Pow(1-math.Exp(-float64(hashes)*float64(tc.expected)/float64(bits)), float64(hashes))
+		if rate > tc.falsePositiveRate*1.05 {
+			t.Errorf("Size(%d, %g) = %d bits, %d hashes with a false positive rate of %g", tc.expected, tc.falsePositiveRate, bits, hashes, rate)
+		}
+	}
+
+	if bits, hashes := Size(10000000, 0.001); bits/8/1024/1024 != 17 || hashes != 10 {
+		t.Errorf("Size of the default filter = %d bits, %d hashes, want about 18MB and 10 hashes", bits, hashes)
+	}
+}
+
+func TestTestAndAddRecognizesAddedCandidates(t *testing.T) {
+	project := testProject(t, models.DedupCount)
+	filter := openFilter(t, project)
+
+	// New candidates are only taken for known ones at about the configured
+	// rate while the filter fills up
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if filter.TestAndAdd(fmt.Sprintf("candidate%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 10 {
+		t.Errorf("%d of 1000 new candidates were taken for known ones", falsePositives)
+	}
+	if filter.Count() != uint64(1000-falsePositives) {
+		t.Errorf("Count() = %d, want %d", filter.Count(), 1000-falsePositives)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !filter.TestAndAdd(fmt.Sprintf("candidate%d", i)) {
+			t.Fatalf("TestAndAdd(candidate%d) = false for an added candidate", i)
+		}
+	}
+}
+
+func TestCommitKeepsOnlyCommittedCandidates(t *testing.T) {
+	project := testProject(t, models.DedupCount)
+	writeFile(t, project.SourceWordlist, "seeded\n")
+
+	filter, err := Open(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.TestAndAdd("seeded") {
+		t.Error("a line of the source wordlist was not seeded")
+	}
+	filter.TestAndAdd("committed")
+	if err := filter.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	filter.TestAndAdd("discarded")
+	filter.Close()
+
+	if _, err := os.Stat(journalPath(project.DedupFilter)); !os.IsNotExist(err) {
+		t.Errorf("the journal was not removed after Commit: %v", err)
+	}
+
+	filter = openFilter(t, project)
+	if filter.Count() != 2 {
+		t.Errorf("Count() = %d after reopening, want 2", filter.Count())
+	}
+	if !filter.TestAndAdd("committed") {
+		t.Error("a committed candidate was forgotten")
+	}
+	if filter.TestAndAdd("discarded") {
+		t.Error("a candidate added after Commit was kept by Close")
+	}
+}
+
+func TestOpenReplaysInterruptedCommit(t *testing.T) {
+	project := testProject(t, models.DedupCount)
+
+	filter, err := Open(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter.TestAndAdd("journaled")
+	// A crash after the journal was written leaves the filter file unchanged
+	if err := utils.WriteFileAtomic(journalPath(project.DedupFilter), filter.journal(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filter.Close()
+
+	filter = openFilter(t, project)
+	if !filter.TestAndAdd("journaled") {
+		t.Error("the journal of an interrupted Commit was not replayed")
+	}
+	if filter.Count() != 1 {
+		t.Errorf("Count() = %d after replaying, want 1", filter.Count())
+	}
+	if _, err := os.Stat(journalPath(project.DedupFilter)); !os.IsNotExist(err) {
+		t.Errorf("the journal was not removed after replaying: %v", err)
+	}
+}
+
+func TestOpenRebuildsFilterForDifferentJournal(t *testing.T) {
+	project := testProject(t, models.DedupCount)
+	writeFile(t, project.SourceWordlist, "seeded\n")
+
+	filter, err := Open(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter.TestAndAdd("uncommitted")
+	journal := filter.journal()
+	filter.Close()
+	// A journal for a filter with a different number of bits
+	journal[15]++
+	writeFile(t, journalPath(project.DedupFilter), string(journal))
+
+	filter = openFilter(t, project)
+	if !filter.TestAndAdd("seeded") {
+		t.Error("the rebuilt filter was not seeded from the source wordlist")
+	}
+	if filter.TestAndAdd("uncommitted") {
+		t.Error("a journal for a different filter was applied")
+	}
+}
+
+func TestIgnoreModeOnlyRecognizesRepeatsOfTheSameSource(t *testing.T) {
+	project := testProject(t, models.DedupIgnore)
+	writeFile(t, project.SourceWordlist, "alpha\nbeta\n")
+	if _, err := sources.Add(project, sources.Source{Name: "first.txt", Key: "first", SHA256: "0123", Offset: 0, Length: int64(len("alpha\n"))}); err != nil {
+		t.Fatal(err)
+	}
+	// Sources recorded before keys were recorded are keyed by their name
+	if _, err := sources.Add(project, sources.Source{Name: "old.txt", SHA256: "4567", Offset: int64(len("alpha\n")), Length: int64(len("beta\n"))}); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := openFilter(t, project)
+	filter.SetSource("first")
+	if !filter.TestAndAdd("alpha") {
+		t.Error("a line of a recorded source was not seeded under its key")
+	}
+	filter.SetSource("old.txt")
+	if !filter.TestAndAdd("beta") {
+		t.Error("a line of a source without a key was not seeded under its name")
+	}
+	filter.SetSource("second")
+	if filter.TestAndAdd("alpha") {
+		t.Error("a line of another source was taken for a repeat")
+	}
+	if !filter.TestAndAdd("alpha") {
+		t.Error("a line repeated by the same source was not recognized")
+	}
+}
+
+func TestOpenRebuildsFilterWhenModeChanges(t *testing.T) {
+	project := testProject(t, models.DedupCount)
+	writeFile(t, project.SourceWordlist, "alpha\n")
+	if _, err := sources.Add(project, sources.Source{Name: "first.txt", Key: "first", SHA256: "0123", Offset: 0, Length: int64(len("alpha\n"))}); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := Open(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter.Close()
+
+	project.Config.Dedup.Mode = models.DedupIgnore
+	filter = openFilter(t, project)
+	if filter.TestAndAdd("alpha") {
+		t.Error("the filter of the count mode was reused by the ignore mode")
+	}
+	filter.SetSource("first")
+	if !filter.TestAndAdd("alpha") {
+		t.Error("the filter was not rebuilt from the recorded sources")
+	}
+}
+
+// writeFile writes a file or fails the test.
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,290 @@
+package generate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"ponder/pkg/dedup"
+	"ponder/pkg/generations"
+	"ponder/pkg/jobs"
+	"ponder/pkg/metrics"
+	"ponder/pkg/models"
+	"ponder/pkg/sources"
+	"ponder/pkg/state"
+	"ponder/pkg/utils"
+	"sync"
+)
+
+// ErrGenerationRunning is returned when a project is already generating
+var ErrGenerationRunning = errors.New("generation already running")
+
+// ErrGenerationNotRunning is returned when cancelling a project that is not
+// generating
+var ErrGenerationNotRunning = errors.New("generation not running")
+
+// activeGeneration is a running generation that can be cancelled
+type activeGeneration struct {
+	cancel context.CancelFunc
+	jobID  string
+}
+
+// activeMu guards the running generations
+var activeMu sync.Mutex
+
+// active holds the running generation of every project by name
+var active = map[string]*activeGeneration{}
+
+// Start begins a generation of the project as a background job.
+//
+// Args:
+// project (*models.Project): The project to generate.
+//
+// Returns:
+// string: The ID of the generation job.
+// error: ErrGenerationRunning if the project is already generating.
+func Start(project *models.Project) (string, error) {
+	generation, ctx, err := beginGeneration(project)
+	if err != nil {
+		return "", err
+	}
+
+	job := jobs.Start(jobs.KindGenerate, project.Name, generation.run(ctx, project))
+	return job.ID(), nil
+}
+
+// RunScheduled generates the project on the calling goroutine. It is used by
+// the scheduler and can be cancelled like a generation started with Start.
+//
+// Args:
+// project (*models.Project): The project to generate.
+//
+// Returns:
+// error: ErrGenerationRunning if the project is already generating or an
+// error from the generation.
+func RunScheduled(project *models.Project) error {
+	generation, ctx, err := beginGeneration(project)
+	if err != nil {
+		return err
+	}
+
+	run := generation.run(ctx, project)
+	jobs.Run(jobs.KindGenerate, project.Name, func(job *jobs.Job) error {
+		err = run(job)
+		return err
+	})
+	return err
+}
+
+// Cancel stops the running generation of the project. Work that has already
+// been written is left for the next generation to replace.
+//
+// Args:
+// project (*models.Project): The project to stop generating.
+//
+// Returns:
+// string: The ID of the cancelled generation job.
+// error: ErrGenerationNotRunning if the project is not generating.
+func Cancel(project *models.Project) (string, error) {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+
+	generation, ok := active[project.Name]
+	if !ok {
+		return "", ErrGenerationNotRunning
+	}
+	generation.cancel()
+	return generation.jobID, nil
+}
+
+// Rollback republishes the wizard wordlist and counts of an earlier
+// generation. The processed offset is reset to the source size of that
+// generation so incremental generations continue from it.
+//
+// Args:
+// project (*models.Project): The project to roll back.
+// id (int): The ID of the generation to publish.
+//
+// Returns:
+// error: ErrGenerationRunning if the project is generating,
+// generations.ErrGenerationNotFound if there is no such generation, or an
+// error from publishing.
+func Rollback(project *models.Project, id int) error {
+	if !project.Mu.TryLock() {
+		return ErrGenerationRunning
+	}
+	defer project.Mu.Unlock()
+
+	generation, err := generations.Get(project, id)
+	if err != nil {
+		return err
+	}
+	wordlistPATH, countsPATH, err := generations.Files(project, id)
+	if err != nil {
+		return err
+	}
+
+	// The counts are published first and the offset recorded last, matching
+	// a regular generation
+	if err := publishSnapshot(countsPATH, project.WizardCounts); err != nil {
+		return err
+	}
+	if err := publishSnapshot(wordlistPATH, project.WizardWordlist); err != nil {
+		return err
+	}
+	if err := WriteProcessedOffset(project.WizardOffset, project.WizardCounts, generation.SourceSize); err != nil {
+		return err
+	}
+	if err := generations.SetPublished(project, id); err != nil {
+		return err
+	}
+
+	utils.LogInternalEvent("Generation rolled back", fmt.Sprintf("Project: %s, Generation: %d.", project.Name, id))
+	return nil
+}
+
+// RemoveSource cuts the data of a source out of the source wordlist of the
+// project, along with the rules and masks collected from it. The processed
+// offset is reset and the dedup filter removed before any data is cut, so the
+// next generation and ingest start over from the remaining data even if the
+// removal is only completed after a restart.
+//
+// Args:
+// project (*models.Project): The project.
+// id (string): The ID of the source.
+//
+// Returns:
+// sources.Source: The removed source.
+// error: ErrGenerationRunning if the project is generating,
+// sources.ErrSourceNotFound if there is no such source, or an error from
+// rewriting the source wordlist.
+func RemoveSource(project *models.Project, id string) (sources.Source, error) {
+	if !project.Mu.TryLock() {
+		return sources.Source{}, ErrGenerationRunning
+	}
+	defer project.Mu.Unlock()
+
+	project.SourceMu.Lock()
+	defer project.SourceMu.Unlock()
+
+	if _, err := sources.Get(project, id); err != nil {
+		return sources.Source{}, err
+	}
+	if err := WriteProcessedOffset(project.WizardOffset, project.WizardCounts, 0); err != nil {
+		return sources.Source{}, err
+	}
+	if err := dedup.Remove(project); err != nil {
+		return sources.Source{}, err
+	}
+	source, err := sources.Remove(project, id)
+	if err != nil {
+		return sources.Source{}, err
+	}
+
+	utils.LogEvent(models.LogLevelWarn, "Source removed", fmt.Sprintf("Project: %s, Source: %s, File: %s, Bytes: %d.", project.Name, source.ID, source.Name, source.Length), models.LogFields{
+		"project": project.Name,
+		"source":  source.ID,
+		"file":    source.Name,
+		"bytes":   source.Length,
+	})
+	return source, nil
+}
+
+// publishSnapshot links a generation snapshot next to a published file and
+// renames the link over it, so the snapshot itself is never modified.
+//
+// Args:
+// snapshotPATH (string): The path to the snapshot.
+// targetPATH (string): The path to the published file.
+//
+// Returns:
+// error: An error if one occurred.
+func publishSnapshot(snapshotPATH string, targetPATH string) error {
+	linkPATH := filepath.Join(filepath.Dir(targetPATH), "."+filepath.Base(targetPATH)+"-rollback")
+	os.Remove(linkPATH)
+	if err := utils.LinkOrCopy(snapshotPATH, linkPATH); err != nil {
+		return err
+	}
+	if err := utils.PublishFile(linkPATH, targetPATH); err != nil {
+		os.Remove(linkPATH)
+		return err
+	}
+	return nil
+}
+
+// beginGeneration registers a generation of the project so a second one
+// cannot start and it can be cancelled.
+//
+// Args:
+// project (*models.Project): The project to generate.
+//
+// Returns:
+// *activeGeneration: The registered generation.
+// context.Context: The context cancelled by Cancel.
+// error: ErrGenerationRunning if the project is already generating.
+func beginGeneration(project *models.Project) (*activeGeneration, context.Context, error) {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+
+	if _, ok := active[project.Name]; ok {
+		return nil, nil, ErrGenerationRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	generation := &activeGeneration{cancel: cancel}
+	active[project.Name] = generation
+	return generation, ctx, nil
+}
+
+// run returns the job work that generates the project and unregisters the
+// generation once it is done.
+//
+// Args:
+// ctx (context.Context): The context cancelled by Cancel.
+// project (*models.Project): The project to generate.
+//
+// Returns:
+// func(*jobs.Job) error: The job work.
+func (g *activeGeneration) run(ctx context.Context, project *models.Project) func(job *jobs.Job) error {
+	return func(job *jobs.Job) error {
+		activeMu.Lock()
+		g.jobID = job.ID()
+		activeMu.Unlock()
+
+		defer func() {
+			activeMu.Lock()
+			if active[project.Name] == g {
+				delete(active, project.Name)
+			}
+			activeMu.Unlock()
+			g.cancel()
+		}()
+
+		// A generation interrupted by a restart is started again
+		pendingID, err := state.AddPendingJob(project, state.PendingJob{Kind: jobs.KindGenerate})
+		if err != nil {
+			utils.LogInternalError("Error recording pending job", fmt.Sprintf("Project: %s, Kind: %s, Error: %v", project.Name, jobs.KindGenerate, err))
+		} else {
+			defer func() {
+				if err := state.RemovePendingJob(project, pendingID); err != nil {
+					utils.LogInternalError("Error removing pending job", fmt.Sprintf("Project: %s, Kind: %s, Error: %v", project.Name, jobs.KindGenerate, err))
+				}
+			}()
+		}
+
+		err = GenerateProject(ctx, project)
+		if errors.Is(err, context.Canceled) {
+			metrics.Generations.With(project.Name, metrics.ResultCancelled).Inc()
+			utils.LogEvent(models.LogLevelWarn, "Wordlist update cancelled", fmt.Sprintf("Project: %s", project.Name), models.LogFields{
+				"project": project.Name,
+			})
+		} else if err != nil {
+			metrics.Generations.With(project.Name, metrics.ResultFailed).Inc()
+			utils.LogInternalError("Error updating wordlist", fmt.Sprintf("Project: %s, %v", project.Name, err))
+		} else {
+			metrics.Generations.With(project.Name, metrics.ResultCompleted).Inc()
+		}
+		return err
+	}
+}
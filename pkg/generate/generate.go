@@ -2,42 +2,355 @@
 package generate
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"ponder/pkg/generations"
+	"ponder/pkg/metrics"
 	"ponder/pkg/models"
+	"ponder/pkg/state"
 	"ponder/pkg/utils"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
+// GenerateProject regenerates the wizard wordlist of a project while holding
+// the generation lock, using an incremental update when the project
+// configuration enables it. Uploads keep appending to the source wordlist
+// while it runs as only the source data present at the start is processed.
+// Progress is reported to the project and the generation stops when the
+// context is cancelled.
+//
+// Args:
+// ctx (context.Context): The context used to cancel the generation.
+// project (*models.Project): The project to generate.
+//
+// Returns:
+// error: An error if one occurred.
+func GenerateProject(ctx context.Context, project *models.Project) error {
+	startTime := time.Now()
+	utils.LogInternalEvent("Starting a wordlist update", fmt.Sprintf("Project: %s, last uploaded %v.", project.Name, project.LastUploaded()))
+
+	project.Mu.Lock()
+	project.Progress.Start()
+	defer project.Progress.Finish()
+	ctx = models.WithProgress(ctx, project.Progress)
+	utils.LogInternalEvent("Creating wizard wordlist", fmt.Sprintf("Generating %v.", project.WizardWordlist))
+	var err error
+	if project.Config.IncrementalGeneration {
+		err = UpdateWizardWordlist(ctx, project.SourceWordlist, project.WizardWordlist, project.WizardCounts, project.WizardOffset, project.Config)
+	} else {
+		err = CreateWizardWordlist(ctx, project.SourceWordlist, project.WizardWordlist, project.WizardCounts, project.WizardOffset, project.Config)
+	}
+	if err == nil {
+		// A failed snapshot only costs the history, the new wordlist is
+		// already published
+		if recordErr := recordGeneration(project, startTime); recordErr != nil {
+			utils.LogInternalError("Error recording generation", fmt.Sprintf("Project: %s, %v", project.Name, recordErr))
+		}
+
+		// Appends wait while the collected rules and masks are ranked, so
+		// no partly written record is read
+		project.SourceMu.Lock()
+		project.Progress.SetPhase(models.PhaseRanking)
+		err = CreateRankedList(ctx, project.RulesSource, project.RulesList, project.RulesCounts)
+		if err == nil {
+			err = CreateRankedList(ctx, project.MasksSource, project.MasksList, project.MasksCounts)
+		}
+		project.SourceMu.Unlock()
+	}
+	project.Mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Uploads that finish while the generation runs may not be included, so
+	// the start time is recorded to have them picked up by the next cycle
+	project.SetLastUpdated(startTime)
+	if err := state.Save(project); err != nil {
+		utils.LogInternalError("Error saving project state", fmt.Sprintf("Project: %s, Error: %v", project.Name, err))
+	}
+	duration := time.Since(startTime)
+	metrics.GenerationDuration.With(project.Name).Set(duration.Seconds())
+	utils.LogEvent(models.LogLevelInfo, "Wordlist update complete", fmt.Sprintf("Project: %s, Duration: %v.", project.Name, duration), models.LogFields{
+		"project":  project.Name,
+		"duration": duration.String(),
+	})
+	return nil
+}
+
+// recordGeneration snapshots the published wizard wordlist of a project as a
+// new generation. project.Mu must be held.
+//
+// Args:
+// project (*models.Project): The project that published the wordlist.
+// startTime (time.Time): The start of the generation.
+//
+// Returns:
+// error: An error if one occurred.
+func recordGeneration(project *models.Project, startTime time.Time) error {
+	sourceSize, err := ReadProcessedOffset(project.WizardOffset, project.WizardCounts)
+	if err != nil {
+		return err
+	}
+
+	generation, recorded, err := generations.Record(project, generations.Generation{
+		Started:    startTime,
+		Finished:   time.Now(),
+		SourceSize: sourceSize,
+		LineCount:  project.Progress.Snapshot().LinesWritten,
+	})
+	if err != nil || !recorded {
+		return err
+	}
+
+	utils.LogInternalEvent("Generation recorded", fmt.Sprintf("Project: %s, Generation: %d.", project.Name, generation.ID))
+	return nil
+}
+
+// CreateRankedList ranks the rules or masks collected from uploads by
+// frequency and writes them to a hashcat rule or mask file with a companion
+// counts file. The source is left as collected, since the rules and masks of
+// every source are a segment of it that is cut out when the source is
+// removed.
+//
+// Args:
+// ctx (context.Context): The context used to cancel the ranking.
+// sourcePATH (string): The path to the collected "count\tkey" records.
+// targetPATH (string): The path to the rule or mask file.
+// countsPATH (string): The path to the companion counts file.
+//
+// Returns:
+// error: An error if one occurred.
+func CreateRankedList(ctx context.Context, sourcePATH string, targetPATH string, countsPATH string) error {
+	sourceInfo, err := os.Stat(sourcePATH)
+	if os.IsNotExist(err) || (err == nil && sourceInfo.Size() == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	utils.LogInternalEvent("Creating ranked list", fmt.Sprintf("Generating %v.", targetPATH))
+	utils.RemoveStaleAtomicFiles(targetPATH)
+	utils.RemoveStaleAtomicFiles(countsPATH)
+	if err := utils.MergeCountsByFrequency(ctx, targetPATH, countsPATH, sourcePATH); err != nil {
+		utils.LogInternalError("Error ranking collected counts", err.Error())
+		return err
+	}
+
+	return nil
+}
+
 // CreateWizardWordlist processes the source file in chunks, removes trailing digits from strings,
 // and writes the processed content to the target file in a memory-efficient manner.
+// The frequency of every candidate is written to the counts file and the size
+// of the processed source is recorded in the offset file.
 //
 // Args:
+// ctx (context.Context): The context used to cancel the generation.
 // sourcePATH (string): The path to the source file.
 // targetPATH (string): The path to the target file.
+// countsPATH (string): The path to the companion counts file.
+// offsetPATH (string): The path to the file recording the processed offset.
+// config (*models.Config): The configuration whose pipeline rules are applied.
 //
 // Returns:
 // error: An error if one occurred.
-func CreateWizardWordlist(sourcePATH string, targetPATH string) error {
+func CreateWizardWordlist(ctx context.Context, sourcePATH string, targetPATH string, countsPATH string, offsetPATH string, config *models.Config) error {
+	return generateFromOffset(ctx, sourcePATH, targetPATH, countsPATH, offsetPATH, 0, config)
+}
+
+// UpdateWizardWordlist runs the generation pipeline only over the source data
+// appended since the last generation and merges the resulting counts into the
+// existing counts file. A full rebuild is performed when there is no previous
+// generation, the source file has shrunk since it was recorded, or the counts
+// file was replaced without recording its offset, for example by a crash
+// between the two.
+//
+// Args:
+// ctx (context.Context): The context used to cancel the generation.
+// sourcePATH (string): The path to the source file.
+// targetPATH (string): The path to the target file.
+// countsPATH (string): The path to the companion counts file.
+// offsetPATH (string): The path to the file recording the processed offset.
+// config (*models.Config): The configuration whose pipeline rules are applied.
+//
+// Returns:
+// error: An error if one occurred.
+func UpdateWizardWordlist(ctx context.Context, sourcePATH string, targetPATH string, countsPATH string, offsetPATH string, config *models.Config) error {
+	offset, err := ReadProcessedOffset(offsetPATH, countsPATH)
+	if err != nil {
+		utils.LogInternalError("Error reading processed offset in wordlist generation", err.Error())
+		return err
+	}
+
+	sourceInfo, err := os.Stat(sourcePATH)
+	if err != nil {
+		utils.LogInternalError("Error opening file in wordlist generation", err.Error())
+		return err
+	}
+
+	countsInfo, err := os.Stat(countsPATH)
+	if offset == 0 || err != nil || countsInfo.Size() == 0 || offset > sourceInfo.Size() {
+		utils.LogInternalEvent("Performing full generation", fmt.Sprintf("No usable previous generation for offset %d.", offset))
+		return generateFromOffset(ctx, sourcePATH, targetPATH, countsPATH, offsetPATH, 0, config)
+	}
+
+	if offset == sourceInfo.Size() {
+		utils.LogInternalEvent("Skipping incremental generation", "No new source data since the last generation.")
+		return nil
+	}
+
+	utils.LogInternalEvent("Performing incremental generation", fmt.Sprintf("Processing %d new bytes from offset %d.", sourceInfo.Size()-offset, offset))
+	return generateFromOffset(ctx, sourcePATH, targetPATH, countsPATH, offsetPATH, offset, config)
+}
+
+// generateFromOffset processes the source file from the given offset to its
+// current size and sorts the candidates by frequency. When the offset is not
+// zero the existing counts are merged into the result.
+//
+// Args:
+// ctx (context.Context): The context used to cancel the generation.
+// sourcePATH (string): The path to the source file.
+// targetPATH (string): The path to the target file.
+// countsPATH (string): The path to the companion counts file.
+// offsetPATH (string): The path to the file recording the processed offset.
+// offset (int64): The byte offset to start processing from.
+// config (*models.Config): The configuration whose pipeline rules are applied.
+//
+// Returns:
+// error: An error if one occurred.
+func generateFromOffset(ctx context.Context, sourcePATH string, targetPATH string, countsPATH string, offsetPATH string, offset int64, config *models.Config) error {
 	sourceFile, err := os.Open(sourcePATH)
 	if err != nil {
-		utils.LogInternalEvent("Error opening file in wordlist generation", err.Error())
+		utils.LogInternalError("Error opening file in wordlist generation", err.Error())
 		return err
 	}
 	defer sourceFile.Close()
 
-	targetFile, err := os.OpenFile(targetPATH, os.O_CREATE|os.O_WRONLY, 0644)
+	// The size is captured up front so data appended while the generation is
+	// running is left for the next cycle.
+	sourceInfo, err := sourceFile.Stat()
+	if err != nil {
+		utils.LogInternalError("Error opening file in wordlist generation", err.Error())
+		return err
+	}
+	// An upload may be appending a line right now, so the generation stops
+	// after the last complete line
+	sourceSize, err := lastCompleteLine(sourceFile, sourceInfo.Size())
+	if err != nil {
+		utils.LogInternalError("Error reading file in wordlist generation", err.Error())
+		return err
+	}
+	if sourceSize < offset {
+		sourceSize = offset
+	}
+
+	if _, err := sourceFile.Seek(offset, io.SeekStart); err != nil {
+		utils.LogInternalError("Error seeking file in wordlist generation", err.Error())
+		return err
+	}
+
+	// The candidates are written next to the target and only renamed over it
+	// once sorted, so downloads keep serving the last published wordlist
+	utils.RemoveStaleAtomicFiles(targetPATH)
+	utils.RemoveStaleAtomicFiles(countsPATH)
+	candidatesFile, err := os.CreateTemp(filepath.Dir(targetPATH), "."+filepath.Base(targetPATH)+"-candidates-")
 	if err != nil {
-		utils.LogInternalEvent("Error opening file in wordlist generation", err.Error())
+		utils.LogInternalError("Error opening file in wordlist generation", err.Error())
 		return err
 	}
-	defer targetFile.Close()
+	candidatesPATH := candidatesFile.Name()
+	defer os.Remove(candidatesPATH)
 
+	progress := models.ProgressFromContext(ctx)
+	progress.SetSourceBytes(sourceSize - offset)
+	err = processSourceChunks(ctx, io.LimitReader(sourceFile, sourceSize-offset), candidatesFile, config)
+	if closeErr := candidatesFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	utils.LogInternalEvent("Sorting wordlist by frequency", fmt.Sprintf("Target: %s.", targetPATH))
+
+	// Deduplicates and sorts the candidates by frequency
+	var previousCounts []string
+	if offset > 0 {
+		previousCounts = append(previousCounts, countsPATH)
+	}
+	if err := utils.SortByAproxFrequency(ctx, candidatesPATH, countsPATH, previousCounts...); err != nil {
+		utils.LogInternalError("Error sorting wordlist by frequency in wordlist generation", err.Error())
+		return err
+	}
+	if err := utils.PublishFile(candidatesPATH, targetPATH); err != nil {
+		utils.LogInternalError("Error publishing wordlist in wordlist generation", err.Error())
+		return err
+	}
+
+	// The offset is recorded last along with the counts file it belongs to,
+	// so counts published before a crash are detected and rebuilt instead of
+	// having the same source data merged into them twice
+	if err := WriteProcessedOffset(offsetPATH, countsPATH, sourceSize); err != nil {
+		utils.LogInternalError("Error writing processed offset in wordlist generation", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// lastCompleteLine finds the end of the last complete line within the first
+// size bytes of a file.
+//
+// Args:
+// file (*os.File): The file to search.
+// size (int64): The number of bytes to search.
+//
+// Returns:
+// int64: The offset just after the last newline or 0 if there is none.
+// error: An error if one occurred.
+func lastCompleteLine(file *os.File, size int64) (int64, error) {
+	buffer := make([]byte, 64*1024)
+	end := size
+	for end > 0 {
+		start := end - int64(len(buffer))
+		if start < 0 {
+			start = 0
+		}
+		block := buffer[:end-start]
+		if _, err := file.ReadAt(block, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(block, '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// processSourceChunks reads the source data in chunks, runs each chunk through
+// the generation pipeline, and writes the candidates to the target file.
+//
+// Args:
+// ctx (context.Context): The context used to cancel the generation.
+// source (io.Reader): The source data to process.
+// targetFile (*os.File): The file to write the candidates to.
+// config (*models.Config): The configuration whose pipeline rules are applied.
+//
+// Returns:
+// error: An error if one occurred.
+func processSourceChunks(ctx context.Context, source io.Reader, targetFile *os.File, config *models.Config) error {
 	// This buffer size is the maximum size that can be processed in a single
 	// chunk. This is to prevent memory exhaustion when processing large files.
 	//
@@ -48,44 +361,149 @@ func CreateWizardWordlist(sourcePATH string, targetPATH string) error {
 	// The highest tested value was 1MB. The size was reduced to 256KB to
 	// ensure completion at the expense of speed because other operations could
 	// use the memory for more valuable tasks like deduplication/sorting.
-	buffer := make([]byte, 256*1024)
+	bufferSize := 256 * 1024
 
-	utils.LogInternalEvent("Processing source file", fmt.Sprintf("Chunk size: %d bytes.", len(buffer)))
-	for {
-		n, err := sourceFile.Read(buffer)
-		if err != nil && err != io.EOF {
-			utils.LogInternalEvent("Error reading file in wordlist generation", err.Error())
+	utils.LogInternalEvent("Processing source file", fmt.Sprintf("Chunk size: %d bytes.", bufferSize))
+	progress := models.ProgressFromContext(ctx)
+	err := utils.ReadLineChunks(source, bufferSize, func(chunk []byte) error {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		if n == 0 {
-			break
-		}
+		progress.AddSourceConsumed(int64(len(chunk)))
 
-		processedChunk := buffer[:n]
-		processedChunk = models.GenerateNGramSliceBytes(processedChunk, 1, 5)
-		preparedLines := PrepareStringForTransformations(processedChunk)
-		processedChunk = []byte(strings.Join(preparedLines, "\n"))
-		processedChunk = filterLines(processedChunk)
-		processedChunk = removeTrailingDigits(processedChunk)
-		processedChunk = removeLeadingDigits(processedChunk)
-		processedChunk = models.EnforceLengthRange(processedChunk, 4, 32)
-		nGramChunk := filterLines(processedChunk)
+		processedChunk := chunk
+		for _, name := range config.Pipeline.GenerationStages {
+			var err error
+			processedChunk, err = generationStages[name](processedChunk, config)
+			if err != nil {
+				return fmt.Errorf("error in generation stage %s: %w", name, err)
+			}
+		}
 
-		if _, err := targetFile.Write(nGramChunk); err != nil {
-			utils.LogInternalEvent("Error writing to file in wordlist generation", err.Error())
-			return err
+		// Stages that join lines do not end with a newline, so one is added
+		// to keep the last line of this chunk separate from the next chunk
+		nGramChunk := processedChunk
+		if len(nGramChunk) > 0 && nGramChunk[len(nGramChunk)-1] != '\n' {
+			nGramChunk = append(nGramChunk, '\n')
 		}
+
+		_, err := targetFile.Write(nGramChunk)
+		return err
+	})
+	if err != nil {
+		utils.LogInternalError("Error processing file in wordlist generation", err.Error())
+		return err
 	}
 
-	utils.LogInternalEvent("Sorting wordlist by frequency", fmt.Sprintf("Target: %s.", targetPATH))
+	return nil
+}
+
+// generationStages maps the stage names used in the pipeline configuration to
+// their implementation. Every stage takes and returns newline separated lines.
+var generationStages = map[string]func(data []byte, config *models.Config) ([]byte, error){
+	models.StageNGrams: func(data []byte, config *models.Config) ([]byte, error) {
+		return models.GenerateNGramSliceBytes(data, config.Pipeline.NGramRange.Min, config.Pipeline.NGramRange.Max), nil
+	},
+	models.StagePrepare: func(data []byte, config *models.Config) ([]byte, error) {
+		prepared, err := PrepareStringForTransformations(data, config.Characters)
+		return []byte(strings.Join(prepared, "\n")), err
+	},
+	models.StageFilter: filterLines,
+	models.StageTrimTrailingDigits: func(data []byte, config *models.Config) ([]byte, error) {
+		return removeTrailingDigits(data)
+	},
+	models.StageTrimLeadingDigits: func(data []byte, config *models.Config) ([]byte, error) {
+		return removeLeadingDigits(data)
+	},
+	models.StageLength: func(data []byte, config *models.Config) ([]byte, error) {
+		return models.EnforceLengthRange(data, config.Pipeline.LengthRange.Min, config.Pipeline.LengthRange.Max), nil
+	},
+	models.StageBlocklist: filterBlocklist,
+}
+
+// ReadProcessedOffset reads the number of source bytes that have already been
+// processed into the wizard wordlist. The offset only applies to the counts
+// file it was recorded with, so zero is returned when the counts file has
+// been replaced since.
+//
+// Args:
+// offsetPATH (string): The path to the offset file.
+// countsPATH (string): The path to the companion counts file.
+//
+// Returns:
+// int64: The processed offset or zero if none has been recorded for the
+// current counts file.
+// error: An error if one occurred.
+func ReadProcessedOffset(offsetPATH string, countsPATH string) (int64, error) {
+	data, err := os.ReadFile(offsetPATH)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// The offset is followed by the fingerprint of the counts file, which
+	// offset files written by older versions do not have
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	offset, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if len(fields) > 1 {
+		fingerprint, err := countsFingerprint(countsPATH)
+		if err != nil {
+			return 0, err
+		}
+		if strings.Join(fields[1:], " ") != fingerprint {
+			utils.LogInternalEvent("Discarding processed offset", fmt.Sprintf("The counts file %s changed after offset %d was recorded.", countsPATH, offset))
+			return 0, nil
+		}
+	}
+	return offset, nil
+}
 
-	// Some deduplication from the function below 
-	if err := utils.SortByAproxFrequency(targetPATH); err != nil {
-		utils.LogInternalEvent("Error sorting wordlist by frequency in wordlist generation", err.Error())
+// WriteProcessedOffset records the number of source bytes that have been
+// processed into the wizard wordlist along with the fingerprint of the counts
+// file holding them. It must be called after the counts file is published.
+//
+// Args:
+// offsetPATH (string): The path to the offset file.
+// countsPATH (string): The path to the companion counts file.
+// offset (int64): The processed offset.
+//
+// Returns:
+// error: An error if one occurred.
+func WriteProcessedOffset(offsetPATH string, countsPATH string, offset int64) error {
+	fingerprint, err := countsFingerprint(countsPATH)
+	if err != nil {
 		return err
 	}
+	return utils.WriteFileAtomic(offsetPATH, []byte(strconv.FormatInt(offset, 10)+" "+fingerprint+"\n"), 0644)
+}
 
-	return nil
+// countsFingerprint identifies the published version of a counts file by its
+// size and modification time. Counts files are replaced by renaming a new
+// file over them, so every publish changes the fingerprint.
+//
+// Args:
+// countsPATH (string): The path to the counts file.
+//
+// Returns:
+// string: The fingerprint, or "-" if the counts file does not exist.
+// error: An error if one occurred.
+func countsFingerprint(countsPATH string) (string, error) {
+	info, err := os.Stat(countsPATH)
+	if os.IsNotExist(err) {
+		return "-", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano()), nil
 }
 
 // PrepareStringForTransformations processes each line in the input byte slice,
@@ -95,14 +513,14 @@ func CreateWizardWordlist(sourcePATH string, targetPATH string) error {
 // Args:
 //
 //	data ([]byte): The byte slice containing lines to process.
+//	policy (models.CharacterPolicy): The character policy to apply.
 //
 // Returns:
 //
 //	[]string: A flattened slice of all prepared string variants for all lines.
-func PrepareStringForTransformations(data []byte) []string {
-	// Convert the byte slice to a string for line-wise processing
-	input := string(data)
-	scanner := bufio.NewScanner(strings.NewReader(input))
+//	error: An error if a line was too long to be scanned.
+func PrepareStringForTransformations(data []byte, policy models.CharacterPolicy) ([]string, error) {
+	scanner := utils.NewLineScanner(bytes.NewReader(data))
 
 	var results []string
 
@@ -116,8 +534,17 @@ func PrepareStringForTransformations(data []byte) []string {
 		clean = strings.ReplaceAll(clean, "\r", "")
 		clean = strings.ReplaceAll(clean, "\f", "")
 		clean = strings.ReplaceAll(clean, "\v", "")
-		clean = RemoveControlChars(clean)
+		if policy.AllowUnicode {
+			clean = RemoveNonPrintableChars(clean)
+		} else {
+			clean = RemoveControlChars(clean)
+		}
+		clean = utils.NormalizeCandidate(clean, policy)
 		clean = strings.ToLower(clean)
+		// Normalizing can make a line longer than the later stages scan
+		if len(clean) > utils.MaxLineLength {
+			continue
+		}
 
 		if strings.Contains(clean, " ") {
 			results = append(results, strings.ReplaceAll(
@@ -127,7 +554,7 @@ func PrepareStringForTransformations(data []byte) []string {
 		}
 	}
 
-	return results
+	return results, scanner.Err()
 }
 
 // RemoveControlChars removes all non-printable ASCII characters from a string,
@@ -142,32 +569,106 @@ func RemoveControlChars(s string) string {
 	return b.String()
 }
 
-// filterLines checks each line and skips those that consist only of digits or special characters.
+// RemoveNonPrintableChars removes all non-printable characters from a string
+// while keeping printable characters from any script.
+func RemoveNonPrintableChars(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsPrint(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// filterLines checks each line and skips those that consist only of digits or
+// special characters, are not allowed by the character policy, or do not look
+// like words.
 //
 // Args:
 // data ([]byte): The byte slice containing the data to be processed.
+// config (*models.Config): The configuration whose rules are applied.
 //
 // Returns:
 // []byte: The processed byte slice with filtered lines.
-func filterLines(data []byte) []byte {
-	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+// error: An error if a line was too long to be scanned.
+func filterLines(data []byte, config *models.Config) ([]byte, error) {
+	scanner := utils.NewLineScanner(bytes.NewReader(data))
 	var result strings.Builder
+	var lines, digitsRejected, policyRejected, wordsRejected int
 
 	for scanner.Scan() {
 		line := scanner.Text()
+		lines++
 		if utils.IsAllDigitsOrSpecialChars(line) {
+			digitsRejected++
 			continue
 		}
-		if utils.ContainsOnlyASCII(line) == false {
+		if utils.AllowedByCharacterPolicy(line, config.Characters) == false {
+			policyRejected++
 			continue
 		}
-		if utils.LikelyContainsWords(line) == false {
+		if utils.LikelyContainsWords(line, config.Pipeline.WordLikeness) == false {
+			wordsRejected++
 			continue
 		}
 		result.WriteString(line + "\n")
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Every filter only sees the lines the filters before it accepted
+	checked := lines
+	for _, filter := range []struct {
+		name     string
+		rejected int
+	}{
+		{models.FilterDigitsOrSpecial, digitsRejected},
+		{models.FilterCharacterPolicy, policyRejected},
+		{models.FilterWordLikeness, wordsRejected},
+	} {
+		metrics.FilterLines.With(metrics.StageGenerate, filter.name, metrics.ResultAccepted).Add(float64(checked - filter.rejected))
+		metrics.FilterLines.With(metrics.StageGenerate, filter.name, metrics.ResultRejected).Add(float64(filter.rejected))
+		checked -= filter.rejected
+	}
+
+	return []byte(result.String()), nil
+}
+
+// filterBlocklist skips every line that matches one of the blocklist
+// patterns.
+//
+// Args:
+// data ([]byte): The byte slice containing the data to be processed.
+// config (*models.Config): The configuration holding the blocklist.
+//
+// Returns:
+// []byte: The processed byte slice with blocked lines removed.
+// error: An error if a line was too long to be scanned.
+func filterBlocklist(data []byte, config *models.Config) ([]byte, error) {
+	scanner := utils.NewLineScanner(bytes.NewReader(data))
+	var result strings.Builder
+
+	var accepted, rejected int
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if utils.IsQualityCandidateCheck(line, config.Pipeline.BlocklistRegexps()) == false {
+			rejected++
+			continue
+		}
+		accepted++
+		result.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	metrics.FilterLines.With(metrics.StageGenerate, models.FilterBlocklist, metrics.ResultAccepted).Add(float64(accepted))
+	metrics.FilterLines.With(metrics.StageGenerate, models.FilterBlocklist, metrics.ResultRejected).Add(float64(rejected))
 
-	return []byte(result.String())
+	return []byte(result.String()), nil
 }
 
 // removeTrailingDigits removes trailing digits from each line in the given byte slice.
@@ -177,8 +678,9 @@ func filterLines(data []byte) []byte {
 //
 // Returns:
 // []byte: The processed byte slice with trailing digits removed.
-func removeTrailingDigits(data []byte) []byte {
-	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+// error: An error if a line was too long to be scanned.
+func removeTrailingDigits(data []byte) ([]byte, error) {
+	scanner := utils.NewLineScanner(bytes.NewReader(data))
 	var result strings.Builder
 
 	for scanner.Scan() {
@@ -189,7 +691,7 @@ func removeTrailingDigits(data []byte) []byte {
 		result.WriteString(processedLine + "\n")
 	}
 
-	return []byte(result.String())
+	return []byte(result.String()), scanner.Err()
 }
 
 // removeLeadingDigits removes leading digits from each line in the given byte
@@ -200,8 +702,9 @@ func removeTrailingDigits(data []byte) []byte {
 //
 // Returns:
 // []byte: The processed byte slice with leading digits removed.
-func removeLeadingDigits(data []byte) []byte {
-	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+// error: An error if a line was too long to be scanned.
+func removeLeadingDigits(data []byte) ([]byte, error) {
+	scanner := utils.NewLineScanner(bytes.NewReader(data))
 	var result strings.Builder
 
 	for scanner.Scan() {
@@ -212,5 +715,5 @@ func removeLeadingDigits(data []byte) []byte {
 		result.WriteString(processedLine + "\n")
 	}
 
-	return []byte(result.String())
+	return []byte(result.String()), scanner.Err()
 }
@@ -0,0 +1,133 @@
+package generate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"ponder/pkg/metrics"
+	"ponder/pkg/models"
+	"ponder/pkg/utils"
+	"strings"
+	"testing"
+)
+
+// generationFiles returns the paths of a source wordlist and the files
+// generated from it in a new directory, which also takes the temporary files
+// of the sort.
+func generationFiles(t *testing.T) (string, string, string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	sourceDirectory := models.SourceDirectory
+	models.SourceDirectory = dir
+	t.Cleanup(func() { models.SourceDirectory = sourceDirectory })
+
+	return filepath.Join(dir, "source-wordlist.txt"), filepath.Join(dir, "wizard-wordlist.txt"), filepath.Join(dir, "wizard-wordlist.counts"), filepath.Join(dir, "wizard-wordlist.offset")
+}
+
+// appendSource appends lines to the source wordlist.
+func appendSource(t *testing.T, sourcePATH string, lines ...string) {
+	t.Helper()
+
+	file, err := os.OpenFile(sourcePATH, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadProcessedOffsetDiscardsOffsetOfReplacedCounts(t *testing.T) {
+	_, _, countsPATH, offsetPATH := generationFiles(t)
+
+	if err := os.WriteFile(countsPATH, []byte("2\tpassword\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteProcessedOffset(offsetPATH, countsPATH, 42); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := ReadProcessedOffset(offsetPATH, countsPATH); err != nil || offset != 42 {
+		t.Fatalf("got offset %d and error %v, want 42", offset, err)
+	}
+
+	// A generation that crashed after publishing its counts leaves the
+	// offset of the previous counts behind
+	if err := utils.WriteFileAtomic(countsPATH, []byte("3\tpassword\n1\tsunshine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := ReadProcessedOffset(offsetPATH, countsPATH); err != nil || offset != 0 {
+		t.Errorf("got offset %d and error %v for replaced counts, want 0", offset, err)
+	}
+
+	// Offset files without a fingerprint are still read
+	if err := os.WriteFile(offsetPATH, []byte("17\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := ReadProcessedOffset(offsetPATH, countsPATH); err != nil || offset != 17 {
+		t.Errorf("got offset %d and error %v for a plain offset, want 17", offset, err)
+	}
+}
+
+func TestUpdateWizardWordlistRebuildsCountsPublishedWithoutOffset(t *testing.T) {
+	sourcePATH, targetPATH, countsPATH, offsetPATH := generationFiles(t)
+	config := models.DefaultConfig()
+
+	appendSource(t, sourcePATH, "sunshine", "sunshine", "dragonfly")
+	if err := CreateWizardWordlist(context.Background(), sourcePATH, targetPATH, countsPATH, offsetPATH, config); err != nil {
+		t.Fatalf("CreateWizardWordlist: %v", err)
+	}
+	first, err := os.ReadFile(countsPATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The offset of an incremental run is lost after its counts were
+	// published, so merging the new data again would count it twice
+	offsetData, err := os.ReadFile(offsetPATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendSource(t, sourcePATH, "sunshine")
+	if err := UpdateWizardWordlist(context.Background(), sourcePATH, targetPATH, countsPATH, offsetPATH, config); err != nil {
+		t.Fatalf("UpdateWizardWordlist: %v", err)
+	}
+	if err := os.WriteFile(offsetPATH, offsetData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateWizardWordlist(context.Background(), sourcePATH, targetPATH, countsPATH, offsetPATH, config); err != nil {
+		t.Fatalf("UpdateWizardWordlist after a crash: %v", err)
+	}
+	counts, err := os.ReadFile(countsPATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(counts) == string(first) || !strings.HasPrefix(string(counts), "3\tsunshine\n") {
+		t.Errorf("got counts %q, want sunshine counted 3 times", counts)
+	}
+}
+
+func TestFilterLinesCountsLinesUnderThePipelineFilterNames(t *testing.T) {
+	config := models.DefaultConfig()
+	counters := map[string]*metrics.Metric{}
+	before := map[string]float64{}
+	for _, name := range []string{models.FilterDigitsOrSpecial, models.FilterCharacterPolicy, models.FilterWordLikeness} {
+		counters[name] = metrics.FilterLines.With(metrics.StageGenerate, name, metrics.ResultRejected)
+		before[name] = counters[name].Value()
+	}
+
+	filtered, err := filterLines([]byte("sunshine\n12345\npässword\nxqzkt\n"), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(filtered) != "sunshine\n" {
+		t.Errorf("filterLines kept %q", filtered)
+	}
+	for name, counter := range counters {
+		if rejected := counter.Value() - before[name]; rejected != 1 {
+			t.Errorf("%s rejected %g lines, want 1", name, rejected)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package generations
+
+import (
+	"context"
+	"fmt"
+	"ponder/pkg/models"
+	"ponder/pkg/utils"
+)
+
+// Kinds of change between two generations
+const (
+	// ChangeNew lines are only in the later generation
+	ChangeNew = "new"
+	// ChangeDropped lines are only in the earlier generation
+	ChangeDropped = "dropped"
+	// ChangeMoved lines moved by at least the minimum shift
+	ChangeMoved = "moved"
+	// ChangeAll selects every kind of change
+	ChangeAll = "all"
+)
+
+// DefaultMinShift is the number of ranks a line has to move to be reported
+// as moved when no minimum is given
+const DefaultMinShift = 1000
+
+// Change is a line that is new, dropped, or moved between two generations
+type Change struct {
+	Kind    string `json:"kind"`
+	Line    string `json:"line"`
+	OldRank int    `json:"old_rank"`
+	NewRank int    `json:"new_rank"`
+}
+
+// Previous returns the newest retained generation older than a generation.
+//
+// Args:
+// project (*models.Project): The project
+// id (int): The ID of the generation
+//
+// Returns:
+// Generation: The previous generation
+// error: ErrGenerationNotFound if there is none
+func Previous(project *models.Project, id int) (Generation, error) {
+	list, err := List(project)
+	if err != nil {
+		return Generation{}, err
+	}
+	for _, generation := range list {
+		if generation.ID < id {
+			return generation, nil
+		}
+	}
+	return Generation{}, ErrGenerationNotFound
+}
+
+// Diff compares the wordlists of two generations in bounded memory and calls
+// fn for every change of the requested kind. New and moved lines are ordered
+// by their rank in the later generation and dropped lines by their rank in
+// the earlier one.
+//
+// Args:
+// ctx (context.Context): The context used to cancel the comparison
+// project (*models.Project): The project
+// fromID (int): The ID of the earlier generation
+// toID (int): The ID of the later generation
+// kind (string): ChangeNew, ChangeDropped, ChangeMoved, or ChangeAll
+// minShift (int): The number of ranks a line has to move to count as moved
+// fn (func(Change) error): Called with every change
+//
+// Returns:
+// error: ErrGenerationNotFound if either generation does not exist or an
+// error from the comparison
+func Diff(ctx context.Context, project *models.Project, fromID int, toID int, kind string, minShift int, fn func(change Change) error) error {
+	switch kind {
+	case ChangeNew, ChangeDropped, ChangeMoved, ChangeAll:
+	default:
+		return fmt.Errorf("unknown change kind: %s", kind)
+	}
+
+	fromPATH, _, err := Files(project, fromID)
+	if err != nil {
+		return err
+	}
+	toPATH, _, err := Files(project, toID)
+	if err != nil {
+		return err
+	}
+
+	include := func(change utils.RankChange) bool {
+		changeKind := classify(change, minShift)
+		return changeKind != "" && (kind == ChangeAll || kind == changeKind)
+	}
+	return utils.DiffRankedLists(ctx, fromPATH, toPATH, include, func(change utils.RankChange) error {
+		return fn(Change{
+			Kind:    classify(change, minShift),
+			Line:    change.Line,
+			OldRank: change.OldRank,
+			NewRank: change.NewRank,
+		})
+	})
+}
+
+// classify returns the kind of a rank change.
+//
+// Args:
+// change (utils.RankChange): The change
+// minShift (int): The number of ranks a line has to move to count as moved
+//
+// Returns:
+// string: The kind or empty if the line did not move far enough
+func classify(change utils.RankChange, minShift int) string {
+	switch {
+	case change.OldRank == 0:
+		return ChangeNew
+	case change.NewRank == 0:
+		return ChangeDropped
+	}
+
+	shift := change.NewRank - change.OldRank
+	if shift < 0 {
+		shift = -shift
+	}
+	if shift >= minShift {
+		return ChangeMoved
+	}
+	return ""
+}
@@ -0,0 +1,342 @@
+// Package generations keeps a numbered snapshot of every wizard wordlist a
+// project publishes so earlier generations can be downloaded and restored
+package generations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"ponder/pkg/models"
+	"ponder/pkg/utils"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// manifestFile is the name of the file listing the generations of a project
+const manifestFile = "manifest.json"
+
+// wordlistFile and countsFile are the names of the snapshot files
+const (
+	wordlistFile = "wizard-wordlist.txt"
+	countsFile   = "wizard-wordlist.counts"
+)
+
+// ErrGenerationNotFound is returned when a generation does not exist
+var ErrGenerationNotFound = errors.New("generation not found")
+
+// Generation describes a published wizard wordlist
+type Generation struct {
+	ID         int       `json:"id"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+	SourceSize int64     `json:"source_size"`
+	LineCount  int64     `json:"line_count"`
+	ConfigHash string    `json:"config_hash"`
+	Published  bool      `json:"published"`
+}
+
+// manifest is the layout of the manifest file on disk
+type manifest struct {
+	Published   int          `json:"published"`
+	Generations []Generation `json:"generations"`
+}
+
+// manifestMu guards the manifest files of every project
+var manifestMu sync.Mutex
+
+// Record snapshots the published wizard wordlist and counts of a project as a
+// new generation and drops the oldest generations beyond the retention of the
+// project. The snapshot shares the published files through hard links, which
+// is safe because generations replace the published files instead of
+// rewriting them. Nothing is recorded when the published wordlist is already
+// the published generation. project.Mu must be held.
+//
+// Args:
+// project (*models.Project): The project that published the wordlist
+// generation (Generation): The timing, source size, and line count of the
+// generation
+//
+// Returns:
+// Generation: The recorded or already published generation
+// bool: False if nothing was recorded
+// error: An error if one occurred
+func Record(project *models.Project, generation Generation) (Generation, bool, error) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	current, err := readManifest(project)
+	if err != nil {
+		return Generation{}, false, err
+	}
+
+	if current.Published != 0 && samePublishedFile(project, current.Published) {
+		for _, existing := range current.Generations {
+			if existing.ID == current.Published {
+				existing.Published = true
+				return existing, false, nil
+			}
+		}
+	}
+
+	generation.ID = 1
+	for _, existing := range current.Generations {
+		if existing.ID >= generation.ID {
+			generation.ID = existing.ID + 1
+		}
+	}
+	generation.ConfigHash, err = configHash(project.Config)
+	if err != nil {
+		return Generation{}, false, err
+	}
+
+	directory := generationDirectory(project, generation.ID)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return Generation{}, false, err
+	}
+	if err := utils.LinkOrCopy(project.WizardWordlist, filepath.Join(directory, wordlistFile)); err != nil {
+		os.RemoveAll(directory)
+		return Generation{}, false, err
+	}
+	if err := utils.LinkOrCopy(project.WizardCounts, filepath.Join(directory, countsFile)); err != nil {
+		os.RemoveAll(directory)
+		return Generation{}, false, err
+	}
+
+	current.Generations = append(current.Generations, generation)
+	current.Published = generation.ID
+	pruneGenerations(project, &current)
+	if err := writeManifest(project, current); err != nil {
+		return Generation{}, false, err
+	}
+
+	generation.Published = true
+	return generation, true, nil
+}
+
+// List returns every retained generation of a project, newest first.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// []Generation: The generations
+// error: An error if one occurred
+func List(project *models.Project) ([]Generation, error) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	current, err := readManifest(project)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Generation, 0, len(current.Generations))
+	for _, generation := range current.Generations {
+		generation.Published = generation.ID == current.Published
+		list = append(list, generation)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID > list[j].ID
+	})
+	return list, nil
+}
+
+// Get returns a retained generation of a project.
+//
+// Args:
+// project (*models.Project): The project
+// id (int): The ID of the generation
+//
+// Returns:
+// Generation: The generation
+// error: ErrGenerationNotFound if there is no such generation
+func Get(project *models.Project, id int) (Generation, error) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	current, err := readManifest(project)
+	if err != nil {
+		return Generation{}, err
+	}
+
+	for _, generation := range current.Generations {
+		if generation.ID == id {
+			generation.Published = generation.ID == current.Published
+			return generation, nil
+		}
+	}
+	return Generation{}, ErrGenerationNotFound
+}
+
+// Files returns the paths to the wordlist and counts snapshots of a
+// generation.
+//
+// Args:
+// project (*models.Project): The project
+// id (int): The ID of the generation
+//
+// Returns:
+// string: The path to the wordlist snapshot
+// string: The path to the counts snapshot
+// error: ErrGenerationNotFound if there is no such generation
+func Files(project *models.Project, id int) (string, string, error) {
+	if _, err := Get(project, id); err != nil {
+		return "", "", err
+	}
+
+	directory := generationDirectory(project, id)
+	return filepath.Join(directory, wordlistFile), filepath.Join(directory, countsFile), nil
+}
+
+// SetPublished records which generation the published files belong to.
+//
+// Args:
+// project (*models.Project): The project
+// id (int): The ID of the generation
+//
+// Returns:
+// error: ErrGenerationNotFound if there is no such generation
+func SetPublished(project *models.Project, id int) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	current, err := readManifest(project)
+	if err != nil {
+		return err
+	}
+
+	for _, generation := range current.Generations {
+		if generation.ID == id {
+			current.Published = id
+			return writeManifest(project, current)
+		}
+	}
+	return ErrGenerationNotFound
+}
+
+// pruneGenerations drops the oldest generations beyond the retention of the
+// project. The published generation is always kept.
+//
+// Args:
+// project (*models.Project): The project
+// current (*manifest): The manifest to prune
+//
+// Returns:
+// None
+func pruneGenerations(project *models.Project, current *manifest) {
+	sort.Slice(current.Generations, func(i, j int) bool {
+		return current.Generations[i].ID > current.Generations[j].ID
+	})
+
+	kept := current.Generations[:0]
+	for i, generation := range current.Generations {
+		if i < project.Config.GenerationRetention || generation.ID == current.Published {
+			kept = append(kept, generation)
+			continue
+		}
+		if err := os.RemoveAll(generationDirectory(project, generation.ID)); err != nil {
+			utils.LogInternalError("Error removing generation", fmt.Sprintf("Project: %s, Generation: %d, Error: %v", project.Name, generation.ID, err))
+		}
+	}
+	current.Generations = kept
+}
+
+// samePublishedFile checks if the published wordlist of a project is the
+// snapshot of a generation.
+//
+// Args:
+// project (*models.Project): The project
+// id (int): The ID of the generation
+//
+// Returns:
+// bool: True if both paths are the same file
+func samePublishedFile(project *models.Project, id int) bool {
+	published, err := os.Stat(project.WizardWordlist)
+	if err != nil {
+		return false
+	}
+	snapshot, err := os.Stat(filepath.Join(generationDirectory(project, id), wordlistFile))
+	if err != nil {
+		return false
+	}
+	return os.SameFile(published, snapshot)
+}
+
+// readManifest reads the manifest of a project. manifestMu must be held.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// manifest: The manifest or an empty one if none has been written
+// error: An error if one occurred
+func readManifest(project *models.Project) (manifest, error) {
+	var current manifest
+	data, err := os.ReadFile(filepath.Join(project.GenerationsDirectory, manifestFile))
+	if os.IsNotExist(err) {
+		return current, nil
+	}
+	if err != nil {
+		return current, err
+	}
+	if err := json.Unmarshal(data, &current); err != nil {
+		return current, fmt.Errorf("invalid generations manifest: %w", err)
+	}
+	return current, nil
+}
+
+// writeManifest replaces the manifest of a project. manifestMu must be held.
+//
+// Args:
+// project (*models.Project): The project
+// current (manifest): The manifest
+//
+// Returns:
+// error: An error if one occurred
+func writeManifest(project *models.Project, current manifest) error {
+	for i := range current.Generations {
+		current.Generations[i].Published = false
+	}
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(project.GenerationsDirectory, manifestFile), data, 0644)
+}
+
+// generationDirectory returns the directory holding the snapshots of a
+// generation.
+//
+// Args:
+// project (*models.Project): The project
+// id (int): The ID of the generation
+//
+// Returns:
+// string: The directory
+func generationDirectory(project *models.Project, id int) string {
+	return filepath.Join(project.GenerationsDirectory, strconv.Itoa(id))
+}
+
+// configHash returns the SHA-256 hash of a configuration so generations made
+// with different settings can be told apart.
+//
+// Args:
+// config (*models.Config): The configuration
+//
+// Returns:
+// string: The hex encoded hash
+// error: An error if one occurred
+func configHash(config *models.Config) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
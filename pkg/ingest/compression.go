@@ -0,0 +1,146 @@
+package ingest
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Source is an uploaded or imported file that can be read from any offset.
+// Both *os.File and multipart.File satisfy it.
+type Source interface {
+	io.Reader
+	io.ReaderAt
+}
+
+// Magic bytes used to detect the compression of a file
+var (
+	gzipMagic     = []byte{0x1f, 0x8b}
+	bzip2Magic    = []byte("BZh")
+	zstdMagic     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic      = []byte("PK\x03\x04")
+	emptyZipMagic = []byte("PK\x05\x06")
+)
+
+// AppendFileToWordlist detects the compression of the source from its magic
+// bytes and streams the decompressed data through AppendToWordlist. Zip
+// archives have every file they contain appended in turn. Decompressed data
+// is never written to disk.
+//
+// Args:
+// src (Source): The uploaded or imported file
+// size (int64): The size of the file in bytes
+// targetPATH (string): The path to the target wordlist
+// opts (Options): The ingest options
+//
+// Returns:
+// error: An error if one occurred
+func AppendFileToWordlist(src Source, size int64, targetPATH string, opts Options) error {
+	magic := make([]byte, len(zipMagic))
+	n, err := src.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("error reading file header: %w", err)
+	}
+	magic = magic[:n]
+
+	if bytes.HasPrefix(magic, zipMagic) || bytes.HasPrefix(magic, emptyZipMagic) {
+		archive, err := zip.NewReader(src, size)
+		if err != nil {
+			return fmt.Errorf("error opening zip archive: %w", err)
+		}
+		for _, entry := range archive.File {
+			if entry.FileInfo().IsDir() {
+				continue
+			}
+			if err := appendZipEntry(entry, targetPATH, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return appendStream(io.NewSectionReader(src, 0, size), targetPATH, opts)
+}
+
+// appendZipEntry appends a single file from a zip archive to the wordlist.
+//
+// Args:
+// entry (*zip.File): The file within the archive
+// targetPATH (string): The path to the target wordlist
+// opts (Options): The ingest options
+//
+// Returns:
+// error: An error if one occurred
+func appendZipEntry(entry *zip.File, targetPATH string, opts Options) error {
+	reader, err := entry.Open()
+	if err != nil {
+		return fmt.Errorf("error opening %s in zip archive: %w", entry.Name, err)
+	}
+	defer reader.Close()
+
+	if err := appendStream(reader, targetPATH, opts); err != nil {
+		return fmt.Errorf("error reading %s in zip archive: %w", entry.Name, err)
+	}
+	return nil
+}
+
+// appendStream detects gzip, bzip2 and zstd streams by their magic bytes and
+// appends the decompressed data to the wordlist. Anything else is treated as
+// plain text.
+//
+// Args:
+// r (io.Reader): The possibly compressed data
+// targetPATH (string): The path to the target wordlist
+// opts (Options): The ingest options
+//
+// Returns:
+// error: An error if one occurred
+func appendStream(r io.Reader, targetPATH string, opts Options) error {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("error reading stream header: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return fmt.Errorf("error opening gzip stream: %w", err)
+		}
+		defer gzipReader.Close()
+		return AppendToWordlist(gzipReader, targetPATH, opts)
+	case isBzip2(magic):
+		return AppendToWordlist(bzip2.NewReader(buffered), targetPATH, opts)
+	case bytes.HasPrefix(magic, zstdMagic):
+		// Low memory mode and a single goroutine keep the decoder suitable
+		// for memory constrained devices
+		zstdReader, err := zstd.NewReader(buffered, zstd.WithDecoderLowmem(true), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("error opening zstd stream: %w", err)
+		}
+		defer zstdReader.Close()
+		return AppendToWordlist(zstdReader, targetPATH, opts)
+	default:
+		return AppendToWordlist(buffered, targetPATH, opts)
+	}
+}
+
+// isBzip2 reports whether a stream header starts a bzip2 stream. The magic
+// bytes are followed by the block size from '1' to '9', so plain text that
+// happens to start with "BZh" is not taken for a bzip2 stream.
+//
+// Args:
+// magic ([]byte): The first bytes of the stream
+//
+// Returns:
+// bool: True if the stream is bzip2 compressed
+func isBzip2(magic []byte) bool {
+	return len(magic) > len(bzip2Magic) && bytes.HasPrefix(magic, bzip2Magic) && magic[len(bzip2Magic)] >= '1' && magic[len(bzip2Magic)] <= '9'
+}
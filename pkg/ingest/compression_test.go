@@ -0,0 +1,52 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"ponder/pkg/models"
+	"strings"
+	"testing"
+)
+
+// bzip2Compressed is "compressed\n" compressed with bzip2 -9
+var bzip2Compressed = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x7e, 0x03, 0xdd, 0xfe, 0x00, 0x00,
+	0x00, 0xc1, 0x80, 0x00, 0x10, 0x0e, 0x02, 0xd8, 0x00, 0x20, 0x00, 0x22, 0x00, 0xcd, 0x42, 0x0c,
+	0x98, 0x8b, 0xac, 0x06, 0x99, 0xcb, 0xc5, 0xdc, 0x91, 0x4e, 0x14, 0x24, 0x1f, 0x80, 0xf7, 0x7f,
+	0x80,
+}
+
+// appendFile ingests a file into a new wordlist and returns the wordlist.
+func appendFile(t *testing.T, data []byte) string {
+	t.Helper()
+
+	target := filepath.Join(t.TempDir(), "source-wordlist.txt")
+	if err := os.WriteFile(target, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Format: Format{Name: FormatRaw}, Config: models.DefaultConfig()}
+	if err := AppendFileToWordlist(strings.NewReader(string(data)), int64(len(data)), target, opts); err != nil {
+		t.Fatalf("AppendFileToWordlist: %v", err)
+	}
+
+	output, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output)
+}
+
+func TestAppendFileToWordlistDetectsBzip2(t *testing.T) {
+	if output := appendFile(t, bzip2Compressed); output != "compressed\n" {
+		t.Errorf("got %q, want %q", output, "compressed\n")
+	}
+}
+
+func TestAppendFileToWordlistKeepsPlainTextStartingWithBzip2Magic(t *testing.T) {
+	// The default filters lowercase every candidate
+	for _, input := range []string{"BZhello\n", "BZh0world\n", "BZh:password\n"} {
+		if output := appendFile(t, []byte(input)); output != strings.ToLower(input) {
+			t.Errorf("input %q: got %q", input, output)
+		}
+	}
+}
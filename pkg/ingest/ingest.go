@@ -0,0 +1,399 @@
+// Package ingest contains the code that filters uploaded and imported data
+// before it is added to the source wordlist
+package ingest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"ponder/pkg/metrics"
+	"ponder/pkg/models"
+	"ponder/pkg/utils"
+	"strings"
+	"sync/atomic"
+)
+
+// readBufferSize is the number of bytes read from the input at a time
+var readBufferSize = 4 * 1024 * 1024
+
+// Format describes how the plaintext is laid out within each raw line
+type Format struct {
+	// Name is the name of the format as given in the request
+	Name string
+	// Fields is the number of colon separated fields before the plaintext
+	Fields int
+}
+
+// Options controls how data is ingested
+type Options struct {
+	// Format is the layout of the lines in the data
+	Format Format
+	// Config is the configuration whose filtering rules are applied
+	Config *models.Config
+	// Analyzers observe every plaintext before it is filtered
+	Analyzers []Analyzer
+	// Stats counts the progress of the ingest when set
+	Stats *Stats
+	// Project labels the upload metrics of the ingest
+	Project string
+	// Known recognizes candidates that were already ingested when set
+	Known KnownCandidates
+	// SkipKnown drops the candidates recognized by Known instead of
+	// appending them again
+	SkipKnown bool
+}
+
+// KnownCandidates remembers the candidates that were added to the source
+// wordlist, for example in a dedup.Filter
+type KnownCandidates interface {
+	// TestAndAdd remembers a candidate and returns true if it was seen before
+	TestAndAdd(candidate string) bool
+}
+
+// Stats counts the progress of an ingest and may be read while it runs
+type Stats struct {
+	// BytesProcessed is the number of decompressed bytes read
+	BytesProcessed atomic.Int64
+	// LinesAccepted is the number of lines that produced a candidate
+	LinesAccepted atomic.Int64
+	// LinesRejected is the number of lines that were skipped or filtered
+	LinesRejected atomic.Int64
+	// LinesKnown is the number of lines whose candidates were all
+	// recognized by Options.Known
+	LinesKnown atomic.Int64
+	// CandidatesWritten is the number of candidates appended to the target
+	CandidatesWritten atomic.Int64
+	// BytesWritten is the number of bytes appended to the target
+	BytesWritten atomic.Int64
+}
+
+// Analyzer observes the decoded plaintexts of an upload before the ingest
+// filters are applied, for example to derive rules from them
+type Analyzer interface {
+	// Observe is called with every decoded plaintext
+	Observe(plaintext string)
+	// Flush writes out anything the analyzer has collected
+	Flush() error
+}
+
+// Supported format names
+const (
+	// FormatRaw is one plaintext per line
+	FormatRaw = "raw"
+	// FormatPotfile is hashcat potfile or --show output as HASH:PLAIN
+	FormatPotfile = "potfile"
+	// FormatSaltedPotfile is hashcat output for salted modes as HASH:SALT:PLAIN
+	FormatSaltedPotfile = "potfile-salted"
+)
+
+// ParseFormat converts a format name into a Format. An empty name is treated
+// as raw. When username is true an additional leading field is expected, as
+// written by hashcat with --username.
+//
+// Args:
+// name (string): The name of the format
+// username (bool): True if every line is prefixed with a username field
+//
+// Returns:
+// Format: The parsed format
+// error: An error if the format is unknown
+func ParseFormat(name string, username bool) (Format, error) {
+	format := Format{Name: name}
+	switch name {
+	case "", FormatRaw:
+		format.Name = FormatRaw
+	case FormatPotfile:
+		format.Fields = 1
+	case FormatSaltedPotfile:
+		format.Fields = 2
+	default:
+		return Format{}, fmt.Errorf("unknown ingest format: %s", name)
+	}
+
+	if username {
+		format.Fields++
+	}
+
+	return format, nil
+}
+
+// ExtractPlaintext returns the plaintext portion of a line in the given
+// format. The plaintext is everything after the leading fields, so
+// plaintexts that contain colons are kept intact.
+//
+// Args:
+// line (string): The raw line
+// format (Format): The layout of the line
+//
+// Returns:
+// string: The plaintext
+// bool: False if the line does not have enough fields for the format
+func ExtractPlaintext(line string, format Format) (string, bool) {
+	if format.Fields == 0 {
+		return line, true
+	}
+
+	parts := strings.SplitN(line, ":", format.Fields+1)
+	if len(parts) <= format.Fields {
+		return "", false
+	}
+
+	return parts[format.Fields], true
+}
+
+// AppendToWordlist streams the reader through the ingest filters and appends
+// every accepted line to the target wordlist. Lines are read with
+// utils.ReadLineChunks so lines that straddle a buffer boundary are kept
+// intact. Candidates recognized by opts.Known are counted and, when
+// opts.SkipKnown is set, not appended again.
+//
+// Args:
+// r (io.Reader): The data to ingest
+// targetPATH (string): The path to the target wordlist
+// opts (Options): The ingest options
+//
+// Returns:
+// error: An error if one occurred
+func AppendToWordlist(r io.Reader, targetPATH string, opts Options) error {
+	targetFile, err := os.OpenFile(targetPATH, os.O_APPEND|os.O_RDWR, os.ModeAppend)
+	if err != nil {
+		return fmt.Errorf("error opening target file %s: %w", targetPATH, err)
+	}
+	defer targetFile.Close()
+
+	if err := ensureTrailingNewline(targetFile); err != nil {
+		return fmt.Errorf("error preparing target file %s: %w", targetPATH, err)
+	}
+
+	writer := bufio.NewWriter(targetFile)
+	err = utils.ReadLineChunks(r, readBufferSize, func(chunk []byte) error {
+		var accepted, rejected, known, candidatesWritten, bytesWritten int64
+		for _, line := range strings.Split(string(chunk), "\n") {
+			if line == "" {
+				continue
+			}
+			plaintext, ok := ExtractPlaintext(strings.TrimRight(line, "\r"), opts.Format)
+			if !ok {
+				rejected++
+				continue
+			}
+			plaintext = decodePlaintext(plaintext)
+			for _, analyzer := range opts.Analyzers {
+				analyzer.Observe(plaintext)
+			}
+			candidates := filterPlaintext(plaintext, opts.Config)
+			if len(candidates) == 0 {
+				rejected++
+				continue
+			}
+			knownCandidates := 0
+			for _, candidate := range candidates {
+				if opts.Known != nil && opts.Known.TestAndAdd(candidate) {
+					knownCandidates++
+					if opts.SkipKnown {
+						continue
+					}
+				}
+				if _, err := writer.WriteString(candidate + "\n"); err != nil {
+					return fmt.Errorf("error writing to target file %s: %w", targetPATH, err)
+				}
+				candidatesWritten++
+				bytesWritten += int64(len(candidate)) + 1
+			}
+			if knownCandidates == len(candidates) {
+				known++
+				if opts.SkipKnown {
+					rejected++
+					continue
+				}
+			}
+			accepted++
+		}
+
+		metrics.UploadBytes.With(opts.Project).Add(float64(len(chunk)))
+		metrics.UploadLines.With(opts.Project, metrics.ResultAccepted).Add(float64(accepted))
+		metrics.UploadLines.With(opts.Project, metrics.ResultRejected).Add(float64(rejected))
+		metrics.UploadKnownLines.With(opts.Project).Add(float64(known))
+		if opts.Stats != nil {
+			opts.Stats.BytesProcessed.Add(int64(len(chunk)))
+			opts.Stats.LinesAccepted.Add(accepted)
+			opts.Stats.LinesRejected.Add(rejected)
+			opts.Stats.LinesKnown.Add(known)
+			opts.Stats.CandidatesWritten.Add(candidatesWritten)
+			opts.Stats.BytesWritten.Add(bytesWritten)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing to target file %s: %w", targetPATH, err)
+	}
+
+	return nil
+}
+
+// FilterLine decodes a single raw line, applies the character policy, and
+// runs every resulting variant through the quality filters.
+//
+// Args:
+// line (string): The raw line
+// config (*models.Config): The configuration whose filtering rules are applied
+//
+// Returns:
+// []string: The normalized candidates that should be added to the wordlist
+func FilterLine(line string, config *models.Config) []string {
+	return filterPlaintext(decodePlaintext(line), config)
+}
+
+// FlushAnalyzers flushes every analyzer and returns the first error.
+//
+// Args:
+// analyzers ([]Analyzer): The analyzers to flush
+//
+// Returns:
+// error: An error if one occurred
+func FlushAnalyzers(analyzers []Analyzer) error {
+	var firstErr error
+	for _, analyzer := range analyzers {
+		if err := analyzer.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// decodePlaintext converts $HEX[] lines to plaintext and leaves lines that
+// fail to decode as they are.
+//
+// Args:
+// line (string): The raw line
+//
+// Returns:
+// string: The decoded plaintext
+func decodePlaintext(line string) string {
+	convertedLine, err := models.ConvertHexToPlaintext(line)
+	if err != nil {
+		return line
+	}
+	return convertedLine
+}
+
+// filterPlaintext applies the character policy to a decoded plaintext and
+// runs every resulting variant through the quality filters.
+//
+// Args:
+// plaintext (string): The decoded plaintext
+// config (*models.Config): The configuration whose filtering rules are applied
+//
+// Returns:
+// []string: The normalized candidates that should be added to the wordlist
+func filterPlaintext(plaintext string, config *models.Config) []string {
+	variants := utils.CandidateVariants(plaintext, config.Characters)
+	if len(variants) == 0 {
+		characterPolicyLines[metrics.ResultRejected].Inc()
+		return nil
+	}
+	characterPolicyLines[metrics.ResultAccepted].Inc()
+
+	var candidates []string
+	for _, variant := range variants {
+		// Decoding and normalizing can make a line longer, and the source
+		// wordlist must only hold lines that generation can scan
+		if len(variant) > utils.MaxLineLength {
+			continue
+		}
+		if !passesIngestFilters(variant, config) {
+			continue
+		}
+		candidates = append(candidates, strings.TrimSpace(strings.ToLower(variant)))
+	}
+
+	return candidates
+}
+
+// ingestFilters maps the filter names used in the pipeline configuration to
+// checks that return true when a line should be kept
+var ingestFilters = map[string]func(line string, config *models.Config) bool{
+	models.FilterDigitsOrSpecial: func(line string, config *models.Config) bool {
+		return !utils.IsAllDigitsOrSpecialChars(line)
+	},
+	models.FilterWordLikeness: func(line string, config *models.Config) bool {
+		return utils.LikelyContainsWords(line, config.Pipeline.WordLikeness)
+	},
+	models.FilterBlocklist: func(line string, config *models.Config) bool {
+		return utils.IsQualityCandidateCheck(line, config.Pipeline.BlocklistRegexps())
+	},
+}
+
+// filterLines holds the accepted and rejected line counters of every ingest
+// filter so they are not looked up for every line
+var filterLines = func() map[string]map[string]*metrics.Metric {
+	counters := map[string]map[string]*metrics.Metric{}
+	for name := range ingestFilters {
+		counters[name] = map[string]*metrics.Metric{
+			metrics.ResultAccepted: metrics.FilterLines.With(metrics.StageIngest, name, metrics.ResultAccepted),
+			metrics.ResultRejected: metrics.FilterLines.With(metrics.StageIngest, name, metrics.ResultRejected),
+		}
+	}
+	return counters
+}()
+
+// characterPolicyLines holds the line counters of the character policy
+var characterPolicyLines = map[string]*metrics.Metric{
+	metrics.ResultAccepted: metrics.FilterLines.With(metrics.StageIngest, models.FilterCharacterPolicy, metrics.ResultAccepted),
+	metrics.ResultRejected: metrics.FilterLines.With(metrics.StageIngest, models.FilterCharacterPolicy, metrics.ResultRejected),
+}
+
+// passesIngestFilters runs a line through the configured ingest filters in
+// order and stops at the first rejection.
+//
+// Args:
+// line (string): The candidate to check
+// config (*models.Config): The configuration declaring the filters
+//
+// Returns:
+// bool: True if every filter accepted the line
+func passesIngestFilters(line string, config *models.Config) bool {
+	for _, name := range config.Pipeline.IngestFilters {
+		if !ingestFilters[name](line, config) {
+			filterLines[name][metrics.ResultRejected].Inc()
+			return false
+		}
+		filterLines[name][metrics.ResultAccepted].Inc()
+	}
+	return true
+}
+
+// ensureTrailingNewline writes a newline to the end of the file if it is not
+// empty and does not already end with one, so appended data always starts on
+// a new line.
+//
+// Args:
+// file (*os.File): The file opened for reading and appending
+//
+// Returns:
+// error: An error if one occurred
+func ensureTrailingNewline(file *os.File) error {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if fileInfo.Size() == 0 {
+		return nil
+	}
+
+	lastByte := make([]byte, 1)
+	if _, err := file.ReadAt(lastByte, fileInfo.Size()-1); err != nil {
+		return err
+	}
+	if lastByte[0] == '\n' {
+		return nil
+	}
+
+	_, err = file.Write([]byte("\n"))
+	return err
+}
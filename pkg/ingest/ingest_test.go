@@ -0,0 +1,85 @@
+package ingest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"ponder/pkg/metrics"
+	"ponder/pkg/models"
+	"strings"
+	"testing"
+)
+
+// appendWithBufferSize ingests the input into a new wordlist with the given
+// read buffer size and returns the wordlist.
+func appendWithBufferSize(t *testing.T, input string, bufferSize int, opts Options) string {
+	t.Helper()
+
+	previous := readBufferSize
+	readBufferSize = bufferSize
+	defer func() { readBufferSize = previous }()
+
+	target := filepath.Join(t.TempDir(), "source-wordlist.txt")
+	if err := os.WriteFile(target, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendToWordlist(strings.NewReader(input), target, opts); err != nil {
+		t.Fatalf("AppendToWordlist with buffer size %d: %v", bufferSize, err)
+	}
+
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestAppendToWordlistIdenticalForEveryBufferSize(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&input, "hash%d:Summer%sTime%d", i, strings.Repeat("o", i%40), i)
+		if i%4 == 0 {
+			input.WriteString("\r")
+		}
+		input.WriteString("\n")
+	}
+	input.WriteString("hashfinal:lastpassword")
+
+	opts := Options{Format: Format{Name: FormatPotfile, Fields: 1}, Config: models.DefaultConfig()}
+	expected := appendWithBufferSize(t, input.String(), 256*1024, opts)
+	if lines := strings.Count(expected, "\n"); lines < 3000 {
+		t.Fatalf("only %d candidates were written", lines)
+	}
+	if strings.Contains(expected, "\r") {
+		t.Fatal("carriage returns were kept")
+	}
+	if !strings.HasSuffix(expected, "lastpassword\n") {
+		t.Fatal("the final line without a newline was not ingested")
+	}
+
+	for _, size := range []int{1, 7, 4096} {
+		if output := appendWithBufferSize(t, input.String(), size, opts); output != expected {
+			t.Errorf("buffer size %d: wordlist differs from buffer size %d", size, 256*1024)
+		}
+	}
+}
+
+func TestAppendToWordlistCountsFilterLinesInIngestStage(t *testing.T) {
+	opts := Options{Format: Format{Name: FormatRaw}, Config: models.DefaultConfig()}
+	accepted := metrics.FilterLines.With(metrics.StageIngest, models.FilterBlocklist, metrics.ResultAccepted)
+	rejected := metrics.FilterLines.With(metrics.StageIngest, models.FilterBlocklist, metrics.ResultRejected)
+	generated := metrics.FilterLines.With(metrics.StageGenerate, models.FilterBlocklist, metrics.ResultAccepted)
+	acceptedBefore, rejectedBefore, generatedBefore := accepted.Value(), rejected.Value(), generated.Value()
+
+	appendWithBufferSize(t, "sunshine\nhttps://example.com/login\n", 4096, opts)
+
+	if accepted.Value() <= acceptedBefore {
+		t.Error("accepted lines were not counted in the ingest stage")
+	}
+	if rejected.Value() <= rejectedBefore {
+		t.Error("rejected lines were not counted in the ingest stage")
+	}
+	if generated.Value() != generatedBefore {
+		t.Error("an ingest counted lines in the generate stage")
+	}
+}
@@ -0,0 +1,387 @@
+// Package jobs runs uploads, imports, and generations in the background and
+// tracks their progress
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"ponder/pkg/ingest"
+	"ponder/pkg/models"
+	"ponder/pkg/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job kinds
+const (
+	// KindUpload processes a staged upload
+	KindUpload = "upload"
+	// KindImport processes the files of an import directory
+	KindImport = "import"
+	// KindGenerate regenerates the wizard wordlist of a project
+	KindGenerate = "generate"
+)
+
+// Job states
+const (
+	// StateQueued jobs are waiting for a worker
+	StateQueued = "queued"
+	// StateRunning jobs are being processed
+	StateRunning = "running"
+	// StateCompleted jobs finished without an error
+	StateCompleted = "completed"
+	// StateFailed jobs finished with an error
+	StateFailed = "failed"
+)
+
+// maxRetainedJobs is the number of jobs kept for status requests. The oldest
+// finished jobs are dropped first.
+const maxRetainedJobs = 1000
+
+// queueSize is the number of jobs that can wait for a worker before
+// Enqueue refuses new ones
+const queueSize = 1024
+
+// ErrJobNotFound is returned when a job does not exist
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrQueueFull is returned when every slot of the queue is taken
+var ErrQueueFull = errors.New("job queue is full")
+
+// Job is a unit of background work
+type Job struct {
+	id      string
+	kind    string
+	project string
+	size    int64
+	created time.Time
+	run     func(job *Job) error
+	stats   ingest.Stats
+
+	mu       sync.Mutex
+	state    string
+	err      string
+	started  time.Time
+	finished time.Time
+}
+
+// Status is a snapshot of a job for API responses
+type Status struct {
+	ID             string     `json:"id"`
+	Kind           string     `json:"kind"`
+	Project        string     `json:"project"`
+	State          string     `json:"state"`
+	Size           int64      `json:"size,omitempty"`
+	BytesProcessed int64      `json:"bytes_processed"`
+	LinesAccepted  int64      `json:"lines_accepted"`
+	LinesRejected  int64      `json:"lines_rejected"`
+	LinesKnown     int64      `json:"lines_known"`
+	Error          string     `json:"error,omitempty"`
+	Created        time.Time  `json:"created"`
+	Started        *time.Time `json:"started,omitempty"`
+	Finished       *time.Time `json:"finished,omitempty"`
+}
+
+// jobsMu guards the job registry
+var jobsMu sync.RWMutex
+
+// jobs holds every retained job by ID
+var jobs = map[string]*Job{}
+
+// queue feeds jobs to the workers
+var queue = make(chan *Job, queueSize)
+
+// StartWorkers starts the workers that process queued jobs.
+//
+// Args:
+// workers (int): The number of jobs processed at once
+//
+// Returns:
+// None
+func StartWorkers(workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range queue {
+				job.execute()
+			}
+		}()
+	}
+}
+
+// Enqueue registers a job and queues it for the workers. It never blocks, a
+// job that does not fit into the queue is dropped again.
+//
+// Args:
+// kind (string): The kind of job
+// project (string): The name of the project the job belongs to
+// size (int64): The number of bytes to process or 0 if unknown
+// run (func(*Job) error): The work to perform
+//
+// Returns:
+// (*Job): The queued job
+// (error): ErrQueueFull if the queue has no free slot
+func Enqueue(kind string, project string, size int64, run func(job *Job) error) (*Job, error) {
+	job := register(kind, project, size, run)
+	select {
+	case queue <- job:
+		return job, nil
+	default:
+		jobsMu.Lock()
+		delete(jobs, job.id)
+		jobsMu.Unlock()
+		return nil, ErrQueueFull
+	}
+}
+
+// QueueFull reports whether Enqueue would currently refuse a job, so
+// handlers can turn requests away before staging their data.
+//
+// Args:
+// None
+//
+// Returns:
+// (bool): True if the queue has no free slot
+func QueueFull() bool {
+	return len(queue) >= cap(queue)
+}
+
+// Run registers a job and performs it on the calling goroutine, so work that
+// is already scheduled elsewhere is reported like any other job.
+//
+// Args:
+// kind (string): The kind of job
+// project (string): The name of the project the job belongs to
+// run (func(*Job) error): The work to perform
+//
+// Returns:
+// (*Job): The finished job
+func Run(kind string, project string, run func(job *Job) error) *Job {
+	job := register(kind, project, 0, run)
+	job.execute()
+	return job
+}
+
+// Start registers a job and performs it on its own goroutine, so long running
+// work does not hold up the workers processing uploads and imports.
+//
+// Args:
+// kind (string): The kind of job
+// project (string): The name of the project the job belongs to
+// run (func(*Job) error): The work to perform
+//
+// Returns:
+// (*Job): The started job
+func Start(kind string, project string, run func(job *Job) error) *Job {
+	job := register(kind, project, 0, run)
+	go job.execute()
+	return job
+}
+
+// Get returns the status of a job.
+//
+// Args:
+// id (string): The ID of the job
+//
+// Returns:
+// (Status): The status of the job
+// (error): ErrJobNotFound if there is no such job
+func Get(id string) (Status, error) {
+	jobsMu.RLock()
+	job, ok := jobs[id]
+	jobsMu.RUnlock()
+	if !ok {
+		return Status{}, ErrJobNotFound
+	}
+	return job.Status(), nil
+}
+
+// List returns the status of every retained job, newest first.
+//
+// Args:
+// None
+//
+// Returns:
+// ([]Status): The job statuses
+func List() []Status {
+	jobsMu.RLock()
+	list := make([]Status, 0, len(jobs))
+	for _, job := range jobs {
+		list = append(list, job.Status())
+	}
+	jobsMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+	return list
+}
+
+// ID returns the ID of the job.
+//
+// Args:
+// None
+//
+// Returns:
+// (string): The job ID
+func (j *Job) ID() string {
+	return j.id
+}
+
+// Stats returns the counters the work updates while it runs.
+//
+// Args:
+// None
+//
+// Returns:
+// (*ingest.Stats): The job counters
+func (j *Job) Stats() *ingest.Stats {
+	return &j.stats
+}
+
+// Status returns a snapshot of the job.
+//
+// Args:
+// None
+//
+// Returns:
+// (Status): The job status
+func (j *Job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := Status{
+		ID:             j.id,
+		Kind:           j.kind,
+		Project:        j.project,
+		State:          j.state,
+		Size:           j.size,
+		BytesProcessed: j.stats.BytesProcessed.Load(),
+		LinesAccepted:  j.stats.LinesAccepted.Load(),
+		LinesRejected:  j.stats.LinesRejected.Load(),
+		LinesKnown:     j.stats.LinesKnown.Load(),
+		Error:          j.err,
+		Created:        j.created,
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		status.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		status.Finished = &finished
+	}
+	return status
+}
+
+// execute performs the work of the job and records the outcome.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func (j *Job) execute() {
+	j.mu.Lock()
+	j.state = StateRunning
+	j.started = time.Now()
+	j.mu.Unlock()
+
+	err := j.run(j)
+
+	j.mu.Lock()
+	j.finished = time.Now()
+	if err != nil {
+		j.state = StateFailed
+		j.err = err.Error()
+	} else {
+		j.state = StateCompleted
+	}
+	j.mu.Unlock()
+
+	if err != nil {
+		utils.LogEvent(models.LogLevelError, "Job failed", fmt.Sprintf("Job: %s, Kind: %s, Project: %s, Error: %v", j.id, j.kind, j.project, err), models.LogFields{
+			"job":      j.id,
+			"kind":     j.kind,
+			"project":  j.project,
+			"duration": j.finished.Sub(j.started).String(),
+		})
+	}
+}
+
+// register creates a queued job and adds it to the registry, dropping the
+// oldest finished jobs when the registry is full.
+//
+// Args:
+// kind (string): The kind of job
+// project (string): The name of the project the job belongs to
+// size (int64): The number of bytes to process or 0 if unknown
+// run (func(*Job) error): The work to perform
+//
+// Returns:
+// (*Job): The registered job
+func register(kind string, project string, size int64, run func(job *Job) error) *Job {
+	job := &Job{
+		id:      newID(),
+		kind:    kind,
+		project: project,
+		size:    size,
+		created: time.Now(),
+		run:     run,
+		state:   StateQueued,
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	jobs[job.id] = job
+	if len(jobs) > maxRetainedJobs {
+		pruneJobs()
+	}
+	return job
+}
+
+// pruneJobs drops the oldest finished jobs until the registry is within its
+// limit. jobsMu must be held.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func pruneJobs() {
+	var finished []*Job
+	for _, job := range jobs {
+		job.mu.Lock()
+		if !job.finished.IsZero() {
+			finished = append(finished, job)
+		}
+		job.mu.Unlock()
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].created.Before(finished[j].created)
+	})
+
+	for _, job := range finished {
+		if len(jobs) <= maxRetainedJobs {
+			return
+		}
+		delete(jobs, job.id)
+	}
+}
+
+// newID returns a random job ID.
+//
+// Args:
+// None
+//
+// Returns:
+// (string): The job ID
+func newID() string {
+	id := make([]byte, 8)
+	// crypto/rand only fails if the system has no entropy source at all
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,43 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEnqueueRefusesJobsWhenTheQueueIsFull(t *testing.T) {
+	// No workers are started, so every queued job stays in the queue
+	t.Cleanup(func() {
+		for len(queue) > 0 {
+			<-queue
+		}
+	})
+	run := func(job *Job) error { return nil }
+
+	for i := 0; i < queueSize; i++ {
+		if _, err := Enqueue(KindUpload, "test", 0, run); err != nil {
+			t.Fatalf("job %d: %v", i, err)
+		}
+	}
+	if !QueueFull() {
+		t.Fatal("QueueFull is false with every slot taken")
+	}
+
+	jobsMu.RLock()
+	registered := len(jobs)
+	jobsMu.RUnlock()
+
+	job, err := Enqueue(KindUpload, "test", 0, run)
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("got error %v, want ErrQueueFull", err)
+	}
+	if job != nil {
+		t.Error("a job was returned for a full queue")
+	}
+
+	jobsMu.RLock()
+	defer jobsMu.RUnlock()
+	if len(jobs) != registered {
+		t.Errorf("the refused job was kept in the registry (%d jobs, want %d)", len(jobs), registered)
+	}
+}
@@ -0,0 +1,156 @@
+// Package masks derives hashcat masks from uploaded plaintexts in the style
+// of PACK mask analysis
+package masks
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"ponder/pkg/utils"
+	"strconv"
+	"strings"
+)
+
+// maxMaskLength is the longest plaintext in bytes a mask is derived from
+const maxMaskLength = 64
+
+// specialCharacters is the hashcat ?s charset
+const specialCharacters = " !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// charsetSizes is the number of candidates of every built-in hashcat charset
+var charsetSizes = map[byte]int64{
+	'l': 26,
+	'u': 26,
+	'd': 10,
+	's': int64(len(specialCharacters)),
+	'a': 95,
+	'b': 256,
+}
+
+// RankedMask is a mask with the number of plaintexts it matched
+type RankedMask struct {
+	Mask     string
+	Count    int
+	Keyspace *big.Int
+}
+
+// DeriveMask returns the hashcat mask of a plaintext using ?l, ?u, ?d and ?s
+// for printable ASCII and ?b for every other byte.
+//
+// Args:
+// plaintext (string): The decoded plaintext
+//
+// Returns:
+// string: The mask
+// bool: False if the plaintext is empty or too long
+func DeriveMask(plaintext string) (string, bool) {
+	if len(plaintext) == 0 || len(plaintext) > maxMaskLength {
+		return "", false
+	}
+
+	var mask strings.Builder
+	for i := 0; i < len(plaintext); i++ {
+		c := plaintext[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+			mask.WriteString("?l")
+		case c >= 'A' && c <= 'Z':
+			mask.WriteString("?u")
+		case c >= '0' && c <= '9':
+			mask.WriteString("?d")
+		case strings.IndexByte(specialCharacters, c) >= 0:
+			mask.WriteString("?s")
+		default:
+			mask.WriteString("?b")
+		}
+	}
+
+	return mask.String(), true
+}
+
+// Keyspace returns the number of candidates a mask of built-in charsets
+// produces.
+//
+// Args:
+// mask (string): The mask
+//
+// Returns:
+// *big.Int: The keyspace
+// error: An error if the mask contains anything but built-in charsets
+func Keyspace(mask string) (*big.Int, error) {
+	if len(mask)%2 != 0 {
+		return nil, fmt.Errorf("invalid mask: %s", mask)
+	}
+
+	keyspace := big.NewInt(1)
+	for i := 0; i < len(mask); i += 2 {
+		size, ok := charsetSizes[mask[i+1]]
+		if mask[i] != '?' || !ok {
+			return nil, fmt.Errorf("invalid mask: %s", mask)
+		}
+		keyspace.Mul(keyspace, big.NewInt(size))
+	}
+
+	return keyspace, nil
+}
+
+// NewCollector creates a collector that counts the mask of every plaintext
+// and appends them to the given masks source.
+//
+// Args:
+// path (string): The path to the masks source file
+//
+// Returns:
+// *utils.CountCollector: The collector
+func NewCollector(path string) *utils.CountCollector {
+	return utils.NewCountCollector(path, DeriveMask)
+}
+
+// TopMasks reads the first n masks of a ranked counts file that pass the
+// count and keyspace limits.
+//
+// Args:
+// countsPATH (string): The path to the ranked "count\tmask" records
+// n (int): The number of masks to return
+// minCount (int): The minimum count of a mask or 0 for any
+// maxKeyspace (*big.Int): The maximum keyspace of a mask or nil for any
+//
+// Returns:
+// []RankedMask: The masks in rank order
+// error: An error if one occurred
+func TopMasks(countsPATH string, n int, minCount int, maxKeyspace *big.Int) ([]RankedMask, error) {
+	file, err := os.Open(countsPATH)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ranked []RankedMask
+	scanner := utils.NewLineScanner(file)
+	for len(ranked) < n && scanner.Scan() {
+		countField, mask, found := strings.Cut(scanner.Text(), "\t")
+		if !found {
+			continue
+		}
+		count, err := strconv.Atoi(countField)
+		if err != nil {
+			continue
+		}
+		// Counts are in descending order so nothing later can pass
+		if count < minCount {
+			break
+		}
+
+		keyspace, err := Keyspace(mask)
+		if err != nil {
+			continue
+		}
+		if maxKeyspace != nil && keyspace.Cmp(maxKeyspace) > 0 {
+			continue
+		}
+
+		ranked = append(ranked, RankedMask{Mask: mask, Count: count, Keyspace: keyspace})
+	}
+
+	return ranked, scanner.Err()
+}
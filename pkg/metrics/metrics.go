@@ -0,0 +1,365 @@
+// Package metrics keeps the counters and gauges exposed to Prometheus and
+// writes them in the Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metric types written in the TYPE line of a family
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+)
+
+// Metric is a single counter or gauge value that is safe for concurrent use
+type Metric struct {
+	bits atomic.Uint64
+}
+
+// Add adds to the value. Counters must only be given positive values.
+//
+// Args:
+// v (float64): The amount to add
+//
+// Returns:
+// None
+func (m *Metric) Add(v float64) {
+	for {
+		old := m.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if m.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// Inc adds one to the value.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func (m *Metric) Inc() {
+	m.Add(1)
+}
+
+// Set replaces the value of a gauge.
+//
+// Args:
+// v (float64): The new value
+//
+// Returns:
+// None
+func (m *Metric) Set(v float64) {
+	m.bits.Store(math.Float64bits(v))
+}
+
+// Value returns the current value.
+//
+// Args:
+// None
+//
+// Returns:
+// float64: The value
+func (m *Metric) Value() float64 {
+	return math.Float64frombits(m.bits.Load())
+}
+
+// Vec is a family of metrics sharing a name and label names
+type Vec struct {
+	name       string
+	help       string
+	metricType string
+	labels     []string
+	mu         sync.RWMutex
+	children   map[string]*child
+}
+
+// child is the metric of one set of label values
+type child struct {
+	values []string
+	metric *Metric
+}
+
+// With returns the metric for a set of label values, creating it on first
+// use. The values are given in the order of the label names.
+//
+// Args:
+// values (...string): The label values
+//
+// Returns:
+// *Metric: The metric
+func (v *Vec) With(values ...string) *Metric {
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	existing, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return existing.metric
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if existing, ok := v.children[key]; ok {
+		return existing.metric
+	}
+	created := &child{values: append([]string(nil), values...), metric: &Metric{}}
+	v.children[key] = created
+	return created.metric
+}
+
+// GaugeFunc is a gauge family whose samples are read when the metrics are
+// written, for values such as file sizes that are cheaper to look up than to
+// track
+type GaugeFunc struct {
+	name    string
+	help    string
+	labels  []string
+	collect func(emit func(value float64, values ...string))
+}
+
+// registryMu guards the registered families
+var registryMu sync.Mutex
+
+// families holds every registered family by name
+var families = map[string]any{}
+
+// NewCounter registers a counter family.
+//
+// Args:
+// name (string): The metric name
+// help (string): The description of the metric
+// labels (...string): The label names
+//
+// Returns:
+// *Vec: The counter family
+func NewCounter(name string, help string, labels ...string) *Vec {
+	return register(name, help, typeCounter, labels)
+}
+
+// NewGauge registers a gauge family.
+//
+// Args:
+// name (string): The metric name
+// help (string): The description of the metric
+// labels (...string): The label names
+//
+// Returns:
+// *Vec: The gauge family
+func NewGauge(name string, help string, labels ...string) *Vec {
+	return register(name, help, typeGauge, labels)
+}
+
+// NewGaugeFunc registers a gauge family whose samples are collected when the
+// metrics are written. collect is called with a function that records one
+// sample per call.
+//
+// Args:
+// name (string): The metric name
+// help (string): The description of the metric
+// labels ([]string): The label names
+// collect (func(emit func(value float64, values ...string))): Records the
+// current samples
+//
+// Returns:
+// None
+func NewGaugeFunc(name string, help string, labels []string, collect func(emit func(value float64, values ...string))) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	mustBeUnregistered(name)
+	families[name] = &GaugeFunc{name: name, help: help, labels: labels, collect: collect}
+}
+
+// register adds a family to the registry.
+//
+// Args:
+// name (string): The metric name
+// help (string): The description of the metric
+// metricType (string): The metric type
+// labels ([]string): The label names
+//
+// Returns:
+// *Vec: The family
+func register(name string, help string, metricType string, labels []string) *Vec {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	mustBeUnregistered(name)
+	vec := &Vec{name: name, help: help, metricType: metricType, labels: labels, children: map[string]*child{}}
+	families[name] = vec
+	return vec
+}
+
+// mustBeUnregistered panics when a name is registered twice, which is a
+// programming error. registryMu must be held.
+//
+// Args:
+// name (string): The metric name
+//
+// Returns:
+// None
+func mustBeUnregistered(name string) {
+	if _, ok := families[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+}
+
+// Write writes every registered family in the Prometheus text exposition
+// format, sorted by name.
+//
+// Args:
+// w (io.Writer): The destination
+//
+// Returns:
+// error: An error if one occurred
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	registered := make([]any, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		registered = append(registered, families[name])
+	}
+	registryMu.Unlock()
+
+	writer := bufio.NewWriter(w)
+	for _, family := range registered {
+		switch family := family.(type) {
+		case *Vec:
+			writeFamily(writer, family)
+		case *GaugeFunc:
+			writeGaugeFunc(writer, family)
+		}
+	}
+	return writer.Flush()
+}
+
+// writeFamily writes the samples of a counter or gauge family.
+//
+// Args:
+// writer (*bufio.Writer): The destination
+// vec (*Vec): The family
+//
+// Returns:
+// None
+func writeFamily(writer *bufio.Writer, vec *Vec) {
+	vec.mu.RLock()
+	children := make([]*child, 0, len(vec.children))
+	for _, c := range vec.children {
+		children = append(children, c)
+	}
+	vec.mu.RUnlock()
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Join(children[i].values, "\xff") < strings.Join(children[j].values, "\xff")
+	})
+
+	writeHeader(writer, vec.name, vec.help, vec.metricType)
+	for _, c := range children {
+		writeSample(writer, vec.name, vec.labels, c.values, c.metric.Value())
+	}
+}
+
+// writeGaugeFunc collects and writes the samples of a gauge function.
+//
+// Args:
+// writer (*bufio.Writer): The destination
+// gauge (*GaugeFunc): The family
+//
+// Returns:
+// None
+func writeGaugeFunc(writer *bufio.Writer, gauge *GaugeFunc) {
+	writeHeader(writer, gauge.name, gauge.help, typeGauge)
+	gauge.collect(func(value float64, values ...string) {
+		writeSample(writer, gauge.name, gauge.labels, values, value)
+	})
+}
+
+// writeHeader writes the HELP and TYPE lines of a family.
+//
+// Args:
+// writer (*bufio.Writer): The destination
+// name (string): The metric name
+// help (string): The description of the metric
+// metricType (string): The metric type
+//
+// Returns:
+// None
+func writeHeader(writer *bufio.Writer, name string, help string, metricType string) {
+	writer.WriteString("# HELP " + name + " " + strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help) + "\n")
+	writer.WriteString("# TYPE " + name + " " + metricType + "\n")
+}
+
+// writeSample writes one sample line.
+//
+// Args:
+// writer (*bufio.Writer): The destination
+// name (string): The metric name
+// labels ([]string): The label names
+// values ([]string): The label values
+// value (float64): The sample value
+//
+// Returns:
+// None
+func writeSample(writer *bufio.Writer, name string, labels []string, values []string, value float64) {
+	writer.WriteString(name)
+	if len(labels) > 0 {
+		writer.WriteString("{")
+		for i, label := range labels {
+			if i > 0 {
+				writer.WriteString(",")
+			}
+			labelValue := ""
+			if i < len(values) {
+				labelValue = values[i]
+			}
+			writer.WriteString(label + `="` + escapeLabelValue(labelValue) + `"`)
+		}
+		writer.WriteString("}")
+	}
+	writer.WriteString(" " + formatValue(value) + "\n")
+}
+
+// escapeLabelValue escapes backslashes, quotes, and newlines in a label
+// value.
+//
+// Args:
+// value (string): The label value
+//
+// Returns:
+// string: The escaped value
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatValue formats a sample value, including the special values.
+//
+// Args:
+// value (float64): The value
+//
+// Returns:
+// string: The formatted value
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
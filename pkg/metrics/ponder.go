@@ -0,0 +1,53 @@
+package metrics
+
+// Results used as the "result" label of line and generation counters
+const (
+	ResultAccepted  = "accepted"
+	ResultRejected  = "rejected"
+	ResultCompleted = "completed"
+	ResultFailed    = "failed"
+	ResultCancelled = "cancelled"
+)
+
+// Stages used as the "stage" label of filter counters
+const (
+	StageIngest   = "ingest"
+	StageGenerate = "generate"
+)
+
+// UploadLines counts the lines read from uploads and imports
+var UploadLines = NewCounter("ponder_upload_lines_total", "Lines read from uploads and imports by result.", "project", "result")
+
+// UploadBytes counts the decompressed bytes read from uploads and imports
+var UploadBytes = NewCounter("ponder_upload_bytes_total", "Decompressed bytes read from uploads and imports.", "project")
+
+// UploadKnownLines counts the lines from uploads and imports whose candidates
+// had all been ingested before
+var UploadKnownLines = NewCounter("ponder_upload_lines_known_total", "Lines from uploads and imports whose candidates were already ingested.", "project")
+
+// FilterLines counts the lines checked by every quality filter during ingest
+// and generation. Its "filter" label is the name of the filter in the models
+// package, so it matches the names used in the pipeline configuration.
+var FilterLines = NewCounter("ponder_filter_lines_total", "Lines checked by each filter by stage and result.", "stage", "filter", "result")
+
+// Generations counts the finished generations
+var Generations = NewCounter("ponder_generations_total", "Finished generations by result.", "project", "result")
+
+// GenerationDuration is the duration of the last completed generation
+var GenerationDuration = NewGauge("ponder_generation_last_duration_seconds", "Duration of the last completed generation.", "project")
+
+// GenerationPhaseSeconds counts the time spent in every generation phase
+var GenerationPhaseSeconds = NewCounter("ponder_generation_phase_seconds_total", "Time spent in each generation phase.", "phase")
+
+// SortChunks counts the sorted chunks written by the external sort
+var SortChunks = NewCounter("ponder_sort_chunks_written_total", "Sorted chunks written by the external sort.").With()
+
+// SortRunFlushes counts the frequency runs flushed while counting sorted
+// chunks
+var SortRunFlushes = NewCounter("ponder_sort_run_flushes_total", "Frequency runs flushed to disk while counting sorted chunks.").With()
+
+// MergedLines counts the lines written by the final k-way merge
+var MergedLines = NewCounter("ponder_merge_lines_written_total", "Lines written by the final merge of the external sort.").With()
+
+// DownloadBytes counts the bytes served by downloads
+var DownloadBytes = NewCounter("ponder_download_bytes_total", "Bytes served by downloads.", "kind")
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"fmt"
+)
+
+// Dedup modes usable in DedupConfig.Mode
+const (
+	// DedupCount appends lines that were already ingested so every repeat
+	// adds to their frequency, and only reports how many there were
+	DedupCount = "count"
+	// DedupIgnore drops lines that were already ingested from a source with
+	// the same key, so uploading the same file twice, or again after it grew,
+	// does not change the frequencies of the lines it already had while a
+	// line shared by different sources still counts once for each of them.
+	// The key is the "source" value of the upload, or else the file name.
+	DedupIgnore = "ignore"
+)
+
+// DedupConfig controls the optional ingest stage that recognizes lines which
+// were already added to the source wordlist
+type DedupConfig struct {
+	// Enabled turns the dedup stage on
+	Enabled bool `json:"enabled"`
+	// Mode is either "count" or "ignore"
+	Mode string `json:"mode"`
+	// ExpectedLines is the number of distinct candidates the filter is sized
+	// for. The false positive rate rises once more have been added.
+	ExpectedLines int64 `json:"expected_lines"`
+	// FalsePositiveRate is the chance that a new candidate is taken for one
+	// that was already ingested
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+}
+
+// DefaultDedup returns the dedup configuration used when none is given. The
+// stage is disabled and its filter takes about 18MB once enabled.
+//
+// Args:
+// None
+//
+// Returns:
+// (DedupConfig): The default dedup configuration
+func DefaultDedup() DedupConfig {
+	return DedupConfig{
+		Mode:              DedupCount,
+		ExpectedLines:     10000000,
+		FalsePositiveRate: 0.001,
+	}
+}
+
+// Validate fills unset fields with defaults and checks that the mode and
+// filter size are usable.
+//
+// Args:
+// None
+//
+// Returns:
+// (error): The first problem found in the dedup configuration
+func (d *DedupConfig) Validate() error {
+	defaults := DefaultDedup()
+	if d.Mode == "" {
+		d.Mode = defaults.Mode
+	}
+	if d.ExpectedLines == 0 {
+		d.ExpectedLines = defaults.ExpectedLines
+	}
+	if d.FalsePositiveRate == 0 {
+		d.FalsePositiveRate = defaults.FalsePositiveRate
+	}
+
+	switch d.Mode {
+	case DedupCount, DedupIgnore:
+	default:
+		return fmt.Errorf("unknown dedup mode: %s", d.Mode)
+	}
+	if d.ExpectedLines < 1 {
+		return fmt.Errorf("invalid dedup expected lines: %d", d.ExpectedLines)
+	}
+	if d.FalsePositiveRate <= 0 || d.FalsePositiveRate >= 1 {
+		return fmt.Errorf("invalid dedup false positive rate: %g", d.FalsePositiveRate)
+	}
+
+	return nil
+}
@@ -8,7 +8,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
-	"time"
+	"unicode/utf8"
 )
 
 // Config holds the configuration for the application
@@ -16,6 +16,45 @@ type Config struct {
 	SourceDirectory string `json:"source_directory"`
 	SourceWordlist  string `json:"source_wordlist"`
 	WizardWordlist  string `json:"wizard_wordlist"`
+	WizardCounts    string `json:"wizard_counts"`
+	WizardOffset    string `json:"wizard_offset"`
+	// IncrementalGeneration only processes source data appended since the
+	// last generation when enabled
+	IncrementalGeneration bool `json:"incremental_generation"`
+	// IntervalMinutes is how often the wordlist is checked for new uploads
+	IntervalMinutes int `json:"generation_interval_minutes"`
+	// Characters controls how non-ASCII candidates are handled
+	Characters CharacterPolicy `json:"character_policy"`
+	// Pipeline declares the ingest filters and generation stages
+	Pipeline PipelineConfig `json:"pipeline"`
+	// JobWorkers is the number of uploads and imports processed at once
+	JobWorkers int `json:"job_workers"`
+	// GenerationRetention is the number of wizard wordlist generations kept
+	// for download and rollback
+	GenerationRetention int `json:"generation_retention"`
+	// UploadExpiryHours is how long a resumable upload may go without
+	// receiving data before its staged data is discarded
+	UploadExpiryHours int `json:"upload_expiry_hours"`
+	// Dedup controls the ingest stage that recognizes lines which were
+	// already added to the source wordlist
+	Dedup DedupConfig `json:"dedup"`
+}
+
+// CharacterPolicy controls how candidates containing non-ASCII characters are
+// normalized, filtered, and written out
+type CharacterPolicy struct {
+	// AllowUnicode keeps candidates containing non-ASCII letters instead of
+	// dropping them
+	AllowUnicode bool `json:"allow_unicode"`
+	// Transliterate adds an ASCII variant of every non-ASCII candidate, for
+	// example "müller" also yields "muller"
+	Transliterate bool `json:"transliterate"`
+	// Normalization is the Unicode normalization form applied to candidates,
+	// either "NFC", "NFKC", or "none"
+	Normalization string `json:"normalization"`
+	// HexEncodeOutput writes candidates that are not printable ASCII as
+	// $HEX[] in downloads
+	HexEncodeOutput bool `json:"hex_encode_output"`
 }
 
 // ConfigFilePath is the path to the configuration file
@@ -37,11 +76,21 @@ var SourceWordlist = fmt.Sprintf("%s/source-wordlist.txt", SourceDirectory)
 // Default is /data/wizard-wordlist.txt
 var WizardWordlist = fmt.Sprintf("%s/wizard-wordlist.txt", SourceDirectory)
 
-// LastUpdated is the last time the wordlist was updated
-var LastUpdated = time.Time{}
+// WizardCounts is the path to the frequency counts of the wizard wordlist
+// Default is /data/wizard-wordlist.counts
+var WizardCounts = fmt.Sprintf("%s/wizard-wordlist.counts", SourceDirectory)
+
+// WizardOffset is the path to the file recording how much of the source
+// wordlist has been processed into the wizard wordlist
+// Default is /data/wizard-wordlist.offset
+var WizardOffset = fmt.Sprintf("%s/wizard-wordlist.offset", SourceDirectory)
+
+// IncrementalGeneration controls whether generation only processes newly
+// appended source data
+var IncrementalGeneration = false
 
-// LastUploaded is the last time data was uploaded
-var LastUploaded = time.Time{}
+// CurrentConfig is the configuration loaded at startup
+var CurrentConfig = DefaultConfig()
 
 // LogFile is the path to the log file
 var LogFile = fmt.Sprintf("%s/log.txt", SourceDirectory)
@@ -51,15 +100,101 @@ type Log struct {
 	Entries []LogEntry `json:"entries"`
 }
 
+// Log levels ordered from least to most severe
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// LogFields holds structured values attached to a log entry such as the
+// project, job ID, or duration
+type LogFields map[string]any
+
 // LogEntry is used to log an event to the log
 type LogEntry struct {
-	Time    string `json:"time"`
-	Event   string `json:"event"`
-	Message string `json:"message"`
+	// ID orders the entries and is the cursor of event log streams. Entries
+	// written before the log was structured have none.
+	ID      int64     `json:"id,omitempty"`
+	Time    string    `json:"time"`
+	Level   string    `json:"level"`
+	Event   string    `json:"event"`
+	Message string    `json:"message"`
+	Fields  LogFields `json:"fields,omitempty"`
+}
+
+// DefaultConfig returns the configuration used when no configuration file is
+// present. It matches the historical ASCII only behavior.
+//
+// Args:
+// None
+//
+// Returns:
+// (*Config): The default configuration
+func DefaultConfig() *Config {
+	config := &Config{
+		SourceDirectory:     SourceDirectory,
+		SourceWordlist:      SourceWordlist,
+		WizardWordlist:      WizardWordlist,
+		WizardCounts:        WizardCounts,
+		WizardOffset:        WizardOffset,
+		IntervalMinutes:     15,
+		JobWorkers:          2,
+		GenerationRetention: 10,
+		UploadExpiryHours:   24,
+		Characters: CharacterPolicy{
+			Normalization: "NFC",
+		},
+		Pipeline: DefaultPipeline(),
+		Dedup:    DefaultDedup(),
+	}
+	// The default pipeline is always valid, this compiles the blocklist
+	config.Validate()
+	return config
+}
+
+// Validate fills unset pipeline fields with defaults and checks that the
+// character policy and pipeline are usable.
+//
+// Args:
+// None
+//
+// Returns:
+// (error): The first problem found in the configuration
+func (c *Config) Validate() error {
+	if c.IntervalMinutes < 1 {
+		return fmt.Errorf("invalid generation interval: %d minutes", c.IntervalMinutes)
+	}
+	if c.JobWorkers < 1 {
+		return fmt.Errorf("invalid number of job workers: %d", c.JobWorkers)
+	}
+	if c.GenerationRetention < 1 {
+		return fmt.Errorf("invalid generation retention: %d", c.GenerationRetention)
+	}
+	if c.UploadExpiryHours < 1 {
+		return fmt.Errorf("invalid upload expiry: %d hours", c.UploadExpiryHours)
+	}
+
+	switch c.Characters.Normalization {
+	case "NFC", "NFKC", "none":
+	default:
+		return fmt.Errorf("unknown character normalization: %s", c.Characters.Normalization)
+	}
+
+	if err := c.Pipeline.Validate(); err != nil {
+		return fmt.Errorf("invalid pipeline: %w", err)
+	}
+
+	if err := c.Dedup.Validate(); err != nil {
+		return fmt.Errorf("invalid dedup: %w", err)
+	}
+
+	return nil
 }
 
-// LoadConfig reads the configuration from a JSON file and assigns the values
-// to the global variables.
+// LoadConfig reads the configuration from a JSON file, validates it, and
+// assigns the values to the global variables.
 //
 // Args:
 // None
@@ -74,18 +209,37 @@ func LoadConfig() (*Config, error) {
 	}
 	defer file.Close()
 
-	var config Config
+	// Path defaults are derived below, only the policy defaults are preset
+	defaults := DefaultConfig()
+	config := Config{IntervalMinutes: defaults.IntervalMinutes, JobWorkers: defaults.JobWorkers, GenerationRetention: defaults.GenerationRetention, UploadExpiryHours: defaults.UploadExpiryHours, Characters: defaults.Characters}
 	decoder := json.NewDecoder(file)
 	err = decoder.Decode(&config)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	if config.WizardCounts == "" {
+		config.WizardCounts = strings.TrimSuffix(config.WizardWordlist, ".txt") + ".counts"
+	}
+	if config.WizardOffset == "" {
+		config.WizardOffset = strings.TrimSuffix(config.WizardWordlist, ".txt") + ".offset"
+	}
+
 	// Assign the values to the global variables
 	SourceDirectory = config.SourceDirectory
+	ImportDirectory = fmt.Sprintf("%s/import", SourceDirectory)
+	LogFile = fmt.Sprintf("%s/log.txt", SourceDirectory)
 	SourceWordlist = config.SourceWordlist
 	WizardWordlist = config.WizardWordlist
+	WizardCounts = config.WizardCounts
+	WizardOffset = config.WizardOffset
+	IncrementalGeneration = config.IncrementalGeneration
 
+	CurrentConfig = &config
 	return &config, nil
 }
 
@@ -143,7 +297,8 @@ func GenerateNGrams(text string, wordRangeStart int, wordRangeEnd int) []string
 }
 
 // EnforceLengthRange filters the input byte slice to only include strings
-// between minLength and maxLength characters inclusive.
+// between minLength and maxLength characters inclusive. Characters are
+// counted as runes, so multi-byte UTF-8 candidates are not cut off early.
 //
 // Args:
 // input ([]byte): The input byte slice to filter.
@@ -157,7 +312,8 @@ func EnforceLengthRange(input []byte, minLength int, maxLength int) []byte {
 	var filtered []string
 
 	for _, line := range lines {
-		if len(line) >= minLength && len(line) <= maxLength {
+		length := utf8.RuneCountInString(line)
+		if length >= minLength && length <= maxLength {
 			filtered = append(filtered, line)
 		}
 	}
@@ -209,3 +365,36 @@ func ConvertHexToPlaintext(hash string) (string, error) {
 
 	return result.String(), nil
 }
+
+// ConvertPlaintextToHex is a function that converts a "plaintext" string to a
+// "$HEX[plaintext]" string.
+//
+// Args:
+// plaintext: (string) the plaintext to convert
+//
+// Returns:
+// (string): the hex encoded plaintext
+func ConvertPlaintextToHex(plaintext string) string {
+	return fmt.Sprintf("$HEX[%s]", hex.EncodeToString([]byte(plaintext)))
+}
+
+// NeedsHexEncoding checks if a plaintext contains bytes outside of printable
+// ASCII or could be mistaken for a $HEX[] string, meaning it should be
+// written as $HEX[] for hashcat to read it reliably.
+//
+// Args:
+// plaintext: (string) the plaintext to check
+//
+// Returns:
+// (bool): True if the plaintext should be hex encoded
+func NeedsHexEncoding(plaintext string) bool {
+	if strings.Contains(plaintext, "$HEX[") {
+		return true
+	}
+	for i := 0; i < len(plaintext); i++ {
+		if plaintext[i] < 32 || plaintext[i] > 126 {
+			return true
+		}
+	}
+	return false
+}
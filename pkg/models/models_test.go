@@ -0,0 +1,16 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestEnforceLengthRangeCountsCharacters(t *testing.T) {
+	// "pässwörd" is 8 characters but 10 bytes, "日本語パス" is 5 characters
+	// but 15 bytes
+	input := []byte("abc\npässwörd\n日本語パス\nabcdefghi")
+	expected := "pässwörd\n日本語パス"
+
+	if output := string(EnforceLengthRange(input, 4, 8)); output != expected {
+		t.Errorf("got %q, want %q", output, expected)
+	}
+}
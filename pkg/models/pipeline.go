@@ -0,0 +1,230 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Ingest filter names usable in PipelineConfig.IngestFilters
+const (
+	// FilterDigitsOrSpecial rejects lines without any letters
+	FilterDigitsOrSpecial = "digits-or-special"
+	// FilterWordLikeness rejects lines that do not look like they contain words
+	FilterWordLikeness = "word-likeness"
+	// FilterBlocklist rejects lines matching any of the blocklist patterns
+	FilterBlocklist = "blocklist"
+)
+
+// FilterCharacterPolicy names the check of lines against the character
+// policy. It always runs and is not one of the configurable ingest filters,
+// but its lines are counted under this name with the others.
+const FilterCharacterPolicy = "character-policy"
+
+// Generation stage names usable in PipelineConfig.GenerationStages
+const (
+	// StageNGrams expands every line into its word n-grams
+	StageNGrams = "ngrams"
+	// StagePrepare cleans, lowercases, and title-cases multi-word lines
+	StagePrepare = "prepare"
+	// StageFilter rejects lines without letters, lines not allowed by the
+	// character policy, and lines that do not look like words
+	StageFilter = "filter"
+	// StageTrimTrailingDigits removes trailing digits
+	StageTrimTrailingDigits = "trim-trailing-digits"
+	// StageTrimLeadingDigits removes leading digits
+	StageTrimLeadingDigits = "trim-leading-digits"
+	// StageLength rejects lines outside of the length range
+	StageLength = "length"
+	// StageBlocklist rejects lines matching any of the blocklist patterns
+	StageBlocklist = "blocklist"
+)
+
+// knownIngestFilters is the set of valid ingest filter names
+var knownIngestFilters = map[string]bool{
+	FilterDigitsOrSpecial: true,
+	FilterWordLikeness:    true,
+	FilterBlocklist:       true,
+}
+
+// knownGenerationStages is the set of valid generation stage names
+var knownGenerationStages = map[string]bool{
+	StageNGrams:             true,
+	StagePrepare:            true,
+	StageFilter:             true,
+	StageTrimTrailingDigits: true,
+	StageTrimLeadingDigits:  true,
+	StageLength:             true,
+	StageBlocklist:          true,
+}
+
+// PipelineConfig declares the stages candidates pass through on ingest and
+// generation along with the parameters of each stage
+type PipelineConfig struct {
+	// IngestFilters are applied in order to every uploaded or imported line
+	IngestFilters []string `json:"ingest_filters"`
+	// GenerationStages are applied in order to every chunk of the source
+	// wordlist during generation
+	GenerationStages []string `json:"generation_stages"`
+	// NGramRange is the number of words used for n-grams
+	NGramRange Range `json:"ngram_range"`
+	// LengthRange is the inclusive length in characters of generated
+	// candidates
+	LengthRange Range `json:"length_range"`
+	// Blocklist is a list of regular expressions that reject candidates
+	Blocklist []string `json:"blocklist"`
+	// WordLikeness controls how strictly candidates must look like words
+	WordLikeness WordLikeness `json:"word_likeness"`
+
+	blocklist []*regexp.Regexp
+}
+
+// Range is an inclusive range of integers
+type Range struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// WordLikeness controls the word detection used by the word-likeness filter
+type WordLikeness struct {
+	// WindowSize is the number of consecutive characters that are inspected
+	// together
+	WindowSize int `json:"window_size"`
+	// MaxNonLetters is the number of digits or special characters a window
+	// may contain and still be word-like
+	MaxNonLetters int `json:"max_non_letters"`
+	// MinWindows is the number of word-like windows a candidate needs
+	MinWindows int `json:"min_windows"`
+}
+
+// DefaultPipeline returns the pipeline that replaces the historical hard-coded
+// behavior. The stages and ranges are unchanged, but the blocklist differs:
+// the hard-coded pattern escaped its slashes, dots, and at signs twice inside
+// a raw string, so the URL, ".net", and email address alternatives only
+// matched text containing literal backslashes and never rejected anything.
+// The patterns below match URLs and email addresses as intended, so fewer
+// candidates are kept than before.
+//
+// Args:
+// None
+//
+// Returns:
+// (PipelineConfig): The default pipeline
+func DefaultPipeline() PipelineConfig {
+	return PipelineConfig{
+		IngestFilters: []string{FilterDigitsOrSpecial, FilterWordLikeness, FilterBlocklist},
+		GenerationStages: []string{
+			StageNGrams,
+			StagePrepare,
+			StageFilter,
+			StageTrimTrailingDigits,
+			StageTrimLeadingDigits,
+			StageLength,
+			StageFilter,
+		},
+		NGramRange:  Range{Min: 1, Max: 5},
+		LengthRange: Range{Min: 4, Max: 32},
+		// These patterns match common strings that are likely not quality
+		// candidates.
+		Blocklist: []string{
+			`(xiaonei|zomato|fbobh|fccdbbcdaa|yahoo|linkedin|gmail|yandex|hotmail)`,
+			`https?://`,
+			`@.*\.net`,
+			`<tr>|<div>|<a href|<p>|<img src`,
+			`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,6}`,
+			`^[0-9]+$`,
+			`^.{0,5}$`,
+		},
+		WordLikeness: WordLikeness{
+			WindowSize:    5,
+			MaxNonLetters: 1,
+			MinWindows:    1,
+		},
+	}
+}
+
+// BlocklistRegexps returns the compiled blocklist patterns. Validate must have
+// been called first.
+//
+// Args:
+// None
+//
+// Returns:
+// ([]*regexp.Regexp): The compiled patterns
+func (p *PipelineConfig) BlocklistRegexps() []*regexp.Regexp {
+	return p.blocklist
+}
+
+// applyDefaults fills every unset field with its default value.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func (p *PipelineConfig) applyDefaults() {
+	defaults := DefaultPipeline()
+	if p.IngestFilters == nil {
+		p.IngestFilters = defaults.IngestFilters
+	}
+	if p.GenerationStages == nil {
+		p.GenerationStages = defaults.GenerationStages
+	}
+	if p.NGramRange == (Range{}) {
+		p.NGramRange = defaults.NGramRange
+	}
+	if p.LengthRange == (Range{}) {
+		p.LengthRange = defaults.LengthRange
+	}
+	if p.Blocklist == nil {
+		p.Blocklist = defaults.Blocklist
+	}
+	if p.WordLikeness == (WordLikeness{}) {
+		p.WordLikeness = defaults.WordLikeness
+	}
+}
+
+// Validate fills unset fields with defaults, checks that every stage is
+// known and every range is sensible, and compiles the blocklist patterns.
+//
+// Args:
+// None
+//
+// Returns:
+// (error): The first problem found in the pipeline
+func (p *PipelineConfig) Validate() error {
+	p.applyDefaults()
+
+	for _, name := range p.IngestFilters {
+		if !knownIngestFilters[name] {
+			return fmt.Errorf("unknown ingest filter: %s", name)
+		}
+	}
+	for _, name := range p.GenerationStages {
+		if !knownGenerationStages[name] {
+			return fmt.Errorf("unknown generation stage: %s", name)
+		}
+	}
+
+	if p.NGramRange.Min < 1 || p.NGramRange.Max < p.NGramRange.Min {
+		return fmt.Errorf("invalid ngram range: %d-%d", p.NGramRange.Min, p.NGramRange.Max)
+	}
+	if p.LengthRange.Min < 0 || p.LengthRange.Max < p.LengthRange.Min {
+		return fmt.Errorf("invalid length range: %d-%d", p.LengthRange.Min, p.LengthRange.Max)
+	}
+
+	likeness := p.WordLikeness
+	if likeness.WindowSize < 1 || likeness.MaxNonLetters < 0 || likeness.MaxNonLetters > likeness.WindowSize || likeness.MinWindows < 1 {
+		return fmt.Errorf("invalid word likeness: window %d, max non-letters %d, min windows %d", likeness.WindowSize, likeness.MaxNonLetters, likeness.MinWindows)
+	}
+
+	p.blocklist = make([]*regexp.Regexp, 0, len(p.Blocklist))
+	for _, pattern := range p.Blocklist {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid blocklist pattern %q: %w", pattern, err)
+		}
+		p.blocklist = append(p.blocklist, re)
+	}
+
+	return nil
+}
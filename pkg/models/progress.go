@@ -0,0 +1,292 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"ponder/pkg/metrics"
+	"sync"
+	"time"
+)
+
+// Generation phases reported by GenerationProgress
+const (
+	// PhaseIdle means no generation is running
+	PhaseIdle = "idle"
+	// PhaseProcessing runs the source wordlist through the pipeline
+	PhaseProcessing = "processing"
+	// PhaseSorting splits the candidates into sorted chunks
+	PhaseSorting = "sorting"
+	// PhaseCounting counts the candidates across every chunk
+	PhaseCounting = "counting"
+	// PhaseMerging writes the candidates in frequency order
+	PhaseMerging = "merging"
+	// PhaseRanking ranks the collected rules and masks
+	PhaseRanking = "ranking"
+)
+
+// GenerationProgress tracks a running generation so it can be reported while
+// it runs. A nil *GenerationProgress ignores every update.
+type GenerationProgress struct {
+	mu             sync.Mutex
+	running        bool
+	phase          string
+	phaseStarted   time.Time
+	started        time.Time
+	sourceBytes    int64
+	sourceConsumed int64
+	chunksWritten  int
+	runsWritten    int
+	linesWritten   int64
+}
+
+// ProgressSnapshot is a copy of a GenerationProgress for API responses
+type ProgressSnapshot struct {
+	Running        bool       `json:"running"`
+	Phase          string     `json:"phase"`
+	Started        *time.Time `json:"started,omitempty"`
+	SourceBytes    int64      `json:"source_bytes"`
+	SourceConsumed int64      `json:"source_consumed"`
+	Percent        float64    `json:"percent"`
+	ChunksWritten  int        `json:"chunks_written"`
+	RunsWritten    int        `json:"runs_written"`
+	LinesWritten   int64      `json:"lines_written"`
+}
+
+// progressKey is the context key holding the GenerationProgress
+type progressKey struct{}
+
+// WithProgress returns a context that carries the progress of a generation
+// into the functions it calls.
+//
+// Args:
+// ctx (context.Context): The parent context
+// progress (*GenerationProgress): The progress to report to
+//
+// Returns:
+// (context.Context): The context carrying the progress
+func WithProgress(ctx context.Context, progress *GenerationProgress) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+// ProgressFromContext returns the progress carried by the context.
+//
+// Args:
+// ctx (context.Context): The context
+//
+// Returns:
+// (*GenerationProgress): The progress or nil if the context carries none
+func ProgressFromContext(ctx context.Context) *GenerationProgress {
+	progress, _ := ctx.Value(progressKey{}).(*GenerationProgress)
+	return progress
+}
+
+// Start resets the progress for a new generation.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func (p *GenerationProgress) Start() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.running = true
+	p.phase = PhaseProcessing
+	p.started = time.Now()
+	p.phaseStarted = p.started
+	p.sourceBytes = 0
+	p.sourceConsumed = 0
+	p.chunksWritten = 0
+	p.runsWritten = 0
+	p.linesWritten = 0
+}
+
+// Finish marks the generation as no longer running.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func (p *GenerationProgress) Finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.observePhase()
+	p.running = false
+	p.phase = PhaseIdle
+}
+
+// SetPhase records the phase the generation has entered.
+//
+// Args:
+// phase (string): The phase
+//
+// Returns:
+// None
+func (p *GenerationProgress) SetPhase(phase string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.observePhase()
+	p.phase = phase
+	p.phaseStarted = time.Now()
+}
+
+// observePhase adds the time spent in the current phase to the phase metrics.
+// p.mu must be held.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func (p *GenerationProgress) observePhase() {
+	if !p.running || p.phase == "" || p.phase == PhaseIdle {
+		return
+	}
+	metrics.GenerationPhaseSeconds.With(p.phase).Add(time.Since(p.phaseStarted).Seconds())
+}
+
+// SetSourceBytes records the amount of source data the generation processes.
+//
+// Args:
+// size (int64): The number of source bytes
+//
+// Returns:
+// None
+func (p *GenerationProgress) SetSourceBytes(size int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sourceBytes = size
+}
+
+// AddSourceConsumed records source data that has been processed.
+//
+// Args:
+// n (int64): The number of bytes processed
+//
+// Returns:
+// None
+func (p *GenerationProgress) AddSourceConsumed(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sourceConsumed += n
+}
+
+// AddChunk records a sorted chunk written to disk.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func (p *GenerationProgress) AddChunk() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.chunksWritten++
+}
+
+// AddRun records a frequency run written to disk.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func (p *GenerationProgress) AddRun() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.runsWritten++
+}
+
+// AddLines records lines written to the output.
+//
+// Args:
+// n (int64): The number of lines written
+//
+// Returns:
+// None
+func (p *GenerationProgress) AddLines(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.linesWritten += n
+}
+
+// Snapshot returns a copy of the progress.
+//
+// Args:
+// None
+//
+// Returns:
+// (ProgressSnapshot): The progress
+func (p *GenerationProgress) Snapshot() ProgressSnapshot {
+	if p == nil {
+		return ProgressSnapshot{Phase: PhaseIdle}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snapshot := ProgressSnapshot{
+		Running:        p.running,
+		Phase:          p.phase,
+		SourceBytes:    p.sourceBytes,
+		SourceConsumed: p.sourceConsumed,
+		ChunksWritten:  p.chunksWritten,
+		RunsWritten:    p.runsWritten,
+		LinesWritten:   p.linesWritten,
+	}
+	if snapshot.Phase == "" {
+		snapshot.Phase = PhaseIdle
+	}
+	if !p.started.IsZero() {
+		started := p.started
+		snapshot.Started = &started
+	}
+	if p.sourceBytes > 0 {
+		snapshot.Percent = float64(p.sourceConsumed) * 100 / float64(p.sourceBytes)
+	}
+	return snapshot
+}
+
+// MarshalJSON encodes a snapshot of the progress.
+//
+// Args:
+// None
+//
+// Returns:
+// ([]byte): The JSON encoding
+// (error): Any error that occurred
+func (p *GenerationProgress) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Snapshot())
+}
@@ -0,0 +1,451 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultProjectName is the name of the project served by the top level API
+// routes and backed by the configured source and wizard wordlists
+const DefaultProjectName = "default"
+
+// ProjectConfigFile is the name of the file holding the configuration
+// overrides of a named project
+const ProjectConfigFile = "project.json"
+
+// ErrProjectNotFound is returned when a named project does not exist
+var ErrProjectNotFound = errors.New("project not found")
+
+// ErrProjectExists is returned when creating a project that already exists
+var ErrProjectExists = errors.New("project already exists")
+
+// projectNamePattern restricts project names to values that are safe to use
+// as directory names
+var projectNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Project is a named wordlist with its own source, wizard output, rules,
+// masks, schedule, and configuration
+type Project struct {
+	Name                 string `json:"name"`
+	Directory            string `json:"directory"`
+	ImportDirectory      string `json:"import_directory"`
+	StagingDirectory     string `json:"staging_directory"`
+	GenerationsDirectory string `json:"generations_directory"`
+	SourceWordlist       string `json:"source_wordlist"`
+	WizardWordlist       string `json:"wizard_wordlist"`
+	WizardCounts         string `json:"wizard_counts"`
+	WizardOffset         string `json:"wizard_offset"`
+	RulesSource          string `json:"rules_source"`
+	RulesList            string `json:"rules_list"`
+	RulesCounts          string `json:"rules_counts"`
+	MasksSource          string `json:"masks_source"`
+	MasksList            string `json:"masks_list"`
+	MasksCounts          string `json:"masks_counts"`
+	DedupFilter          string `json:"dedup_filter"`
+	// Progress reports the running generation of the project
+	Progress *GenerationProgress `json:"progress"`
+	// Config is the global configuration with the project overrides applied
+	Config *Config `json:"config"`
+	// Mu synchronizes generation of the wizard wordlist, rules, and masks
+	Mu sync.Mutex `json:"-"`
+	// SourceMu synchronizes appends to the source wordlist and the collected
+	// rules and masks
+	SourceMu sync.Mutex `json:"-"`
+
+	// timesMu guards the upload, update, and check times, which are read by
+	// the updater while jobs and API handlers set them
+	timesMu      sync.Mutex
+	lastUploaded time.Time
+	lastUpdated  time.Time
+	lastChecked  time.Time
+}
+
+// projectFields has the fields of a Project without its methods, so
+// MarshalJSON can encode them without calling itself
+type projectFields Project
+
+// MarshalJSON encodes the project with its upload and update times.
+//
+// Args:
+// None
+//
+// Returns:
+// ([]byte): The JSON encoding of the project
+// (error): Any error that occurred
+func (p *Project) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		*projectFields
+		LastUploaded time.Time `json:"last_uploaded"`
+		LastUpdated  time.Time `json:"last_updated"`
+	}{(*projectFields)(p), p.LastUploaded(), p.LastUpdated()})
+}
+
+// LastUploaded returns when data was last added to the source wordlist.
+//
+// Args:
+// None
+//
+// Returns:
+// (time.Time): The time of the last upload or import
+func (p *Project) LastUploaded() time.Time {
+	p.timesMu.Lock()
+	defer p.timesMu.Unlock()
+	return p.lastUploaded
+}
+
+// SetLastUploaded records when data was last added to the source wordlist.
+//
+// Args:
+// t (time.Time): The time of the upload or import
+//
+// Returns:
+// None
+func (p *Project) SetLastUploaded(t time.Time) {
+	p.timesMu.Lock()
+	defer p.timesMu.Unlock()
+	p.lastUploaded = t
+}
+
+// LastUpdated returns when the last successful generation started.
+//
+// Args:
+// None
+//
+// Returns:
+// (time.Time): The start time of the last generation
+func (p *Project) LastUpdated() time.Time {
+	p.timesMu.Lock()
+	defer p.timesMu.Unlock()
+	return p.lastUpdated
+}
+
+// SetLastUpdated records when the last successful generation started.
+//
+// Args:
+// t (time.Time): The start time of the generation
+//
+// Returns:
+// None
+func (p *Project) SetLastUpdated(t time.Time) {
+	p.timesMu.Lock()
+	defer p.timesMu.Unlock()
+	p.lastUpdated = t
+}
+
+// LastChecked returns when the updater last checked the project for new
+// uploads.
+//
+// Args:
+// None
+//
+// Returns:
+// (time.Time): The time of the last check
+func (p *Project) LastChecked() time.Time {
+	p.timesMu.Lock()
+	defer p.timesMu.Unlock()
+	return p.lastChecked
+}
+
+// SetLastChecked records when the updater last checked the project for new
+// uploads. The zero time has the project checked again on the next cycle.
+//
+// Args:
+// t (time.Time): The time of the check
+//
+// Returns:
+// None
+func (p *Project) SetLastChecked(t time.Time) {
+	p.timesMu.Lock()
+	defer p.timesMu.Unlock()
+	p.lastChecked = t
+}
+
+// Interval returns how often the project is checked for new uploads.
+//
+// Args:
+// None
+//
+// Returns:
+// (time.Duration): The generation interval
+func (p *Project) Interval() time.Duration {
+	return time.Duration(p.Config.IntervalMinutes) * time.Minute
+}
+
+// projectsMu guards the projects registry
+var projectsMu sync.RWMutex
+
+// projects holds every loaded project by name
+var projects = map[string]*Project{}
+
+// ProjectsDirectory returns the directory holding the named projects.
+//
+// Args:
+// None
+//
+// Returns:
+// (string): The projects directory
+func ProjectsDirectory() string {
+	return fmt.Sprintf("%s/projects", SourceDirectory)
+}
+
+// LoadProjects registers the default project from the global configuration
+// and every named project found under the projects directory.
+//
+// Args:
+// None
+//
+// Returns:
+// (error): Any error that occurred
+func LoadProjects() error {
+	projectsMu.Lock()
+	defer projectsMu.Unlock()
+
+	defaultProject := &Project{
+		Name:                 DefaultProjectName,
+		Directory:            SourceDirectory,
+		ImportDirectory:      ImportDirectory,
+		StagingDirectory:     fmt.Sprintf("%s/staging", SourceDirectory),
+		GenerationsDirectory: fmt.Sprintf("%s/generations", SourceDirectory),
+		SourceWordlist:       SourceWordlist,
+		WizardWordlist:       WizardWordlist,
+		WizardCounts:         WizardCounts,
+		WizardOffset:         WizardOffset,
+		RulesSource:          fmt.Sprintf("%s/rules-source.counts", SourceDirectory),
+		RulesList:            fmt.Sprintf("%s/wizard.rule", SourceDirectory),
+		RulesCounts:          fmt.Sprintf("%s/wizard-rule.counts", SourceDirectory),
+		MasksSource:          fmt.Sprintf("%s/masks-source.counts", SourceDirectory),
+		MasksList:            fmt.Sprintf("%s/wizard.hcmask", SourceDirectory),
+		MasksCounts:          fmt.Sprintf("%s/wizard-mask.counts", SourceDirectory),
+		DedupFilter:          fmt.Sprintf("%s/dedup.bloom", SourceDirectory),
+		Config:               CurrentConfig,
+		Progress:             &GenerationProgress{},
+	}
+	if err := createMissingFiles(defaultProject.RulesList, defaultProject.RulesCounts, defaultProject.MasksList, defaultProject.MasksCounts); err != nil {
+		return err
+	}
+	projects = map[string]*Project{
+		DefaultProjectName: defaultProject,
+	}
+
+	entries, err := os.ReadDir(ProjectsDirectory())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !projectNamePattern.MatchString(entry.Name()) || entry.Name() == DefaultProjectName {
+			continue
+		}
+		project, err := loadNamedProject(entry.Name())
+		if err != nil {
+			return fmt.Errorf("error loading project %s: %w", entry.Name(), err)
+		}
+		projects[project.Name] = project
+	}
+
+	return nil
+}
+
+// GetProject returns a loaded project by name.
+//
+// Args:
+// name (string): The name of the project
+//
+// Returns:
+// (*Project): The project
+// (error): ErrProjectNotFound if there is no such project
+func GetProject(name string) (*Project, error) {
+	projectsMu.RLock()
+	defer projectsMu.RUnlock()
+
+	project, ok := projects[name]
+	if !ok {
+		return nil, ErrProjectNotFound
+	}
+	return project, nil
+}
+
+// ListProjects returns every loaded project sorted by name.
+//
+// Args:
+// None
+//
+// Returns:
+// ([]*Project): The projects
+func ListProjects() []*Project {
+	projectsMu.RLock()
+	defer projectsMu.RUnlock()
+
+	list := make([]*Project, 0, len(projects))
+	for _, project := range projects {
+		list = append(list, project)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// CreateProject creates the directory of a new named project, writes its
+// configuration overrides, and registers it.
+//
+// Args:
+// name (string): The name of the project
+// overrides ([]byte): JSON configuration overrides or nil for none
+//
+// Returns:
+// (*Project): The new project
+// (error): Any error that occurred
+func CreateProject(name string, overrides []byte) (*Project, error) {
+	if !projectNamePattern.MatchString(name) || name == DefaultProjectName {
+		return nil, fmt.Errorf("invalid project name: %s", name)
+	}
+	if len(overrides) == 0 {
+		overrides = []byte("{}")
+	}
+
+	projectsMu.Lock()
+	defer projectsMu.Unlock()
+
+	if _, ok := projects[name]; ok {
+		return nil, ErrProjectExists
+	}
+
+	directory := fmt.Sprintf("%s/%s", ProjectsDirectory(), name)
+	// Validate the overrides before anything is written to disk
+	if _, err := projectConfig(directory, overrides); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(fmt.Sprintf("%s/%s", directory, ProjectConfigFile), overrides, 0644); err != nil {
+		return nil, err
+	}
+
+	project, err := loadNamedProject(name)
+	if err != nil {
+		return nil, err
+	}
+	projects[name] = project
+	return project, nil
+}
+
+// loadNamedProject builds a named project from its directory and creates any
+// missing wordlist files.
+//
+// Args:
+// name (string): The name of the project
+//
+// Returns:
+// (*Project): The project
+// (error): Any error that occurred
+func loadNamedProject(name string) (*Project, error) {
+	directory := fmt.Sprintf("%s/%s", ProjectsDirectory(), name)
+
+	overrides, err := os.ReadFile(fmt.Sprintf("%s/%s", directory, ProjectConfigFile))
+	if os.IsNotExist(err) {
+		overrides = []byte("{}")
+	} else if err != nil {
+		return nil, err
+	}
+
+	config, err := projectConfig(directory, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	project := &Project{
+		Name:                 name,
+		Directory:            directory,
+		ImportDirectory:      fmt.Sprintf("%s/import", directory),
+		StagingDirectory:     fmt.Sprintf("%s/staging", directory),
+		GenerationsDirectory: fmt.Sprintf("%s/generations", directory),
+		SourceWordlist:       config.SourceWordlist,
+		WizardWordlist:       config.WizardWordlist,
+		WizardCounts:         config.WizardCounts,
+		WizardOffset:         config.WizardOffset,
+		RulesSource:          fmt.Sprintf("%s/rules-source.counts", directory),
+		RulesList:            fmt.Sprintf("%s/wizard.rule", directory),
+		RulesCounts:          fmt.Sprintf("%s/wizard-rule.counts", directory),
+		MasksSource:          fmt.Sprintf("%s/masks-source.counts", directory),
+		MasksList:            fmt.Sprintf("%s/wizard.hcmask", directory),
+		MasksCounts:          fmt.Sprintf("%s/wizard-mask.counts", directory),
+		DedupFilter:          fmt.Sprintf("%s/dedup.bloom", directory),
+		Config:               config,
+		Progress:             &GenerationProgress{},
+	}
+
+	err = createMissingFiles(project.SourceWordlist, project.WizardWordlist, project.WizardCounts, project.RulesList, project.RulesCounts, project.MasksList, project.MasksCounts)
+	if err != nil {
+		return nil, err
+	}
+
+	return project, nil
+}
+
+// createMissingFiles creates every file that does not exist yet so downloads
+// work before the first generation.
+//
+// Args:
+// paths (...string): The files to create
+//
+// Returns:
+// (error): Any error that occurred
+func createMissingFiles(paths ...string) error {
+	for _, path := range paths {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		file.Close()
+	}
+	return nil
+}
+
+// projectConfig applies JSON overrides to a copy of the global configuration
+// and points its wordlist paths into the project directory.
+//
+// Args:
+// directory (string): The project directory
+// overrides ([]byte): JSON configuration overrides
+//
+// Returns:
+// (*Config): The validated project configuration
+// (error): Any error that occurred
+func projectConfig(directory string, overrides []byte) (*Config, error) {
+	// A JSON round trip deep copies the global configuration so the
+	// overrides cannot modify slices shared with it
+	base, err := json.Marshal(CurrentConfig)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(base, &config); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(overrides, &config); err != nil {
+		return nil, fmt.Errorf("invalid project configuration: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	config.SourceDirectory = directory
+	config.SourceWordlist = fmt.Sprintf("%s/source-wordlist.txt", directory)
+	config.WizardWordlist = fmt.Sprintf("%s/wizard-wordlist.txt", directory)
+	config.WizardCounts = fmt.Sprintf("%s/wizard-wordlist.counts", directory)
+	config.WizardOffset = fmt.Sprintf("%s/wizard-wordlist.offset", directory)
+
+	return &config, nil
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProjectTimesAreSafeForConcurrentUse(t *testing.T) {
+	project := &Project{Name: "test"}
+	uploaded := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				project.SetLastUploaded(uploaded)
+				project.SetLastUpdated(uploaded.Add(-time.Hour))
+				project.SetLastChecked(time.Now())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				_ = project.LastUploaded().After(project.LastUpdated())
+				_ = project.LastChecked()
+				if _, err := json.Marshal(project); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := json.Marshal(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Name         string    `json:"name"`
+		LastUploaded time.Time `json:"last_uploaded"`
+		LastUpdated  time.Time `json:"last_updated"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "test" || !decoded.LastUploaded.Equal(uploaded) || !decoded.LastUpdated.Equal(uploaded.Add(-time.Hour)) {
+		t.Errorf("unexpected JSON encoding: %s", data)
+	}
+}
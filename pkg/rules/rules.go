@@ -0,0 +1,228 @@
+// Package rules derives hashcat rules from uploaded plaintexts by recording
+// the transformations between each plaintext and its base word
+package rules
+
+import (
+	"fmt"
+	"ponder/pkg/utils"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxToggles is the number of toggled positions a mixed case plaintext may
+// have before it is considered noise rather than a pattern
+const maxToggles = 3
+
+// minBaseLength is the shortest base word a rule is derived from
+const minBaseLength = 3
+
+// positionCharacters are the hashcat encodings of character positions
+const positionCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// leetLetters maps the common leet substitutions back to the letter they
+// replace
+var leetLetters = map[byte]byte{
+	'@': 'a',
+	'4': 'a',
+	'3': 'e',
+	'1': 'i',
+	'!': 'i',
+	'0': 'o',
+	'$': 's',
+	'5': 's',
+	'7': 't',
+	'+': 't',
+}
+
+// transformation is the sequence of operations that turns a base word into
+// an observed plaintext
+type transformation struct {
+	// caseRule is "", "c", "u", or a list of toggles
+	caseRule []string
+	// substitutions maps a letter to the character that replaced it
+	substitutions map[byte]byte
+	prefix        string
+	suffix        string
+}
+
+// DeriveRule finds the base word of a plaintext by removing leading digits,
+// trailing digits and symbols, leet substitutions, and capitalization, and
+// returns the hashcat rule that turns the base word back into the plaintext.
+// A plaintext without any transformation yields the no-op rule ":".
+//
+// Args:
+// plaintext (string): The decoded plaintext
+//
+// Returns:
+// string: The hashcat rule
+// bool: False if no rule could be derived from the plaintext
+func DeriveRule(plaintext string) (string, bool) {
+	for i := 0; i < len(plaintext); i++ {
+		if plaintext[i] <= ' ' || plaintext[i] > '~' {
+			return "", false
+		}
+	}
+
+	core := strings.TrimLeftFunc(plaintext, unicode.IsDigit)
+	prefix := plaintext[:len(plaintext)-len(core)]
+	trimmed := strings.TrimRightFunc(core, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	suffix := core[len(trimmed):]
+	core = trimmed
+	if len(core) < minBaseLength {
+		return "", false
+	}
+
+	t := transformation{
+		substitutions: map[byte]byte{},
+		prefix:        prefix,
+		suffix:        suffix,
+	}
+
+	decoded := []byte(core)
+	for i := 0; i < len(decoded); i++ {
+		letter, ok := leetLetters[decoded[i]]
+		if !ok {
+			continue
+		}
+		if previous, ok := t.substitutions[letter]; ok && previous != decoded[i] {
+			return "", false
+		}
+		t.substitutions[letter] = decoded[i]
+		decoded[i] = letter
+	}
+	for _, c := range decoded {
+		if !unicode.IsLetter(rune(c)) {
+			return "", false
+		}
+	}
+
+	base := strings.ToLower(string(decoded))
+	caseRule, ok := deriveCaseRule(string(decoded))
+	if !ok {
+		return "", false
+	}
+	t.caseRule = caseRule
+
+	// A substitution also replaces letters that were left alone, so only
+	// keep rules that reproduce the plaintext exactly
+	if t.apply(base) != plaintext {
+		return "", false
+	}
+
+	return t.String(), true
+}
+
+// deriveCaseRule finds the case functions that turn a lowercase word into
+// the given word.
+//
+// Args:
+// word (string): The word with its observed capitalization
+//
+// Returns:
+// []string: The case functions
+// bool: False if the capitalization has too many toggles to be a pattern
+func deriveCaseRule(word string) ([]string, bool) {
+	lower := strings.ToLower(word)
+	switch {
+	case word == lower:
+		return nil, true
+	case word == strings.ToUpper(word):
+		return []string{"u"}, true
+	case word == strings.ToUpper(lower[:1])+lower[1:]:
+		return []string{"c"}, true
+	}
+
+	var toggles []string
+	for i := 0; i < len(word); i++ {
+		if word[i] == lower[i] {
+			continue
+		}
+		if i >= len(positionCharacters) || len(toggles) == maxToggles {
+			return nil, false
+		}
+		toggles = append(toggles, "T"+string(positionCharacters[i]))
+	}
+	return toggles, true
+}
+
+// apply runs the transformation over a base word the same way hashcat would.
+//
+// Args:
+// base (string): The lowercase base word
+//
+// Returns:
+// string: The transformed word
+func (t transformation) apply(base string) string {
+	word := []byte(base)
+	for _, function := range t.caseRule {
+		switch {
+		case function == "u":
+			word = []byte(strings.ToUpper(string(word)))
+		case function == "c":
+			word = []byte(strings.ToLower(string(word)))
+			word[0] = byte(unicode.ToUpper(rune(word[0])))
+		default:
+			position := strings.IndexByte(positionCharacters, function[1])
+			if position < len(word) {
+				word[position] = byte(unicode.ToUpper(rune(word[position])))
+			}
+		}
+	}
+	for letter, replacement := range t.substitutions {
+		for i := range word {
+			if word[i] == letter {
+				word[i] = replacement
+			}
+		}
+	}
+	return t.prefix + string(word) + t.suffix
+}
+
+// String formats the transformation as a hashcat rule with the functions
+// separated by spaces.
+//
+// Args:
+// None
+//
+// Returns:
+// string: The hashcat rule
+func (t transformation) String() string {
+	functions := append([]string{}, t.caseRule...)
+
+	letters := make([]byte, 0, len(t.substitutions))
+	for letter := range t.substitutions {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i] < letters[j] })
+	for _, letter := range letters {
+		functions = append(functions, fmt.Sprintf("s%c%c", letter, t.substitutions[letter]))
+	}
+
+	// Prepending builds the prefix from its last character to its first
+	for i := len(t.prefix) - 1; i >= 0; i-- {
+		functions = append(functions, "^"+string(t.prefix[i]))
+	}
+	for i := 0; i < len(t.suffix); i++ {
+		functions = append(functions, "$"+string(t.suffix[i]))
+	}
+
+	if len(functions) == 0 {
+		return ":"
+	}
+	return strings.Join(functions, " ")
+}
+
+// NewCollector creates a collector that counts the rule of every plaintext
+// and appends them to the given rules source.
+//
+// Args:
+// path (string): The path to the rules source file
+//
+// Returns:
+// *utils.CountCollector: The collector
+func NewCollector(path string) *utils.CountCollector {
+	return utils.NewCountCollector(path, DeriveRule)
+}
@@ -0,0 +1,577 @@
+// Package sources records where the data in the source wordlist of a project
+// came from. Every uploaded or imported file is a source whose candidates are
+// appended as one contiguous segment of the source wordlist, and whose rules
+// and masks are appended as one segment of the rules and masks sources, so a
+// bad source can be removed again without touching the data of the others.
+package sources
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"ponder/pkg/models"
+	"ponder/pkg/utils"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestFile is the name of the file listing the sources of a project
+const manifestFile = "sources.json"
+
+// removalFile is the name of the file recording a removal in progress
+const removalFile = "sources.removal.json"
+
+// ErrSourceNotFound is returned when a source does not exist
+var ErrSourceNotFound = errors.New("source not found")
+
+// idPattern matches source IDs
+var idPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// Source describes one uploaded or imported file
+type Source struct {
+	ID string `json:"id"`
+	// Name is the name of the uploaded or imported file
+	Name string `json:"name"`
+	// Uploader is the name of the API token that queued the file
+	Uploader string `json:"uploader,omitempty"`
+	// Key identifies the file across uploads for the ignore dedup mode. It
+	// is the "source" value of the upload or else the file name, so a file
+	// that grew between uploads keeps its key while its checksum changes.
+	Key    string    `json:"key,omitempty"`
+	SHA256 string    `json:"sha256"`
+	Tags   []string  `json:"tags,omitempty"`
+	Added  time.Time `json:"added"`
+	// Job is the ID of the job that ingested the file
+	Job string `json:"job"`
+	// LinesAccepted, LinesRejected, and LinesKnown are the line counts of
+	// the ingest
+	LinesAccepted int64 `json:"lines_accepted"`
+	LinesRejected int64 `json:"lines_rejected"`
+	LinesKnown    int64 `json:"lines_known"`
+	// Candidates is the number of lines in the segment
+	Candidates int64 `json:"candidates"`
+	// Offset and Length locate the segment in the source wordlist
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+	// RulesOffset and RulesLength locate the rules collected from the file
+	// in the rules source, MasksOffset and MasksLength its masks in the
+	// masks source. Both are empty for sources recorded before they were
+	// tracked.
+	RulesOffset int64 `json:"rules_offset"`
+	RulesLength int64 `json:"rules_length"`
+	MasksOffset int64 `json:"masks_offset"`
+	MasksLength int64 `json:"masks_length"`
+}
+
+// manifest is the layout of the manifest file on disk
+type manifest struct {
+	Sources []Source `json:"sources"`
+}
+
+// removal is the layout of the removal file. It is written before the
+// segments of a source are cut out of any file, so a removal that is
+// interrupted by a crash or fails part way can be completed.
+type removal struct {
+	Source Source `json:"source"`
+	// SourceSize, RulesSize, and MasksSize are the sizes of the files before
+	// the segments were cut out
+	SourceSize int64 `json:"source_size"`
+	RulesSize  int64 `json:"rules_size"`
+	MasksSize  int64 `json:"masks_size"`
+	// Manifest is the manifest without the source
+	Manifest manifest `json:"manifest"`
+}
+
+// segment is the part of a file that holds the data of a source
+type segment struct {
+	path   string
+	offset int64
+	length int64
+	// size is the size of the file before the segment was cut out
+	size int64
+}
+
+// segments returns the parts of the source wordlist and the rules and masks
+// sources that a removal cuts out.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// []segment: The segments
+func (r removal) segments(project *models.Project) []segment {
+	return []segment{
+		{project.SourceWordlist, r.Source.Offset, r.Source.Length, r.SourceSize},
+		{project.RulesSource, r.Source.RulesOffset, r.Source.RulesLength, r.RulesSize},
+		{project.MasksSource, r.Source.MasksOffset, r.Source.MasksLength, r.MasksSize},
+	}
+}
+
+// manifestMu guards the manifest files of every project
+var manifestMu sync.Mutex
+
+// DedupKey returns the key that scopes the candidates of the source in the
+// ignore dedup mode. Sources recorded before keys were recorded use their
+// file name.
+//
+// Args:
+// None
+//
+// Returns:
+// string: The key of the source
+func (s Source) DedupKey() string {
+	if s.Key != "" {
+		return s.Key
+	}
+	return s.Name
+}
+
+// Add records a source once its segment has been appended to the source
+// wordlist. The SourceMu of the project must be held.
+//
+// Args:
+// project (*models.Project): The project
+// source (Source): The source without an ID
+//
+// Returns:
+// Source: The recorded source
+// error: An error if one occurred
+func Add(project *models.Project, source Source) (Source, error) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	current, err := readManifest(project)
+	if err != nil {
+		return Source{}, err
+	}
+
+	id := make([]byte, 8)
+	// crypto/rand only fails if the system has no entropy source at all
+	rand.Read(id)
+	source.ID = hex.EncodeToString(id)
+	if source.Added.IsZero() {
+		source.Added = time.Now()
+	}
+
+	current.Sources = append(current.Sources, source)
+	if err := writeManifest(project, current); err != nil {
+		return Source{}, err
+	}
+	return source, nil
+}
+
+// List returns the sources of a project in the order they were added.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// []Source: The sources
+// error: An error if one occurred
+func List(project *models.Project) ([]Source, error) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	current, err := readManifest(project)
+	if err != nil {
+		return nil, err
+	}
+	return current.Sources, nil
+}
+
+// Get returns a source of a project.
+//
+// Args:
+// project (*models.Project): The project
+// id (string): The ID of the source
+//
+// Returns:
+// Source: The source
+// error: ErrSourceNotFound if there is no such source
+func Get(project *models.Project, id string) (Source, error) {
+	if !idPattern.MatchString(id) {
+		return Source{}, ErrSourceNotFound
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	current, err := readManifest(project)
+	if err != nil {
+		return Source{}, err
+	}
+	for _, source := range current.Sources {
+		if source.ID == id {
+			return source, nil
+		}
+	}
+	return Source{}, ErrSourceNotFound
+}
+
+// Remove cuts the segments of a source out of the source wordlist and the
+// rules and masks sources, and forgets the source. The new manifest is
+// recorded in a removal file before any file is rewritten, and the removal
+// file is only deleted once the manifest has been replaced, so an interrupted
+// removal is completed by CompleteRemoval. Every file is rewritten next to
+// itself and renamed into place, so it briefly takes twice its size on disk.
+// The SourceMu of the project must be held.
+//
+// Args:
+// project (*models.Project): The project
+// id (string): The ID of the source
+//
+// Returns:
+// Source: The removed source
+// error: ErrSourceNotFound if there is no such source
+func Remove(project *models.Project, id string) (Source, error) {
+	if !idPattern.MatchString(id) {
+		return Source{}, ErrSourceNotFound
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	if err := completePendingRemoval(project); err != nil {
+		return Source{}, err
+	}
+	current, err := readManifest(project)
+	if err != nil {
+		return Source{}, err
+	}
+
+	index := -1
+	for i, source := range current.Sources {
+		if source.ID == id {
+			index = i
+		}
+	}
+	if index == -1 {
+		return Source{}, ErrSourceNotFound
+	}
+	removed := current.Sources[index]
+
+	kept := append(current.Sources[:index], current.Sources[index+1:]...)
+	for i := range kept {
+		if kept[i].Offset > removed.Offset {
+			kept[i].Offset -= removed.Length
+		}
+		if kept[i].RulesOffset > removed.RulesOffset {
+			kept[i].RulesOffset -= removed.RulesLength
+		}
+		if kept[i].MasksOffset > removed.MasksOffset {
+			kept[i].MasksOffset -= removed.MasksLength
+		}
+	}
+	current.Sources = kept
+
+	pending := removal{Source: removed, Manifest: current}
+	if pending.SourceSize, err = fileSize(project.SourceWordlist); err != nil {
+		return Source{}, err
+	}
+	if pending.RulesSize, err = fileSize(project.RulesSource); err != nil {
+		return Source{}, err
+	}
+	if pending.MasksSize, err = fileSize(project.MasksSource); err != nil {
+		return Source{}, err
+	}
+	// A segment outside of its file would leave a removal that can never
+	// be completed and blocks every later append
+	for _, cut := range pending.segments(project) {
+		if cut.length > 0 && (cut.offset < 0 || cut.offset+cut.length > cut.size) {
+			return Source{}, fmt.Errorf("segment %d-%d is outside of %s", cut.offset, cut.offset+cut.length, cut.path)
+		}
+	}
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return Source{}, err
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(project.Directory, removalFile), data, 0644); err != nil {
+		return Source{}, err
+	}
+
+	if err := completeRemoval(project, pending); err != nil {
+		return Source{}, err
+	}
+	return removed, nil
+}
+
+// CompleteRemoval completes a removal that was interrupted by a crash or
+// failed part way. Nothing may be appended to the source wordlist or the
+// rules and masks sources before it succeeds, since the removal relies on
+// their sizes. The SourceMu of the project must be held.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// Source: The removed source or an empty one if no removal was pending
+// error: An error if one occurred
+func CompleteRemoval(project *models.Project) (Source, error) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	pending, ok, err := readRemoval(project)
+	if err != nil || !ok {
+		return Source{}, err
+	}
+	if err := completeRemoval(project, pending); err != nil {
+		return Source{}, err
+	}
+	return pending.Source, nil
+}
+
+// Truncate forgets every source added after the first count, after their
+// segments were cut off the source wordlist. Sources are only added and
+// removed while the SourceMu of the project is held, which must be held here
+// as well.
+//
+// Args:
+// project (*models.Project): The project
+// count (int): The number of sources to keep
+//
+// Returns:
+// []Source: The forgotten sources
+// error: An error if one occurred
+func Truncate(project *models.Project, count int) ([]Source, error) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	current, err := readManifest(project)
+	if err != nil {
+		return nil, err
+	}
+	if count >= len(current.Sources) {
+		return nil, nil
+	}
+
+	dropped := append([]Source(nil), current.Sources[count:]...)
+	current.Sources = current.Sources[:count]
+	if err := writeManifest(project, current); err != nil {
+		return nil, err
+	}
+	return dropped, nil
+}
+
+// HashFile returns the SHA-256 checksum of a file as a hex string.
+//
+// Args:
+// path (string): The path to the file
+//
+// Returns:
+// string: The checksum
+// error: An error if one occurred
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ParseTags splits a comma separated list of tags and drops empty ones.
+//
+// Args:
+// value (string): The comma separated tags
+//
+// Returns:
+// []string: The tags
+func ParseTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// removeSegment replaces a file with a copy that leaves out a segment.
+//
+// Args:
+// sourcePATH (string): The path to the file
+// offset (int64): The start of the segment
+// length (int64): The length of the segment
+//
+// Returns:
+// error: An error if one occurred
+func removeSegment(sourcePATH string, offset int64, length int64) error {
+	source, err := os.Open(sourcePATH)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset+length > info.Size() {
+		return fmt.Errorf("segment %d-%d is outside of %s", offset, offset+length, sourcePATH)
+	}
+
+	file, err := utils.CreateAtomic(sourcePATH, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer file.Abort()
+
+	if _, err := io.Copy(file, io.NewSectionReader(source, 0, offset)); err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, io.NewSectionReader(source, offset+length, info.Size()-offset-length)); err != nil {
+		return err
+	}
+	return file.Commit()
+}
+
+// completePendingRemoval completes the removal recorded in the removal file
+// of a project if there is one. manifestMu must be held.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// error: An error if one occurred
+func completePendingRemoval(project *models.Project) error {
+	pending, ok, err := readRemoval(project)
+	if err != nil || !ok {
+		return err
+	}
+	return completeRemoval(project, pending)
+}
+
+// completeRemoval cuts every segment of a removal out of its file unless the
+// file already has the size it has without it, replaces the manifest, and
+// deletes the removal file. Running it again after a crash finishes the
+// removal. manifestMu must be held.
+//
+// Args:
+// project (*models.Project): The project
+// pending (removal): The removal
+//
+// Returns:
+// error: An error if one occurred
+func completeRemoval(project *models.Project, pending removal) error {
+	for _, cut := range pending.segments(project) {
+		if cut.length == 0 {
+			continue
+		}
+		size, err := fileSize(cut.path)
+		if err != nil {
+			return err
+		}
+		switch size {
+		case cut.size:
+			if err := removeSegment(cut.path, cut.offset, cut.length); err != nil {
+				return err
+			}
+		case cut.size - cut.length:
+			// The segment was cut out before the removal was interrupted
+		default:
+			return fmt.Errorf("%s changed during the removal of source %s", cut.path, pending.Source.ID)
+		}
+	}
+
+	if err := writeManifest(project, pending.Manifest); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(project.Directory, removalFile))
+}
+
+// readRemoval reads the removal file of a project. manifestMu must be held.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// removal: The removal
+// bool: False if no removal is pending
+// error: An error if one occurred
+func readRemoval(project *models.Project) (removal, bool, error) {
+	var pending removal
+	data, err := os.ReadFile(filepath.Join(project.Directory, removalFile))
+	if os.IsNotExist(err) {
+		return pending, false, nil
+	}
+	if err != nil {
+		return pending, false, err
+	}
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return pending, false, fmt.Errorf("invalid source removal: %w", err)
+	}
+	return pending, true, nil
+}
+
+// fileSize returns the size of a file, which is zero if it does not exist.
+//
+// Args:
+// path (string): The path to the file
+//
+// Returns:
+// int64: The size of the file
+// error: An error if one occurred
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// readManifest reads the manifest file of a project. manifestMu must be
+// held.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// manifest: The manifest or an empty one if none has been written
+// error: An error if one occurred
+func readManifest(project *models.Project) (manifest, error) {
+	var current manifest
+	data, err := os.ReadFile(filepath.Join(project.Directory, manifestFile))
+	if os.IsNotExist(err) {
+		return current, nil
+	}
+	if err != nil {
+		return current, err
+	}
+	if err := json.Unmarshal(data, &current); err != nil {
+		return current, fmt.Errorf("invalid sources manifest: %w", err)
+	}
+	return current, nil
+}
+
+// writeManifest replaces the manifest file of a project. manifestMu must be
+// held.
+//
+// Args:
+// project (*models.Project): The project
+// current (manifest): The manifest
+//
+// Returns:
+// error: An error if one occurred
+func writeManifest(project *models.Project, current manifest) error {
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(project.Directory, manifestFile), data, 0644)
+}
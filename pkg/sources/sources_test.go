@@ -0,0 +1,219 @@
+package sources
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"ponder/pkg/models"
+	"testing"
+)
+
+// testProject returns a project in a new directory with two sources. The
+// data of the first is "first", the data of the second "second".
+func testProject(t *testing.T) (*models.Project, Source, Source) {
+	t.Helper()
+
+	dir := t.TempDir()
+	project := &models.Project{
+		Name:           "test",
+		Directory:      dir,
+		SourceWordlist: filepath.Join(dir, "source-wordlist.txt"),
+		RulesSource:    filepath.Join(dir, "rules-source.counts"),
+		MasksSource:    filepath.Join(dir, "masks-source.counts"),
+	}
+	writeFile(t, project.SourceWordlist, "legacy\nfirst\nsecond\n")
+	writeFile(t, project.RulesSource, "1\t:\n1\t$1\n")
+	writeFile(t, project.MasksSource, "1\t?l?l?l?l?l\n1\t?l?l?l?l?l?l\n")
+
+	first, err := Add(project, Source{Name: "first.txt", Offset: 7, Length: 6, RulesOffset: 0, RulesLength: 4, MasksOffset: 0, MasksLength: 13})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Add(project, Source{Name: "second.txt", Offset: 13, Length: 7, RulesOffset: 4, RulesLength: 5, MasksOffset: 13, MasksLength: 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return project, first, second
+}
+
+// writeFile writes a file or fails the test.
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile reads a file or fails the test.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// checkRemoved fails the test unless only the second source and its data
+// are left.
+func checkRemoved(t *testing.T, project *models.Project, second Source) {
+	t.Helper()
+
+	if got := readFile(t, project.SourceWordlist); got != "legacy\nsecond\n" {
+		t.Errorf("source wordlist is %q", got)
+	}
+	if got := readFile(t, project.RulesSource); got != "1\t$1\n" {
+		t.Errorf("rules source is %q", got)
+	}
+	if got := readFile(t, project.MasksSource); got != "1\t?l?l?l?l?l?l\n" {
+		t.Errorf("masks source is %q", got)
+	}
+
+	list, err := List(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := second
+	want.Offset, want.RulesOffset, want.MasksOffset = 7, 0, 0
+	if len(list) != 1 || list[0].ID != want.ID || list[0].Offset != want.Offset || list[0].RulesOffset != want.RulesOffset || list[0].MasksOffset != want.MasksOffset {
+		t.Errorf("got sources %+v, want %+v", list, want)
+	}
+	if _, err := os.Stat(filepath.Join(project.Directory, removalFile)); !os.IsNotExist(err) {
+		t.Errorf("the removal file was not deleted: %v", err)
+	}
+}
+
+func TestRemoveSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wordlist.txt")
+	writeFile(t, path, "aaa\nbbb\nccc\n")
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		offset int64
+		length int64
+		want   string
+	}{
+		{4, 4, "aaa\nccc\n"},
+		{4, 0, "aaa\nccc\n"},
+		{4, 4, "aaa\n"},
+		{0, 4, ""},
+	} {
+		if err := removeSegment(path, tc.offset, tc.length); err != nil {
+			t.Fatalf("removeSegment(%d, %d): %v", tc.offset, tc.length, err)
+		}
+		if got := readFile(t, path); got != tc.want {
+			t.Errorf("removeSegment(%d, %d) left %q, want %q", tc.offset, tc.length, got, tc.want)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("removeSegment changed the permissions to %v", info.Mode().Perm())
+	}
+
+	writeFile(t, path, "aaa\n")
+	if err := removeSegment(path, 2, 4); err == nil {
+		t.Error("removeSegment accepted a segment past the end of the file")
+	}
+	if err := removeSegment(path, -1, 1); err == nil {
+		t.Error("removeSegment accepted a negative offset")
+	}
+	if got := readFile(t, path); got != "aaa\n" {
+		t.Errorf("a rejected segment changed the file to %q", got)
+	}
+}
+
+func TestRemoveCutsEverySegmentOfTheSource(t *testing.T) {
+	project, first, second := testProject(t)
+
+	removed, err := Remove(project, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed.ID != first.ID {
+		t.Errorf("removed source %s, want %s", removed.ID, first.ID)
+	}
+	checkRemoved(t, project, second)
+
+	if _, err := Remove(project, first.ID); err != ErrSourceNotFound {
+		t.Errorf("removing the source again returned %v, want ErrSourceNotFound", err)
+	}
+}
+
+func TestCompleteRemovalFinishesInterruptedRemoval(t *testing.T) {
+	for _, cut := range []string{"none", "source wordlist", "all"} {
+		t.Run(cut, func(t *testing.T) {
+			project, first, second := testProject(t)
+			if _, err := Remove(project, first.ID); err != nil {
+				t.Fatal(err)
+			}
+			// Restore the state Remove left behind when the server stopped
+			// after writing the removal file and cutting some segments
+			current, err := readManifest(project)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pending := removal{Source: first, SourceSize: 20, RulesSize: 9, MasksSize: 28, Manifest: current}
+			if err := writeManifest(project, manifest{Sources: []Source{first, second}}); err != nil {
+				t.Fatal(err)
+			}
+			switch cut {
+			case "none":
+				writeFile(t, project.SourceWordlist, "legacy\nfirst\nsecond\n")
+				fallthrough
+			case "source wordlist":
+				writeFile(t, project.RulesSource, "1\t:\n1\t$1\n")
+				writeFile(t, project.MasksSource, "1\t?l?l?l?l?l\n1\t?l?l?l?l?l?l\n")
+			}
+			writeRemoval(t, project, pending)
+
+			removed, err := CompleteRemoval(project)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed.ID != first.ID {
+				t.Errorf("completed the removal of %q, want %s", removed.ID, first.ID)
+			}
+			checkRemoved(t, project, second)
+		})
+	}
+}
+
+func TestCompleteRemovalRefusesChangedFiles(t *testing.T) {
+	project, first, second := testProject(t)
+	current := manifest{Sources: []Source{second}}
+	writeRemoval(t, project, removal{Source: first, SourceSize: 20, RulesSize: 9, MasksSize: 28, Manifest: current})
+	writeFile(t, project.SourceWordlist, "legacy\nfirst\nsecond\nappended\n")
+
+	if _, err := CompleteRemoval(project); err == nil {
+		t.Fatal("CompleteRemoval cut a segment out of a file that changed")
+	}
+	if got := readFile(t, project.SourceWordlist); got != "legacy\nfirst\nsecond\nappended\n" {
+		t.Errorf("source wordlist is %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(project.Directory, removalFile)); err != nil {
+		t.Errorf("the removal file was deleted: %v", err)
+	}
+
+	if removed, err := CompleteRemoval(&models.Project{Directory: t.TempDir()}); err != nil || removed.ID != "" {
+		t.Errorf("CompleteRemoval without a removal returned %+v and %v", removed, err)
+	}
+}
+
+// writeRemoval writes the removal file of a project or fails the test.
+func writeRemoval(t *testing.T, project *models.Project, pending removal) {
+	t.Helper()
+
+	data, err := json.Marshal(pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(project.Directory, removalFile), string(data))
+}
@@ -0,0 +1,257 @@
+// Package state persists the upload and generation timestamps and the
+// unfinished jobs of every project so pending work resumes after a restart
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"ponder/pkg/models"
+	"ponder/pkg/utils"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// stateFile is the name of the state file in the directory of a project
+const stateFile = "state.json"
+
+// PendingJob is a queued or running job that is performed again when the
+// server restarts before it finishes
+type PendingJob struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// StagedPath is the staged file of an upload
+	StagedPath string `json:"staged_path,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	// Format and FormatFields describe the layout of the uploaded lines
+	Format       string `json:"format,omitempty"`
+	FormatFields int    `json:"format_fields,omitempty"`
+	// Name, Uploader, and Tags are recorded with the sources the job adds
+	Name     string   `json:"name,omitempty"`
+	Uploader string   `json:"uploader,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// Key identifies an uploaded file across uploads for the ignore dedup
+	// mode. Imports key every file by its name.
+	Key    string    `json:"key,omitempty"`
+	Queued time.Time `json:"queued"`
+	// Checkpoint is set while the job appends to the source files of the
+	// project, so an interrupted append can be cut off before the job is
+	// performed again
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+	// Imported lists the files of an import that were appended completely
+	Imported []string `json:"imported,omitempty"`
+}
+
+// Checkpoint holds the sizes of the source wordlist and the collected rules
+// and masks of a project, and the number of its sources, before a job started
+// appending to them
+type Checkpoint struct {
+	SourceSize int64 `json:"source_size"`
+	RulesSize  int64 `json:"rules_size"`
+	MasksSize  int64 `json:"masks_size"`
+	Sources    int   `json:"sources"`
+}
+
+// State is the layout of the state file on disk
+type State struct {
+	LastUploaded time.Time    `json:"last_uploaded"`
+	LastUpdated  time.Time    `json:"last_updated"`
+	PendingJobs  []PendingJob `json:"pending_jobs"`
+}
+
+// stateMu guards the state files of every project
+var stateMu sync.Mutex
+
+// lastPendingID is the ID of the latest pending job
+var lastPendingID int64
+
+// Load applies the timestamps recorded in the state file to a project.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// error: An error if one occurred
+func Load(project *models.Project) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	current, err := readState(project)
+	if err != nil {
+		return err
+	}
+	project.SetLastUploaded(current.LastUploaded)
+	project.SetLastUpdated(current.LastUpdated)
+	return nil
+}
+
+// Save records the upload and generation timestamps of a project.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// error: An error if one occurred
+func Save(project *models.Project) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	current, err := readState(project)
+	if err != nil {
+		return err
+	}
+	current.LastUploaded = project.LastUploaded()
+	current.LastUpdated = project.LastUpdated()
+	return writeState(project, current)
+}
+
+// AddPendingJob records a job that has to be performed again if the server
+// restarts before it finishes.
+//
+// Args:
+// project (*models.Project): The project the job belongs to
+// job (PendingJob): The job without an ID
+//
+// Returns:
+// string: The ID to pass to RemovePendingJob once the job finishes
+// error: An error if one occurred
+func AddPendingJob(project *models.Project, job PendingJob) (string, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	current, err := readState(project)
+	if err != nil {
+		return "", err
+	}
+
+	// IDs are based on the clock so they stay unique across restarts
+	id := time.Now().UnixNano()
+	if id <= lastPendingID {
+		id = lastPendingID + 1
+	}
+	lastPendingID = id
+
+	job.ID = strconv.FormatInt(id, 10)
+	if job.Queued.IsZero() {
+		job.Queued = time.Now()
+	}
+	current.PendingJobs = append(current.PendingJobs, job)
+	if err := writeState(project, current); err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// UpdatePendingJob replaces the record of a job, for example to set or clear
+// its checkpoint. A job that is no longer recorded is left alone.
+//
+// Args:
+// project (*models.Project): The project the job belongs to
+// job (PendingJob): The job with the ID returned by AddPendingJob
+//
+// Returns:
+// error: An error if one occurred
+func UpdatePendingJob(project *models.Project, job PendingJob) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	current, err := readState(project)
+	if err != nil {
+		return err
+	}
+
+	for i := range current.PendingJobs {
+		if current.PendingJobs[i].ID == job.ID {
+			current.PendingJobs[i] = job
+			return writeState(project, current)
+		}
+	}
+	return nil
+}
+
+// RemovePendingJob forgets a job once it has finished or failed.
+//
+// Args:
+// project (*models.Project): The project the job belongs to
+// id (string): The ID returned by AddPendingJob
+//
+// Returns:
+// error: An error if one occurred
+func RemovePendingJob(project *models.Project, id string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	current, err := readState(project)
+	if err != nil {
+		return err
+	}
+
+	kept := current.PendingJobs[:0]
+	for _, job := range current.PendingJobs {
+		if job.ID != id {
+			kept = append(kept, job)
+		}
+	}
+	current.PendingJobs = kept
+	return writeState(project, current)
+}
+
+// PendingJobs returns the unfinished jobs of a project, oldest first.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// []PendingJob: The jobs
+// error: An error if one occurred
+func PendingJobs(project *models.Project) ([]PendingJob, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	current, err := readState(project)
+	if err != nil {
+		return nil, err
+	}
+	return current.PendingJobs, nil
+}
+
+// readState reads the state file of a project. stateMu must be held.
+//
+// Args:
+// project (*models.Project): The project
+//
+// Returns:
+// State: The state or an empty one if none has been written
+// error: An error if one occurred
+func readState(project *models.Project) (State, error) {
+	var current State
+	data, err := os.ReadFile(filepath.Join(project.Directory, stateFile))
+	if os.IsNotExist(err) {
+		return current, nil
+	}
+	if err != nil {
+		return current, err
+	}
+	if err := json.Unmarshal(data, &current); err != nil {
+		return current, fmt.Errorf("invalid state file: %w", err)
+	}
+	return current, nil
+}
+
+// writeState replaces the state file of a project. stateMu must be held.
+//
+// Args:
+// project (*models.Project): The project
+// current (State): The state
+//
+// Returns:
+// error: An error if one occurred
+func writeState(project *models.Project, current State) error {
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(project.Directory, stateFile), data, 0644)
+}
@@ -0,0 +1,431 @@
+// Package uploads stages resumable uploads on disk. An upload is created with
+// its total length, receives its data in chunks at increasing offsets, and is
+// handed to the ingest pipeline once every byte has arrived. The staged data
+// outlives dropped connections and restarts.
+package uploads
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"ponder/pkg/models"
+	"ponder/pkg/utils"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUploadNotFound is returned when an upload does not exist
+var ErrUploadNotFound = errors.New("upload not found")
+
+// ErrOffsetMismatch is returned when a chunk does not start where the staged
+// data ends
+var ErrOffsetMismatch = errors.New("upload offset mismatch")
+
+// ErrUploadTooLarge is returned when a chunk goes past the length of the
+// upload
+var ErrUploadTooLarge = errors.New("upload exceeds its length")
+
+// ErrUploadIncomplete is returned when finalizing an upload that has not
+// received every byte
+var ErrUploadIncomplete = errors.New("upload incomplete")
+
+// idPattern matches upload IDs so they are safe to use in file names
+var idPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// Upload describes a resumable upload
+type Upload struct {
+	ID     string `json:"id"`
+	Length int64  `json:"length"`
+	// Offset is the number of bytes staged so far
+	Offset int64 `json:"offset"`
+	// Format and FormatFields describe the layout of the uploaded lines
+	Format       string `json:"format"`
+	FormatFields int    `json:"format_fields"`
+	// Name, Uploader, and Tags are recorded with the source once the upload
+	// is ingested
+	Name     string   `json:"name,omitempty"`
+	Uploader string   `json:"uploader,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// Key identifies the file across uploads for the ignore dedup mode
+	Key     string    `json:"key,omitempty"`
+	Created time.Time `json:"created"`
+	// Updated is the time data was last staged, taken from the staged data
+	Updated time.Time `json:"-"`
+}
+
+// uploadLock serializes the requests of an upload and counts the requests
+// using it, so it is forgotten once none does
+type uploadLock struct {
+	sync.Mutex
+	users int
+}
+
+// locksMu guards locks
+var locksMu sync.Mutex
+
+// locks holds the lock of every upload with a request in progress
+var locks = map[string]*uploadLock{}
+
+// Create stages a new, empty upload.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// upload (Upload): The length, format, and provenance of the upload
+//
+// Returns:
+// Upload: The upload with its ID
+// error: An error if one occurred
+func Create(project *models.Project, upload Upload) (Upload, error) {
+	if err := os.MkdirAll(project.StagingDirectory, 0755); err != nil {
+		return Upload{}, fmt.Errorf("error creating staging directory: %w", err)
+	}
+
+	id := make([]byte, 8)
+	// crypto/rand only fails if the system has no entropy source at all
+	rand.Read(id)
+	upload.ID = hex.EncodeToString(id)
+	upload.Offset = 0
+	upload.Created = time.Now()
+
+	dataFile, err := os.OpenFile(dataPath(project, upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return Upload{}, err
+	}
+	dataFile.Close()
+
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return Upload{}, err
+	}
+	if err := utils.WriteFileAtomic(infoPath(project, upload.ID), data, 0644); err != nil {
+		os.Remove(dataPath(project, upload.ID))
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+// Get returns an upload with its current offset.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// id (string): The ID of the upload
+//
+// Returns:
+// Upload: The upload
+// error: ErrUploadNotFound if there is no such upload
+func Get(project *models.Project, id string) (Upload, error) {
+	lock := lockUpload(id)
+	defer unlockUpload(id, lock)
+
+	return readUpload(project, id)
+}
+
+// Append stages a chunk of an upload. The chunk must start at the current
+// offset. Data received before a read error is kept, so the client can
+// resume from the returned offset.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// id (string): The ID of the upload
+// offset (int64): The offset the chunk starts at
+// r (io.Reader): The chunk
+//
+// Returns:
+// Upload: The upload with its new offset
+// error: ErrOffsetMismatch, ErrUploadTooLarge, or an error if one occurred
+func Append(project *models.Project, id string, offset int64, r io.Reader) (Upload, error) {
+	lock := lockUpload(id)
+	defer unlockUpload(id, lock)
+
+	upload, err := readUpload(project, id)
+	if err != nil {
+		return upload, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrOffsetMismatch
+	}
+
+	dataFile, err := os.OpenFile(dataPath(project, id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return upload, err
+	}
+	written, copyErr := io.Copy(dataFile, io.LimitReader(r, upload.Length-upload.Offset))
+	// The offset reported to the client has to be on disk
+	syncErr := dataFile.Sync()
+	if closeErr := dataFile.Close(); syncErr == nil {
+		syncErr = closeErr
+	}
+	upload.Offset += written
+	if copyErr != nil {
+		return upload, copyErr
+	}
+	if syncErr != nil {
+		return upload, syncErr
+	}
+
+	if n, _ := r.Read(make([]byte, 1)); n > 0 {
+		return upload, ErrUploadTooLarge
+	}
+	return upload, nil
+}
+
+// Finalize moves the data of a complete upload to a staged file for the
+// ingest pipeline and forgets the upload.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// id (string): The ID of the upload
+//
+// Returns:
+// Upload: The upload
+// string: The path to the staged file
+// error: ErrUploadIncomplete or an error if one occurred
+func Finalize(project *models.Project, id string) (Upload, string, error) {
+	lock := lockUpload(id)
+	defer unlockUpload(id, lock)
+
+	upload, err := readUpload(project, id)
+	if err != nil {
+		return upload, "", err
+	}
+	if upload.Offset != upload.Length {
+		return upload, "", ErrUploadIncomplete
+	}
+
+	stagedPath := filepath.Join(project.StagingDirectory, "upload-"+id)
+	if err := os.Rename(dataPath(project, id), stagedPath); err != nil {
+		return upload, "", err
+	}
+	if err := os.Remove(infoPath(project, id)); err != nil {
+		return upload, "", err
+	}
+	return upload, stagedPath, nil
+}
+
+// Restore turns the staged file of a finalized upload back into the upload,
+// so finalizing it can be retried when the file could not be queued.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// upload (Upload): The upload returned by Finalize
+// stagedPath (string): The path to the staged file returned by Finalize
+//
+// Returns:
+// error: An error if one occurred
+func Restore(project *models.Project, upload Upload, stagedPath string) error {
+	lock := lockUpload(upload.ID)
+	defer unlockUpload(upload.ID, lock)
+
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(stagedPath, dataPath(project, upload.ID)); err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(infoPath(project, upload.ID), data, 0644); err != nil {
+		os.Rename(dataPath(project, upload.ID), stagedPath)
+		return err
+	}
+	return nil
+}
+
+// Abort removes an upload and its staged data.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// id (string): The ID of the upload
+//
+// Returns:
+// error: ErrUploadNotFound if there is no such upload
+func Abort(project *models.Project, id string) error {
+	lock := lockUpload(id)
+	defer unlockUpload(id, lock)
+
+	if _, err := readUpload(project, id); err != nil {
+		return err
+	}
+	if err := os.Remove(dataPath(project, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(infoPath(project, id)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Expire removes the uploads that have not received data for longer than the
+// given age and have not been finalized, along with their staged data. A slow
+// upload that keeps sending chunks is kept however long it takes.
+//
+// Args:
+// project (*models.Project): The project receiving the uploads
+// maxAge (time.Duration): The time an upload may go without data
+//
+// Returns:
+// []Upload: The removed uploads
+// error: An error if one occurred
+func Expire(project *models.Project, maxAge time.Duration) ([]Upload, error) {
+	infoPaths, err := filepath.Glob(filepath.Join(project.StagingDirectory, "resumable-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []Upload
+	for _, path := range infoPaths {
+		id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "resumable-"), ".json")
+		upload, err := expireUpload(project, id, maxAge)
+		if err != nil {
+			return expired, err
+		}
+		if upload != nil {
+			expired = append(expired, *upload)
+		}
+	}
+	return expired, nil
+}
+
+// expireUpload removes an upload if it has not received data for longer than
+// the given age.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// id (string): The ID of the upload
+// maxAge (time.Duration): The time an upload may go without data
+//
+// Returns:
+// *Upload: The removed upload or nil if it was kept
+// error: An error if one occurred
+func expireUpload(project *models.Project, id string, maxAge time.Duration) (*Upload, error) {
+	lock := lockUpload(id)
+	defer unlockUpload(id, lock)
+
+	upload, err := readUpload(project, id)
+	// Finalized and aborted uploads are gone by the time the lock is held
+	if errors.Is(err, ErrUploadNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(upload.Updated) <= maxAge {
+		return nil, nil
+	}
+
+	if err := os.Remove(dataPath(project, id)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := os.Remove(infoPath(project, id)); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// readUpload reads the description of an upload and the size of its staged
+// data. The lock of the upload must be held.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// id (string): The ID of the upload
+//
+// Returns:
+// Upload: The upload
+// error: ErrUploadNotFound if there is no such upload
+func readUpload(project *models.Project, id string) (Upload, error) {
+	var upload Upload
+	if !idPattern.MatchString(id) {
+		return upload, ErrUploadNotFound
+	}
+
+	data, err := os.ReadFile(infoPath(project, id))
+	if os.IsNotExist(err) {
+		return upload, ErrUploadNotFound
+	}
+	if err != nil {
+		return upload, err
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return upload, fmt.Errorf("invalid upload %s: %w", id, err)
+	}
+
+	info, err := os.Stat(dataPath(project, id))
+	if err != nil {
+		return upload, err
+	}
+	upload.Offset = info.Size()
+	upload.Updated = info.ModTime()
+	return upload, nil
+}
+
+// lockUpload takes the lock serializing the requests of an upload. It must be
+// released with unlockUpload.
+//
+// Args:
+// id (string): The ID of the upload
+//
+// Returns:
+// *uploadLock: The held lock
+func lockUpload(id string) *uploadLock {
+	locksMu.Lock()
+	lock, ok := locks[id]
+	if !ok {
+		lock = &uploadLock{}
+		locks[id] = lock
+	}
+	lock.users++
+	locksMu.Unlock()
+
+	lock.Lock()
+	return lock
+}
+
+// unlockUpload releases the lock of an upload and forgets it once no request
+// uses it, so IDs of finished or unknown uploads do not pile up.
+//
+// Args:
+// id (string): The ID of the upload
+// lock (*uploadLock): The lock returned by lockUpload
+//
+// Returns:
+// None
+func unlockUpload(id string, lock *uploadLock) {
+	lock.Unlock()
+
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	lock.users--
+	if lock.users == 0 {
+		delete(locks, id)
+	}
+}
+
+// infoPath returns the path to the description of an upload.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// id (string): The ID of the upload
+//
+// Returns:
+// string: The path
+func infoPath(project *models.Project, id string) string {
+	return filepath.Join(project.StagingDirectory, "resumable-"+id+".json")
+}
+
+// dataPath returns the path to the staged data of an upload.
+//
+// Args:
+// project (*models.Project): The project receiving the upload
+// id (string): The ID of the upload
+//
+// Returns:
+// string: The path
+func dataPath(project *models.Project, id string) string {
+	return filepath.Join(project.StagingDirectory, "resumable-"+id+".part")
+}
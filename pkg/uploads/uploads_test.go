@@ -0,0 +1,199 @@
+package uploads
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"ponder/pkg/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testProject returns a project with a new staging directory.
+func testProject(t *testing.T) *models.Project {
+	t.Helper()
+
+	return &models.Project{Name: "test", StagingDirectory: filepath.Join(t.TempDir(), "staging")}
+}
+
+func TestAppendRejectsChunkAtWrongOffset(t *testing.T) {
+	project := testProject(t)
+	upload, err := Create(project, Upload{Length: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Append(project, upload.ID, 0, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, offset := range []int64{0, 4, 6} {
+		got, err := Append(project, upload.ID, offset, strings.NewReader("world"))
+		if !errors.Is(err, ErrOffsetMismatch) {
+			t.Errorf("offset %d: got error %v, want ErrOffsetMismatch", offset, err)
+		}
+		if got.Offset != 5 {
+			t.Errorf("offset %d: upload offset is %d, want 5", offset, got.Offset)
+		}
+	}
+
+	if got, err := Get(project, upload.ID); err != nil || got.Offset != 5 {
+		t.Errorf("got offset %d and error %v after mismatched chunks, want 5", got.Offset, err)
+	}
+}
+
+func TestAppendRejectsDataPastLength(t *testing.T) {
+	project := testProject(t)
+	upload, err := Create(project, Upload{Length: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Append(project, upload.ID, 0, strings.NewReader("password123"))
+	if !errors.Is(err, ErrUploadTooLarge) {
+		t.Fatalf("got error %v, want ErrUploadTooLarge", err)
+	}
+	// Only the bytes up to the length are staged
+	if got.Offset != 8 {
+		t.Errorf("upload offset is %d, want 8", got.Offset)
+	}
+	data, err := os.ReadFile(dataPath(project, upload.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "password" {
+		t.Errorf("staged data is %q, want %q", data, "password")
+	}
+
+	// A chunk that fits exactly is accepted
+	upload, err = Create(project, Upload{Length: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Append(project, upload.ID, 0, strings.NewReader("password")); err != nil {
+		t.Errorf("chunk of exactly the length: %v", err)
+	}
+}
+
+func TestExpireRemovesOnlyInactiveUploads(t *testing.T) {
+	project := testProject(t)
+	active, err := Create(project, Upload{Length: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inactive, err := Create(project, Upload{Length: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Append(project, inactive.ID, 0, strings.NewReader("ab")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both uploads were created long ago, but only one is still receiving
+	// chunks
+	for _, upload := range []Upload{active, inactive} {
+		setCreated(t, project, upload, upload.Created.Add(-48*time.Hour))
+	}
+	lastChunk := time.Now().Add(-25 * time.Hour)
+	if err := os.Chtimes(dataPath(project, inactive.ID), lastChunk, lastChunk); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Append(project, active.ID, 0, strings.NewReader("cd")); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := Expire(project, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].ID != inactive.ID || expired[0].Offset != 2 {
+		t.Fatalf("got expired uploads %+v, want %s", expired, inactive.ID)
+	}
+	if _, err := Get(project, inactive.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("expired upload: got error %v, want ErrUploadNotFound", err)
+	}
+	if _, err := os.Stat(dataPath(project, inactive.ID)); !os.IsNotExist(err) {
+		t.Errorf("the staged data of the expired upload was kept: %v", err)
+	}
+	if got, err := Get(project, active.ID); err != nil || got.Offset != 2 {
+		t.Errorf("active upload: got offset %d and error %v, want 2", got.Offset, err)
+	}
+}
+
+func TestRestoreKeepsUploadForRetriedFinalize(t *testing.T) {
+	project := testProject(t)
+	upload, err := Create(project, Upload{Length: 9, Name: "breach.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Append(project, upload.ID, 0, strings.NewReader("password\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	finalized, stagedPath, err := Finalize(project, upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(project, finalized, stagedPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stagedPath); !os.IsNotExist(err) {
+		t.Errorf("the staged file was kept after restoring: %v", err)
+	}
+	if got, err := Get(project, upload.ID); err != nil || got.Offset != 9 || got.Name != "breach.txt" {
+		t.Fatalf("restored upload is %+v with error %v", got, err)
+	}
+
+	_, stagedPath, err = Finalize(project, upload.ID)
+	if err != nil {
+		t.Fatalf("finalizing the restored upload: %v", err)
+	}
+	data, err := os.ReadFile(stagedPath)
+	if err != nil || string(data) != "password\n" {
+		t.Errorf("staged file holds %q and error %v", data, err)
+	}
+}
+
+func TestLocksAreForgotten(t *testing.T) {
+	project := testProject(t)
+	upload, err := Create(project, Upload{Length: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Append(project, upload.ID, 0, strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Finalize(project, upload.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"0123456789abcdef", "not-an-id", upload.ID} {
+		Get(project, id)
+		Append(project, id, 0, strings.NewReader("a"))
+		Abort(project, id)
+		Finalize(project, id)
+	}
+	if _, err := Expire(project, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	if len(locks) != 0 {
+		t.Errorf("%d upload locks were kept", len(locks))
+	}
+}
+
+// setCreated rewrites the creation time of an upload.
+func setCreated(t *testing.T, project *models.Project, upload Upload, created time.Time) {
+	t.Helper()
+
+	upload.Created = created
+	data, err := json.Marshal(upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(infoPath(project, upload.ID), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// AtomicFile is written to a temporary file next to its target and only
+// replaces the target once Commit succeeds, so readers never see a partially
+// written file and a crash leaves the previous version in place.
+type AtomicFile struct {
+	*os.File
+	path      string
+	committed bool
+}
+
+// CreateAtomic creates a temporary file in the directory of the target.
+//
+// Args:
+// path (string): The path the file is published to
+// perm (os.FileMode): The permissions of the published file
+//
+// Returns:
+// *AtomicFile: The file to write to
+// error: An error if one occurred
+func CreateAtomic(path string, perm os.FileMode) (*AtomicFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &AtomicFile{File: file, path: path}, nil
+}
+
+// Commit syncs the file to disk and renames it over the target.
+//
+// Args:
+// None
+//
+// Returns:
+// error: An error if one occurred
+func (f *AtomicFile) Commit() error {
+	if err := f.File.Sync(); err != nil {
+		return err
+	}
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	if err := renameSynced(f.File.Name(), f.path); err != nil {
+		return err
+	}
+	f.committed = true
+	return nil
+}
+
+// Abort closes and removes the temporary file unless it has been committed,
+// so it can be deferred right after CreateAtomic.
+//
+// Args:
+// None
+//
+// Returns:
+// None
+func (f *AtomicFile) Abort() {
+	if f.committed {
+		return
+	}
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
+
+// WriteFileAtomic writes data to a file through an AtomicFile.
+//
+// Args:
+// path (string): The path to the file
+// data ([]byte): The contents of the file
+// perm (os.FileMode): The permissions of the file
+//
+// Returns:
+// error: An error if one occurred
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	file, err := CreateAtomic(path, perm)
+	if err != nil {
+		return err
+	}
+	defer file.Abort()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Commit()
+}
+
+// PublishFile syncs a finished file and renames it over a target in the same
+// directory. The directory is synced as well so the rename survives a crash.
+//
+// Args:
+// tempPATH (string): The path to the finished file
+// targetPATH (string): The path to replace
+//
+// Returns:
+// error: An error if one occurred
+func PublishFile(tempPATH string, targetPATH string) error {
+	file, err := os.Open(tempPATH)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	return renameSynced(tempPATH, targetPATH)
+}
+
+// renameSynced renames a file and syncs its directory so the rename survives
+// a crash.
+//
+// Args:
+// tempPATH (string): The path to the finished file
+// targetPATH (string): The path to replace
+//
+// Returns:
+// error: An error if one occurred
+func renameSynced(tempPATH string, targetPATH string) error {
+	if err := os.Rename(tempPATH, targetPATH); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(targetPATH))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// RemoveStaleAtomicFiles removes temporary files of a target left behind by a
+// crash. It must only be called while nothing else is writing the target.
+//
+// Args:
+// path (string): The path the files would have been published to
+//
+// Returns:
+// None
+func RemoveStaleAtomicFiles(path string) {
+	stale, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"-*"))
+	for _, file := range stale {
+		os.Remove(file)
+	}
+}
+
+// LinkOrCopy hard links a file and falls back to copying it when the file
+// system does not support links.
+//
+// Args:
+// sourcePATH (string): The file to link
+// targetPATH (string): The path of the link
+//
+// Returns:
+// error: An error if one occurred
+func LinkOrCopy(sourcePATH string, targetPATH string) error {
+	if err := os.Link(sourcePATH, targetPATH); err == nil {
+		return nil
+	}
+
+	source, err := os.Open(sourcePATH)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := CreateAtomic(targetPATH, 0644)
+	if err != nil {
+		return err
+	}
+	defer target.Abort()
+
+	if _, err := io.Copy(target, source); err != nil {
+		return err
+	}
+	return target.Commit()
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"ponder/pkg/models"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// vowels holds the lowercase vowels of the alphabetic scripts that mark them
+// with distinct letters. Accented Latin vowels are matched through their base
+// letter.
+var vowels = map[*unicode.RangeTable]string{
+	unicode.Latin:    "aeiou",
+	unicode.Cyrillic: "аеёиоуыэюяіїєў",
+	unicode.Greek:    "αεηιουωάέήίόύώϊϋΐΰ",
+}
+
+// transliterations maps letters that do not decompose into an ASCII base
+// letter to their common ASCII spelling
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'þ': "th",
+	'ł': "l", 'ı': "i", 'ħ': "h",
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya", 'і': "i",
+	'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+}
+
+// isVowel checks if a character is a vowel in its script. Letters from
+// scripts without separate vowel letters, such as CJK ideographs or Hangul,
+// are treated as vowels so words in those scripts are not rejected.
+//
+// Args:
+// r (rune): The character to check
+//
+// Returns:
+// bool: True if the character is a vowel or a letter from a script without vowels
+func isVowel(r rune) bool {
+	if !unicode.IsLetter(r) {
+		return false
+	}
+
+	lower := unicode.ToLower(r)
+	for script, letters := range vowels {
+		if !unicode.Is(script, lower) {
+			continue
+		}
+		if strings.ContainsRune(letters, lower) {
+			return true
+		}
+		// Compare accented Latin letters by their base letter
+		base := []rune(norm.NFD.String(string(lower)))[0]
+		return strings.ContainsRune(letters, base)
+	}
+
+	return true
+}
+
+// AllowedByCharacterPolicy checks if a string may be kept under the character
+// policy. Without AllowUnicode only ASCII strings are kept, with it any valid
+// UTF-8 string is kept.
+//
+// Args:
+// s (string): The string to check
+// policy (models.CharacterPolicy): The character policy
+//
+// Returns:
+// bool: True if the string is allowed, false otherwise
+func AllowedByCharacterPolicy(s string, policy models.CharacterPolicy) bool {
+	if policy.AllowUnicode {
+		return utf8.ValidString(s)
+	}
+	return ContainsOnlyASCII(s)
+}
+
+// NormalizeCandidate applies the Unicode normalization form of the character
+// policy to a string.
+//
+// Args:
+// s (string): The string to normalize
+// policy (models.CharacterPolicy): The character policy
+//
+// Returns:
+// string: The normalized string
+func NormalizeCandidate(s string, policy models.CharacterPolicy) string {
+	switch policy.Normalization {
+	case "NFC":
+		return norm.NFC.String(s)
+	case "NFKC":
+		return norm.NFKC.String(s)
+	default:
+		return s
+	}
+}
+
+// TransliterateToASCII converts a string to an ASCII variant by stripping
+// diacritics and mapping Cyrillic and other special letters to their common
+// Latin spelling.
+//
+// Args:
+// s (string): The string to transliterate
+//
+// Returns:
+// string: The transliterated string
+// bool: True if every character could be converted to ASCII
+func TransliterateToASCII(s string) (string, bool) {
+	stripper := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	stripped, _, err := transform.String(stripper, s)
+	if err != nil {
+		return "", false
+	}
+
+	var b strings.Builder
+	for _, r := range stripped {
+		if r <= unicode.MaxASCII {
+			b.WriteRune(r)
+			continue
+		}
+		replacement, ok := transliterations[unicode.ToLower(r)]
+		if !ok {
+			return "", false
+		}
+		if unicode.IsUpper(r) {
+			replacement = strings.ToUpper(replacement)
+		}
+		b.WriteString(replacement)
+	}
+
+	return b.String(), true
+}
+
+// CandidateVariants normalizes a string and returns the variants of it that
+// are allowed by the character policy. When transliteration is enabled a
+// non-ASCII string also yields its ASCII variant. Strings that are not valid
+// UTF-8, typically decoded from $HEX[] plaintexts of legacy wordlists, are
+// read as ISO-8859-1 when non-ASCII candidates are wanted.
+//
+// Args:
+// s (string): The string to process
+// policy (models.CharacterPolicy): The character policy
+//
+// Returns:
+// []string: The allowed variants, which may be empty
+func CandidateVariants(s string, policy models.CharacterPolicy) []string {
+	if !utf8.ValidString(s) && (policy.AllowUnicode || policy.Transliterate) {
+		if decoded, err := charmap.ISO8859_1.NewDecoder().String(s); err == nil {
+			s = decoded
+		}
+	}
+
+	normalized := NormalizeCandidate(s, policy)
+	if ContainsOnlyASCII(normalized) {
+		return []string{normalized}
+	}
+
+	var variants []string
+	if AllowedByCharacterPolicy(normalized, policy) {
+		variants = append(variants, normalized)
+	}
+	if policy.Transliterate {
+		if ascii, ok := TransliterateToASCII(normalized); ok && ascii != normalized {
+			variants = append(variants, ascii)
+		}
+	}
+
+	return variants
+}
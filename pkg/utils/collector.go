@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// maxCollectedKeys is the number of distinct keys a CountCollector holds in
+// memory before it appends them to its file
+const maxCollectedKeys = 1000000
+
+// CountCollector counts a key derived from every plaintext of an upload and
+// appends the counts to a file as "count\tkey" records. The file can be
+// ranked with MergeCountsByFrequency.
+type CountCollector struct {
+	path   string
+	derive func(plaintext string) (string, bool)
+	counts map[string]int
+	err    error
+}
+
+// NewCountCollector creates a collector that appends to the given file.
+//
+// Args:
+// path (string): The path to the counts file
+// derive (func(string) (string, bool)): Returns the key of a plaintext or
+// false to skip it
+//
+// Returns:
+// *CountCollector: The collector
+func NewCountCollector(path string, derive func(plaintext string) (string, bool)) *CountCollector {
+	return &CountCollector{
+		path:   path,
+		derive: derive,
+		counts: make(map[string]int),
+	}
+}
+
+// Observe derives the key of a plaintext and counts it. Errors from early
+// flushes are returned by the next call to Flush.
+//
+// Args:
+// plaintext (string): The decoded plaintext
+//
+// Returns:
+// None
+func (c *CountCollector) Observe(plaintext string) {
+	key, ok := c.derive(plaintext)
+	if !ok {
+		return
+	}
+	c.counts[key]++
+	if len(c.counts) >= maxCollectedKeys {
+		if err := c.Flush(); err != nil && c.err == nil {
+			c.err = err
+		}
+	}
+}
+
+// Flush appends the collected counts to the file and resets them.
+//
+// Args:
+// None
+//
+// Returns:
+// error: An error if one occurred
+func (c *CountCollector) Flush() error {
+	if c.err != nil {
+		err := c.err
+		c.err = nil
+		return err
+	}
+	if len(c.counts) == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(c.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening counts file %s: %w", c.path, err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for key, count := range c.counts {
+		if _, err := fmt.Fprintf(writer, "%d\t%s\n", count, key); err != nil {
+			return fmt.Errorf("error writing counts file %s: %w", c.path, err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing counts file %s: %w", c.path, err)
+	}
+
+	c.counts = make(map[string]int)
+	return nil
+}
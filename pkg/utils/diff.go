@@ -0,0 +1,310 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"ponder/pkg/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// diffChunkLineCount is the number of lines sorted in memory at a time while
+// comparing ranked lists. Each line is held with its rank, so the value is
+// lower than the chunk size of SortByAproxFrequency.
+var diffChunkLineCount = 5000000
+
+// RankChange is a line whose position differs between two ranked lists. A
+// rank of 0 means the line is not in that list.
+type RankChange struct {
+	Line    string
+	OldRank int
+	NewRank int
+}
+
+// DiffRankedLists compares two lists ordered by rank, such as wizard
+// wordlists written by SortByAproxFrequency, where every line appears once.
+// Both lists are split into chunks sorted by line and joined with a k-way
+// merge, and the kept changes are sorted by rank with a second external sort,
+// so memory usage stays bounded regardless of the size of the lists.
+//
+// The changes are passed to fn ordered by their new rank, or by their old
+// rank for lines that were dropped.
+//
+// Args:
+// ctx (context.Context): The context used to cancel the comparison
+// oldPATH (string): The path to the earlier list
+// newPATH (string): The path to the later list
+// include (func(RankChange) bool): Returns true for the changes to keep
+// fn (func(RankChange) error): Called with every kept change
+//
+// Returns:
+// error: An error if one occurred
+func DiffRankedLists(ctx context.Context, oldPATH string, newPATH string, include func(change RankChange) bool, fn func(change RankChange) error) error {
+	tempDir, err := os.MkdirTemp(models.SourceDirectory, "temp_diff_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	oldChunks, err := rankedChunks(ctx, oldPATH, filepath.Join(tempDir, "old"))
+	if err != nil {
+		return err
+	}
+	newChunks, err := rankedChunks(ctx, newPATH, filepath.Join(tempDir, "new"))
+	if err != nil {
+		return err
+	}
+
+	runPaths, err := joinRankedChunks(ctx, oldChunks, newChunks, tempDir, include)
+	if err != nil {
+		return err
+	}
+
+	// Runs hold "key\toldRank\tnewRank\tline" records and are merged in key
+	// order
+	merger, err := newChunkMerger(runPaths, func(a, b freqPair) bool {
+		if a.count != b.count {
+			return a.count < b.count
+		}
+		return a.str < b.str
+	})
+	if err != nil {
+		return err
+	}
+	defer merger.Close()
+
+	for {
+		pair, ok, err := merger.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		change, err := parseRankChange(pair.str)
+		if err != nil {
+			return err
+		}
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+}
+
+// rankedChunks splits a ranked list into chunk files of "rank\tline" records
+// sorted by line.
+//
+// Args:
+// ctx (context.Context): The context used to cancel the comparison
+// path (string): The path to the ranked list
+// chunkDir (string): The directory to write the chunks to
+//
+// Returns:
+// []string: The paths of the chunk files
+// error: An error if one occurred
+func rankedChunks(ctx context.Context, path string, chunkDir string) ([]string, error) {
+	if err := os.MkdirAll(chunkDir, 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var chunkPaths []string
+	pairs := make([]freqPair, 0, 1024)
+	flush := func() error {
+		chunkPath, err := sortAndWriteChunk(pairs, chunkDir, len(chunkPaths))
+		if err != nil {
+			return err
+		}
+		chunkPaths = append(chunkPaths, chunkPath)
+		pairs = pairs[:0]
+		return nil
+	}
+
+	scanner := NewLineScanner(file)
+	for rank := 1; scanner.Scan(); rank++ {
+		if rank%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		pairs = append(pairs, freqPair{str: scanner.Text(), count: rank})
+		if len(pairs) >= diffChunkLineCount {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(pairs) > 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	return chunkPaths, nil
+}
+
+// joinRankedChunks walks the chunks of both lists in line order, pairs up the
+// ranks of every line, and writes the kept changes to run files sorted by
+// rank.
+//
+// Args:
+// ctx (context.Context): The context used to cancel the comparison
+// oldChunks ([]string): The chunk files of the earlier list
+// newChunks ([]string): The chunk files of the later list
+// tempDir (string): The temporary directory for storing files
+// include (func(RankChange) bool): Returns true for the changes to keep
+//
+// Returns:
+// []string: The paths of the run files
+// error: An error if one occurred
+func joinRankedChunks(ctx context.Context, oldChunks []string, newChunks []string, tempDir string, include func(change RankChange) bool) ([]string, error) {
+	byLine := func(a, b freqPair) bool {
+		return a.str < b.str
+	}
+	oldMerger, err := newChunkMerger(oldChunks, byLine)
+	if err != nil {
+		return nil, err
+	}
+	defer oldMerger.Close()
+	newMerger, err := newChunkMerger(newChunks, byLine)
+	if err != nil {
+		return nil, err
+	}
+	defer newMerger.Close()
+
+	var runPaths []string
+	pairs := make([]freqPair, 0, 1024)
+	flush := func() error {
+		sort.Slice(pairs, func(i, j int) bool {
+			if pairs[i].count != pairs[j].count {
+				return pairs[i].count < pairs[j].count
+			}
+			return pairs[i].str < pairs[j].str
+		})
+		runPath := fmt.Sprintf("%s/diff_%d.txt", tempDir, len(runPaths))
+		runFile, err := os.Create(runPath)
+		if err != nil {
+			return err
+		}
+		defer runFile.Close()
+		writer := bufio.NewWriter(runFile)
+		for _, pair := range pairs {
+			if err := writeCountLine(writer, pair.count, pair.str); err != nil {
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		runPaths = append(runPaths, runPath)
+		pairs = pairs[:0]
+		return nil
+	}
+
+	oldPair, oldOK, err := oldMerger.Next()
+	if err != nil {
+		return nil, err
+	}
+	newPair, newOK, err := newMerger.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	for lineCount := 0; oldOK || newOK; lineCount++ {
+		if lineCount%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		var change RankChange
+		advanceOld, advanceNew := false, false
+		switch {
+		case !newOK || (oldOK && oldPair.str < newPair.str):
+			change = RankChange{Line: oldPair.str, OldRank: oldPair.count}
+			advanceOld = true
+		case !oldOK || newPair.str < oldPair.str:
+			change = RankChange{Line: newPair.str, NewRank: newPair.count}
+			advanceNew = true
+		default:
+			change = RankChange{Line: newPair.str, OldRank: oldPair.count, NewRank: newPair.count}
+			advanceOld, advanceNew = true, true
+		}
+
+		if change.OldRank != change.NewRank && include(change) {
+			key := change.NewRank
+			if key == 0 {
+				key = change.OldRank
+			}
+			pairs = append(pairs, freqPair{str: formatRankChange(change), count: key})
+			if len(pairs) >= diffChunkLineCount {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if advanceOld {
+			if oldPair, oldOK, err = oldMerger.Next(); err != nil {
+				return nil, err
+			}
+		}
+		if advanceNew {
+			if newPair, newOK, err = newMerger.Next(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(pairs) > 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	return runPaths, nil
+}
+
+// formatRankChange encodes a change as an "oldRank\tnewRank\tline" record.
+//
+// Args:
+// change (RankChange): The change
+//
+// Returns:
+// string: The record
+func formatRankChange(change RankChange) string {
+	return strconv.Itoa(change.OldRank) + "\t" + strconv.Itoa(change.NewRank) + "\t" + change.Line
+}
+
+// parseRankChange decodes an "oldRank\tnewRank\tline" record.
+//
+// Args:
+// record (string): The record
+//
+// Returns:
+// RankChange: The change
+// error: An error if the record is malformed
+func parseRankChange(record string) (RankChange, error) {
+	oldText, rest, foundOld := strings.Cut(record, "\t")
+	newText, line, foundNew := strings.Cut(rest, "\t")
+	if !foundOld || !foundNew {
+		return RankChange{}, fmt.Errorf("malformed rank change: %q", record)
+	}
+	oldRank, err := strconv.Atoi(oldText)
+	if err != nil {
+		return RankChange{}, fmt.Errorf("malformed rank change: %q: %w", record, err)
+	}
+	newRank, err := strconv.Atoi(newText)
+	if err != nil {
+		return RankChange{}, fmt.Errorf("malformed rank change: %q: %w", record, err)
+	}
+	return RankChange{Line: line, OldRank: oldRank, NewRank: newRank}, nil
+}
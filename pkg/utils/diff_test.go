@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"ponder/pkg/models"
+	"strings"
+	"testing"
+)
+
+// writeRankedList writes lines to a file in rank order.
+func writeRankedList(t *testing.T, path string, lines []string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffRankedListsReportsNewDroppedAndMovedLines(t *testing.T) {
+	chunkLines, sourceDirectory := diffChunkLineCount, models.SourceDirectory
+	diffChunkLineCount, models.SourceDirectory = 13, t.TempDir()
+	t.Cleanup(func() {
+		diffChunkLineCount, models.SourceDirectory = chunkLines, sourceDirectory
+	})
+	dir := t.TempDir()
+	oldPATH := filepath.Join(dir, "old.txt")
+	newPATH := filepath.Join(dir, "new.txt")
+
+	// Every tenth line is dropped, every seventh line is new, and the rest
+	// is shuffled so most lines move while some keep their rank
+	random := rand.New(rand.NewSource(1))
+	var oldLines, newLines []string
+	for i := 0; i < 300; i++ {
+		line := fmt.Sprintf("line%03d", i)
+		oldLines = append(oldLines, line)
+		if i%10 != 0 {
+			newLines = append(newLines, line)
+		}
+		if i%7 == 0 {
+			newLines = append(newLines, fmt.Sprintf("new%03d", i))
+		}
+	}
+	random.Shuffle(len(newLines)/2, func(i, j int) {
+		newLines[i], newLines[j] = newLines[j], newLines[i]
+	})
+	writeRankedList(t, oldPATH, oldLines)
+	writeRankedList(t, newPATH, newLines)
+
+	rankOf := func(lines []string) map[string]int {
+		ranks := make(map[string]int)
+		for i, line := range lines {
+			ranks[line] = i + 1
+		}
+		return ranks
+	}
+	oldRanks, newRanks := rankOf(oldLines), rankOf(newLines)
+	include := func(change RankChange) bool {
+		return !strings.HasSuffix(change.Line, "5")
+	}
+
+	expected := make(map[string]RankChange)
+	for _, lines := range [][]string{oldLines, newLines} {
+		for _, line := range lines {
+			change := RankChange{Line: line, OldRank: oldRanks[line], NewRank: newRanks[line]}
+			if change.OldRank != change.NewRank && include(change) {
+				expected[line] = change
+			}
+		}
+	}
+
+	got := make(map[string]RankChange)
+	previousKey := 0
+	err := DiffRankedLists(context.Background(), oldPATH, newPATH, include, func(change RankChange) error {
+		key := change.NewRank
+		if key == 0 {
+			key = change.OldRank
+		}
+		if key < previousKey {
+			t.Errorf("change %+v with rank %d follows rank %d", change, key, previousKey)
+		}
+		previousKey = key
+		if _, seen := got[change.Line]; seen {
+			t.Errorf("line %q is reported more than once", change.Line)
+		}
+		got[change.Line] = change
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DiffRankedLists: %v", err)
+	}
+
+	if len(got) != len(expected) {
+		t.Errorf("got %d changes, want %d", len(got), len(expected))
+	}
+	for line, want := range expected {
+		if got[line] != want {
+			t.Errorf("line %q: got %+v, want %+v", line, got[line], want)
+		}
+	}
+	for _, line := range []string{"line000", "new007", "line299"} {
+		if _, ok := got[line]; !ok {
+			t.Errorf("expected a change for %q", line)
+		}
+	}
+}
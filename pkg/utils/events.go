@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"os"
+	"ponder/pkg/models"
+	"sync"
+	"time"
+)
+
+// maxRecentLogEntries is the number of log entries kept in memory so event
+// log streams can resume after a reconnect
+const maxRecentLogEntries = 1000
+
+// subscriberBuffer is the number of log entries queued for a stream before
+// it is considered too slow and disconnected
+const subscriberBuffer = 256
+
+// logHubMu guards the recent log entries and the stream subscribers
+var logHubMu sync.Mutex
+
+// recentLogEntries holds the latest log entries, oldest first
+var recentLogEntries []models.LogEntry
+
+// lastLogEntryID is the ID of the latest log entry
+var lastLogEntryID int64
+
+// logSubscribers holds the channel of every open event log stream
+var logSubscribers = map[chan models.LogEntry]struct{}{}
+
+// ErrLogCursorTooOld is returned when entries written after a cursor were
+// rotated out of the kept log files, so a stream cannot resume from it and
+// the client has to load the log in full
+var ErrLogCursorTooOld = errors.New("log cursor too old")
+
+// SubscribeLogEntries returns the log entries written after a cursor and a
+// channel receiving every entry written from now on. Entries that are no
+// longer kept in memory, such as those written before a restart, are read
+// from the log files. The channel may repeat an entry of the backlog, so
+// entries with an ID up to the last one received should be skipped. The
+// channel is closed when the subscriber falls too far behind, after which it
+// should subscribe again with the ID of the last entry it received.
+//
+// Args:
+// since (int64): The ID of the last entry already seen or 0 for every recent
+// entry
+//
+// Returns:
+// []models.LogEntry: The entries after the cursor, oldest first
+// <-chan models.LogEntry: The new entries
+// func(): Stops the subscription
+// error: ErrLogCursorTooOld if entries after the cursor are no longer kept,
+// along with a working subscription, or an error reading the log files
+func SubscribeLogEntries(since int64) ([]models.LogEntry, <-chan models.LogEntry, func(), error) {
+	logHubMu.Lock()
+	var backlog []models.LogEntry
+	for _, entry := range recentLogEntries {
+		if entry.ID > since {
+			backlog = append(backlog, entry)
+		}
+	}
+	// Entries before the oldest one in memory may be missing from it
+	complete := since == 0 || (len(recentLogEntries) > 0 && recentLogEntries[0].ID <= since)
+	boundary := int64(math.MaxInt64)
+	if len(recentLogEntries) > 0 {
+		boundary = recentLogEntries[0].ID
+	}
+
+	entries := make(chan models.LogEntry, subscriberBuffer)
+	logSubscribers[entries] = struct{}{}
+	logHubMu.Unlock()
+
+	unsubscribe := func() {
+		logHubMu.Lock()
+		defer logHubMu.Unlock()
+		if _, ok := logSubscribers[entries]; ok {
+			delete(logSubscribers, entries)
+			close(entries)
+		}
+	}
+	if complete {
+		return backlog, entries, unsubscribe, nil
+	}
+
+	written, err := readLogEntriesBetween(since, boundary)
+	if errors.Is(err, ErrLogCursorTooOld) {
+		return backlog, entries, unsubscribe, err
+	}
+	if err != nil {
+		unsubscribe()
+		return nil, nil, nil, err
+	}
+	return append(written, backlog...), entries, unsubscribe, nil
+}
+
+// readLogEntriesBetween reads the entries with an ID between two cursors from
+// the kept log files. The log files are not written or rotated meanwhile.
+//
+// Args:
+// since (int64): The ID of the last entry already seen
+// before (int64): The ID the entries are older than
+//
+// Returns:
+// []models.LogEntry: The entries, oldest first
+// error: ErrLogCursorTooOld if entries after the cursor were rotated out of
+// the kept log files, or an error if one occurred
+func readLogEntriesBetween(since int64, before int64) ([]models.LogEntry, error) {
+	logFileMu.Lock()
+	defer logFileMu.Unlock()
+
+	var entries []models.LogEntry
+	// The cursor is covered once an entry up to it is kept, or if no log
+	// file was ever dropped
+	covered := false
+	for i := maxRotatedLogFiles; i >= 0; i-- {
+		path := models.LogFile
+		if i > 0 {
+			path = rotatedLogFile(i)
+		}
+		err := readLogFile(path, func(entry models.LogEntry) {
+			switch {
+			case entry.ID == 0:
+			case entry.ID <= since:
+				covered = true
+			case entry.ID < before:
+				entries = append(entries, entry)
+			}
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			if i == maxRotatedLogFiles {
+				covered = true
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !covered {
+		return nil, ErrLogCursorTooOld
+	}
+	return entries, nil
+}
+
+// LastLogEntryID returns the ID of the latest log entry, which can be used as
+// the cursor of an event log stream.
+//
+// Args:
+// None
+//
+// Returns:
+// int64: The ID or 0 if nothing has been logged yet
+func LastLogEntryID() int64 {
+	logHubMu.Lock()
+	defer logHubMu.Unlock()
+
+	return lastLogEntryID
+}
+
+// publishLogEntry assigns the next ID to a log entry, keeps it with the
+// recent entries, and sends it to every stream. IDs are based on the clock so
+// cursors stay meaningful across restarts.
+//
+// Args:
+// entry (models.LogEntry): The entry to publish
+//
+// Returns:
+// models.LogEntry: The entry with its ID
+func publishLogEntry(entry models.LogEntry) models.LogEntry {
+	logHubMu.Lock()
+	defer logHubMu.Unlock()
+
+	entry.ID = time.Now().UnixNano()
+	if entry.ID <= lastLogEntryID {
+		entry.ID = lastLogEntryID + 1
+	}
+	lastLogEntryID = entry.ID
+
+	recentLogEntries = append(recentLogEntries, entry)
+	if len(recentLogEntries) > maxRecentLogEntries {
+		recentLogEntries = recentLogEntries[len(recentLogEntries)-maxRecentLogEntries:]
+	}
+
+	for subscriber := range logSubscribers {
+		select {
+		case subscriber <- entry:
+		default:
+			// A slow stream is dropped instead of blocking the logger, the
+			// client resumes from its last entry
+			delete(logSubscribers, subscriber)
+			close(subscriber)
+		}
+	}
+
+	return entry
+}
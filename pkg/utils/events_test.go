@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"ponder/pkg/models"
+	"testing"
+)
+
+// testLog points the log file to a new directory and empties the log entries
+// kept in memory, as after a restart.
+func testLog(t *testing.T) {
+	t.Helper()
+
+	logFile := models.LogFile
+	models.LogFile = filepath.Join(t.TempDir(), "log.txt")
+	restart(t)
+	t.Cleanup(func() {
+		models.LogFile = logFile
+		restart(t)
+	})
+}
+
+// restart forgets the log entries kept in memory.
+func restart(t *testing.T) {
+	t.Helper()
+
+	logHubMu.Lock()
+	defer logHubMu.Unlock()
+	recentLogEntries = nil
+}
+
+// subscribe subscribes to the log entries after a cursor and returns their
+// events.
+func subscribe(t *testing.T, since int64) ([]string, error) {
+	t.Helper()
+
+	backlog, _, unsubscribe, err := SubscribeLogEntries(since)
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+	var events []string
+	for _, entry := range backlog {
+		events = append(events, entry.Event)
+	}
+	return events, err
+}
+
+func TestSubscribeLogEntriesResumesAfterRestart(t *testing.T) {
+	testLog(t)
+	LogInternalEvent("first", "")
+	cursor := LastLogEntryID()
+	LogInternalEvent("second", "")
+	LogInternalEvent("third", "")
+
+	restart(t)
+	LogInternalEvent("fourth", "")
+
+	events, err := subscribe(t, cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(events) != "[second third fourth]" {
+		t.Errorf("got events %v after a restart", events)
+	}
+}
+
+func TestSubscribeLogEntriesResumesBeforeTheEntriesInMemory(t *testing.T) {
+	testLog(t)
+	LogInternalEvent("first", "")
+	cursor := LastLogEntryID()
+	for i := 0; i < maxRecentLogEntries+5; i++ {
+		LogInternalEvent(fmt.Sprintf("event%d", i), "")
+	}
+
+	events, err := subscribe(t, cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != maxRecentLogEntries+5 || events[0] != "event0" || events[len(events)-1] != fmt.Sprintf("event%d", maxRecentLogEntries+4) {
+		t.Errorf("got %d events from %v to %v", len(events), events[0], events[len(events)-1])
+	}
+}
+
+func TestSubscribeLogEntriesReportsRotatedCursor(t *testing.T) {
+	testLog(t)
+	LogInternalEvent("first", "")
+	cursor := LastLogEntryID()
+	LogInternalEvent("second", "")
+	// The log file with the cursor is rotated out
+	for i := 0; i <= maxRotatedLogFiles; i++ {
+		if i > 0 {
+			LogInternalEvent(fmt.Sprintf("rotated%d", i), "")
+		}
+		logFileMu.Lock()
+		err := rotateLogFiles()
+		logFileMu.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	restart(t)
+	LogInternalEvent("third", "")
+
+	if _, err := subscribe(t, cursor); !errors.Is(err, ErrLogCursorTooOld) {
+		t.Errorf("got error %v, want ErrLogCursorTooOld", err)
+	}
+	if events, err := subscribe(t, LastLogEntryID()); err != nil || len(events) != 0 {
+		t.Errorf("got events %v and error %v for the latest cursor", events, err)
+	}
+}
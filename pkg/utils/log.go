@@ -0,0 +1,291 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"ponder/pkg/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLogSize is the size at which the log file is rotated
+const maxLogSize int64 = 5 * 1024 * 1024
+
+// maxRotatedLogFiles is the number of rotated log files kept next to the log
+// file as log.txt.1 (newest) to log.txt.5 (oldest)
+const maxRotatedLogFiles = 5
+
+// logLevelSeverity orders the log levels so entries can be filtered by a
+// minimum level
+var logLevelSeverity = map[string]int{
+	models.LogLevelDebug: 0,
+	models.LogLevelInfo:  1,
+	models.LogLevelWarn:  2,
+	models.LogLevelError: 3,
+}
+
+// logFileMu serializes writes to and rotation of the log file
+var logFileMu sync.Mutex
+
+// LogFilter selects the log entries returned by ReadLogEntries. Zero values
+// match every entry.
+type LogFilter struct {
+	// Level is the minimum level of the entries
+	Level string
+	// Event matches entries whose event contains it, ignoring case
+	Event string
+	// Start and End bound the time of the entries, inclusive
+	Start time.Time
+	End   time.Time
+	// Limit keeps only the latest matching entries
+	Limit int
+}
+
+// ValidLogLevel checks if a string is a known log level.
+//
+// Args:
+// level (string): The level to check
+//
+// Returns:
+// bool: True if the level is known
+func ValidLogLevel(level string) bool {
+	_, ok := logLevelSeverity[level]
+	return ok
+}
+
+// LogEvent writes a structured entry to the log file and sends it to the open
+// event log streams.
+//
+// Args:
+// level (string): The level of the entry
+// event (string): The event type
+// message (string): The event message
+// fields (models.LogFields): Structured values to attach or nil for none
+//
+// Returns:
+// error: An error if one occurred
+func LogEvent(level string, event string, message string, fields models.LogFields) error {
+	entry := models.LogEntry{
+		Time:    time.Now().Format(time.RFC3339),
+		Level:   level,
+		Event:   event,
+		Message: message,
+		Fields:  fields,
+	}
+	return WriteLogEntry(publishLogEntry(entry))
+}
+
+// LogInternalEvent logs an internal event at the info level
+//
+// Args:
+// event (string): The event type
+// message (string): The event message
+//
+// Returns:
+// error: An error if one occurred
+func LogInternalEvent(event, message string) error {
+	return LogEvent(models.LogLevelInfo, event, message, nil)
+}
+
+// LogInternalError logs an internal event at the error level
+//
+// Args:
+// event (string): The event type
+// message (string): The event message
+//
+// Returns:
+// error: An error if one occurred
+func LogInternalError(event, message string) error {
+	return LogEvent(models.LogLevelError, event, message, nil)
+}
+
+// WriteLogEntry appends a log entry to the log file as a JSON line. The log
+// file is rotated to numbered files once it grows past 5MB.
+//
+// Args:
+// entry (models.LogEntry): The log entry to write
+//
+// Returns:
+// error: An error if one occurred
+func WriteLogEntry(entry models.LogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	logFileMu.Lock()
+	defer logFileMu.Unlock()
+
+	stat, err := os.Stat(models.LogFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && stat.Size()+int64(len(line)) > maxLogSize {
+		if err := rotateLogFiles(); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(models.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// rotateLogFiles shifts every rotated log file up by one number, dropping the
+// oldest, and moves the log file to log.txt.1. logFileMu must be held.
+//
+// Args:
+// None
+//
+// Returns:
+// error: An error if one occurred
+func rotateLogFiles() error {
+	if err := os.Remove(rotatedLogFile(maxRotatedLogFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := maxRotatedLogFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotatedLogFile(i), rotatedLogFile(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(models.LogFile, rotatedLogFile(1))
+}
+
+// rotatedLogFile returns the path to a rotated log file.
+//
+// Args:
+// number (int): The number of the file, 1 being the newest
+//
+// Returns:
+// string: The path
+func rotatedLogFile(number int) string {
+	return fmt.Sprintf("%s.%d", models.LogFile, number)
+}
+
+// ReadLogEntries reads the entries matching a filter from the rotated log
+// files and the log file, oldest first
+//
+// Args:
+// filter (LogFilter): Selects the entries to return
+//
+// Returns:
+// ([]models.LogEntry, error): A slice of log entries and an error if one occurred
+func ReadLogEntries(filter LogFilter) ([]models.LogEntry, error) {
+	paths := make([]string, 0, maxRotatedLogFiles+1)
+	for i := maxRotatedLogFiles; i >= 1; i-- {
+		paths = append(paths, rotatedLogFile(i))
+	}
+	paths = append(paths, models.LogFile)
+
+	var entries []models.LogEntry
+	for _, path := range paths {
+		err := readLogFile(path, func(entry models.LogEntry) {
+			if !filter.matches(entry) {
+				return
+			}
+			entries = append(entries, entry)
+			// Trim in batches so the slice does not grow with the whole log
+			if filter.Limit > 0 && len(entries) >= 2*filter.Limit {
+				entries = append(entries[:0], entries[len(entries)-filter.Limit:]...)
+			}
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+	return entries, nil
+}
+
+// readLogFile parses every entry of a log file. Lines written before the log
+// was structured are read as info entries.
+//
+// Args:
+// path (string): The path to the log file
+// fn (func(models.LogEntry)): Called with every entry
+//
+// Returns:
+// error: An error if one occurred
+func readLogFile(path string, fn func(entry models.LogEntry)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "{") {
+			var entry models.LogEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				continue
+			}
+			fn(entry)
+			continue
+		}
+
+		parts := strings.SplitN(line, " - ", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		timeAndEvent := strings.SplitN(parts[1], ": ", 2)
+		if len(timeAndEvent) < 2 {
+			continue
+		}
+		fn(models.LogEntry{
+			Time:    parts[0],
+			Level:   models.LogLevelInfo,
+			Event:   timeAndEvent[0],
+			Message: timeAndEvent[1],
+		})
+	}
+	return scanner.Err()
+}
+
+// matches checks if a log entry is selected by the filter.
+//
+// Args:
+// entry (models.LogEntry): The entry
+//
+// Returns:
+// bool: True if the entry matches
+func (f LogFilter) matches(entry models.LogEntry) bool {
+	if f.Level != "" && logLevelSeverity[entry.Level] < logLevelSeverity[f.Level] {
+		return false
+	}
+	if f.Event != "" && !strings.Contains(strings.ToLower(entry.Event), strings.ToLower(f.Event)) {
+		return false
+	}
+	if !f.Start.IsZero() || !f.End.IsZero() {
+		entryTime, err := time.Parse(time.RFC3339, entry.Time)
+		if err != nil {
+			return false
+		}
+		if !f.Start.IsZero() && entryTime.Before(f.Start) {
+			return false
+		}
+		if !f.End.IsZero() && entryTime.After(f.End) {
+			return false
+		}
+	}
+	return true
+}
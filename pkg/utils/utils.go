@@ -4,14 +4,19 @@ package utils
 
 import (
 	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"ponder/pkg/metrics"
 	"ponder/pkg/models"
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
-	"time"
 	"unicode"
 )
 
@@ -27,7 +32,7 @@ func MakeFileIfNotExist(path string) {
 	if os.IsNotExist(err) {
 		file, err := os.Create(path)
 		if err != nil {
-			LogInternalEvent("Error creating file in MakeFileIfNotExist", err.Error())
+			LogInternalError("Error creating file in MakeFileIfNotExist", err.Error())
 			return
 		}
 		defer file.Close()
@@ -52,7 +57,7 @@ func GetFirstNLines(path string, n int, substring ...string) ([]string, error) {
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	scanner := NewLineScanner(file)
 	lines := make([]string, 0, n)
 	var substrLower string
 
@@ -77,140 +82,193 @@ func GetFirstNLines(path string, n int, substring ...string) ([]string, error) {
 	return lines, nil
 }
 
-// WriteLogEntry writes a log entry to the log file and enforces a maximum log size of 5MB
+// GetFirstNCountedLines reads the first n records from a counts file written
+// by SortByAproxFrequency and stops at the first record below minCount. Only
+// records whose line contains the specified substring (case-insensitive) are
+// included.
 //
 // Args:
-// entry (models.LogEntry): The log entry to write
+// path (string): The path to the counts file
+// n (int): The number of lines to read
+// minCount (int): The minimum number of occurrences a line needs
+// includeCounts (bool): True to return "count\tline" records instead of lines
+// substring (string): The optional case-insensitive substring to search for
 //
 // Returns:
+// []string: A slice of strings containing the matching lines or records
 // error: An error if one occurred
-func WriteLogEntry(entry models.LogEntry) error {
-	// Maximum log file size: 5MB
-	const maxSize int64 = 5 * 1024 * 1024
-
-	file, err := os.OpenFile(models.LogFile, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+func GetFirstNCountedLines(path string, n int, minCount int, includeCounts bool, substring ...string) ([]string, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer file.Close()
 
-	// Check the file size
-	stat, err := file.Stat()
-	if err != nil {
-		return err
+	scanner := NewLineScanner(file)
+	lines := make([]string, 0, n)
+	var substrLower string
+
+	if len(substring) > 0 {
+		substrLower = strings.ToLower(substring[0])
 	}
 
-	// If the file size exceeds the maximum size, remove the oldest entry
-	if stat.Size() > maxSize {
-		if err := truncateLogFile(file); err != nil {
-			return err
+	for scanner.Scan() && len(lines) < n {
+		pair, err := parseCountLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		// The counts file is in descending order so nothing after this
+		// record can meet the minimum
+		if pair.count < minCount {
+			break
+		}
+		if substrLower != "" && !strings.Contains(strings.ToLower(pair.str), substrLower) {
+			continue
+		}
+		if includeCounts {
+			lines = append(lines, scanner.Text())
+		} else {
+			lines = append(lines, pair.str)
 		}
 	}
 
-	writer := bufio.NewWriter(file)
-	logLine := fmt.Sprintf("%s - %s: %s\n", entry.Time, entry.Event, entry.Message)
-	if _, err := writer.WriteString(logLine); err != nil {
-		return err
-	}
-	if err := writer.Flush(); err != nil {
-		return err
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return lines, nil
 }
 
-// truncateLogFile removes the oldest log entry from the log file
+// MaxLineLength is the length in bytes of the longest line ReadLineChunks
+// passes on. Longer lines, such as binary data without newlines, are dropped
+// so they are never accumulated in memory.
+const MaxLineLength = 64 * 1024
+
+// MaxRecordLength is the length in bytes of the longest line NewLineScanner
+// reads, which leaves room for the count and tab in front of a line of
+// MaxLineLength in a counts file
+const MaxRecordLength = MaxLineLength + 64
+
+// NewLineScanner returns a scanner for the lines of a reader that accepts
+// every line of up to MaxRecordLength bytes, so every line ReadLineChunks
+// passed on can be read again. A longer line stops the scanner with
+// bufio.ErrTooLong, so callers must check Err.
 //
 // Args:
-// file (*os.File): The log file
+// r (io.Reader): The reader to scan
 //
 // Returns:
-// error: An error if one occurred
-func truncateLogFile(file *os.File) error {
-	// Read all log entries
-	entries, err := ReadLogEntries()
-	if err != nil {
-		return err
-	}
-
-	// Remove the oldest entry
-	if len(entries) > 0 {
-		entries = entries[1:]
-	}
-
-	// Truncate the file and write the remaining entries
-	if err := file.Truncate(0); err != nil {
-		return err
-	}
-	if _, err := file.Seek(0, 0); err != nil {
-		return err
-	}
-
-	writer := bufio.NewWriter(file)
-	for _, entry := range entries {
-		logLine := fmt.Sprintf("%s - %s: %s\n", entry.Time, entry.Event, entry.Message)
-		if _, err := writer.WriteString(logLine); err != nil {
-			return err
-		}
-	}
-	if err := writer.Flush(); err != nil {
-		return err
-	}
-
-	return nil
+// *bufio.Scanner: The scanner
+func NewLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	// The buffer has to hold the newline as well
+	scanner.Buffer(nil, MaxRecordLength+1)
+	return scanner
 }
 
-// ReadLogEntries reads all log entries from the log file
+// ReadLineChunks reads from the reader in buffers of bufferSize bytes and calls
+// fn with blocks that only contain complete lines. Any partial line at the end
+// of a buffer is carried over and prepended to the next read so lines that
+// straddle a buffer boundary are never split. The final line is passed to fn
+// even when it has no trailing newline. Lines longer than MaxLineLength are
+// dropped, and the carried over partial line never grows beyond it, so the
+// blocks are the same for every buffer size.
+//
+// Args:
+// r (io.Reader): The reader to read from
+// bufferSize (int): The number of bytes to read at a time
+// fn (func([]byte) error): Called with each block of complete lines
 //
 // Returns:
-// ([]models.LogEntry, error): A slice of log entries and an error if one occurred
-func ReadLogEntries() ([]models.LogEntry, error) {
-	file, err := os.Open(models.LogFile)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	var entries []models.LogEntry
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.SplitN(line, " - ", 2)
-		if len(parts) < 2 {
-			continue
+// error: An error if one occurred while reading or returned by fn
+func ReadLineChunks(r io.Reader, bufferSize int, fn func(chunk []byte) error) error {
+	buffer := make([]byte, bufferSize)
+	var pending []byte
+	// discarding is set while the rest of an over-long line is skipped
+	discarding := false
+
+	for {
+		n, err := r.Read(buffer)
+		data := buffer[:n]
+		if discarding && len(data) > 0 {
+			newline := bytes.IndexByte(data, '\n')
+			if newline < 0 {
+				data = nil
+			} else {
+				data = data[newline+1:]
+				discarding = false
+			}
 		}
-		timeAndEvent := strings.SplitN(parts[1], ": ", 2)
-		if len(timeAndEvent) < 2 {
-			continue
+		if len(data) > 0 {
+			// The carried over partial line has no newline, so only the new
+			// data is searched
+			lastNewline := bytes.LastIndexByte(data, '\n')
+			if lastNewline >= 0 {
+				lastNewline += len(pending)
+			}
+			data = append(pending, data...)
+			if lastNewline >= 0 {
+				if fnErr := fn(dropLongLines(data[:lastNewline+1])); fnErr != nil {
+					return fnErr
+				}
+				// Copy the remainder so the next append does not overwrite
+				// the block that was handed to fn
+				pending = append([]byte(nil), data[lastNewline+1:]...)
+			} else {
+				pending = data
+			}
+			if len(pending) > MaxLineLength {
+				pending = nil
+				discarding = true
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
 		}
-		entries = append(entries, models.LogEntry{
-			Time:    parts[0],
-			Event:   timeAndEvent[0],
-			Message: timeAndEvent[1],
-		})
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+
+	if len(pending) > 0 {
+		return fn(pending)
 	}
 
-	return entries, nil
+	return nil
 }
 
-// LogInternalEvent logs an internal event to the log file
+// dropLongLines removes the lines longer than MaxLineLength from a block of
+// complete lines. The block is only copied when it contains such a line.
 //
 // Args:
-// event (string): The event type
-// message (string): The event message
+// block ([]byte): Lines that each end with a newline
 //
 // Returns:
-// error: An error if one occurred
-func LogInternalEvent(event, message string) error {
-	entry := models.LogEntry{
-		Time:    time.Now().Format(time.RFC3339),
-		Event:   event,
-		Message: message,
+// []byte: The block without over-long lines
+func dropLongLines(block []byte) []byte {
+	if len(block) <= MaxLineLength {
+		return block
+	}
+
+	var kept []byte
+	dropped := false
+	for start := 0; start < len(block); {
+		end := start + bytes.IndexByte(block[start:], '\n')
+		if end-start > MaxLineLength {
+			if !dropped {
+				kept = append([]byte(nil), block[:start]...)
+				dropped = true
+			}
+		} else if dropped {
+			kept = append(kept, block[start:end+1]...)
+		}
+		start = end + 1
+	}
+
+	if !dropped {
+		return block
 	}
-	return WriteLogEntry(entry)
+	return kept
 }
 
 // IsAllDigitsOrSpecialChars checks if a string contains only digits or special characters.
@@ -247,36 +305,132 @@ func ContainsOnlyASCII(s string) bool {
 	return true
 }
 
+// cancelCheckInterval is the number of lines the external sort processes
+// between checks for cancellation and progress updates
+const cancelCheckInterval = 65536
+
+// sortChunkLineCount is the number of lines to read at a time. The higher the
+// number, the larger files are written to the disk. The lower the number, the
+// more files are written to the disk. Adjust as needed. Because the file is
+// read through a scanner, the memory usage is minimal. So this value is just
+// to control the size of the files written to the disk and ensure inodes are
+// not exhausted.
+//
+// Recommended: 25,000,000
+var sortChunkLineCount = 25000000
+
+// sortRunPairCount is the number of unique lines held in memory before they
+// are sorted by count and written to a run file. Unlike the old map based
+// flush this does not introduce duplicates because every line has already
+// been fully counted by the time it reaches the buffer. The higher the
+// threshold, the fewer run files have to be merged, however, base memory
+// usage will also rise.
+//
+// Adjust the threshold as needed
+// Highest Approved: 250,000,000
+// 8GB Recommended: 50,000,000
+var sortRunPairCount = 50000000
+
 // SortByAproxFrequency sorts the content of the target file by the frequency
 // of occurrence using external sorting.
 //
 // The function reads the file in chunks, sorts the chunks, and writes them to
-// temporary files. The sorted chunks are then merged into the target file.
-// This approach is more memory-efficient than reading the entire file into
-// memory but may result in duplicates due to the chunking.
+// temporary files with identical lines collapsed into counts. The sorted
+// chunks are then combined with a k-way merge that counts runs of identical
+// lines across every chunk, so each candidate is counted exactly once. The
+// counted candidates are sorted by count with a second external sort and
+// written to the target file without duplicates and in exact frequency order.
+//
+// When countsPATH is set, a companion file of "count\tline" records is
+// written in the same order as the target file. Any previousCounts files are
+// merged into the result so the counts of an earlier generation carry over.
+//
+// The sort stops when the context is cancelled, except during the final merge.
+// The target and counts files are written to temporary files and renamed into
+// place once complete, so they are never left partially written by a
+// cancellation or crash. Progress is reported to the
+// models.GenerationProgress carried by the context.
 //
 // Args:
+// ctx (context.Context): The context used to cancel the sort
 // targetPATH (string): The path to the file
+// countsPATH (string): The path to the companion counts file or empty to skip
+// previousCounts (...string): Optional counts files to merge into the result
+//
+// Returns:
+// error: An error if one occurred
+func SortByAproxFrequency(ctx context.Context, targetPATH string, countsPATH string, previousCounts ...string) error {
+	return sortByFrequency(ctx, []string{targetPATH}, previousCounts, targetPATH, countsPATH)
+}
+
+// MergeCountsByFrequency merges files of "count\tline" records into a single
+// list without duplicates and in exact frequency order using the same
+// external sort as SortByAproxFrequency.
+//
+// Args:
+// ctx (context.Context): The context used to cancel the sort
+// targetPATH (string): The path to the output list
+// countsPATH (string): The path to the companion counts file or empty to skip
+// countsPATHs (...string): The counts files to merge
+//
+// Returns:
+// error: An error if one occurred
+func MergeCountsByFrequency(ctx context.Context, targetPATH string, countsPATH string, countsPATHs ...string) error {
+	return sortByFrequency(ctx, nil, countsPATHs, targetPATH, countsPATH)
+}
+
+// sortByFrequency counts every line of the plain files and every record of
+// the counted files and writes them to the target in frequency order. Used in
+// SortByAproxFrequency and MergeCountsByFrequency.
+//
+// Args:
+// ctx (context.Context): The context used to cancel the sort
+// plainPATHs ([]string): Files holding one line per occurrence
+// countedPATHs ([]string): Files holding "count\tline" records
+// targetPATH (string): The path to the output list
+// countsPATH (string): The path to the companion counts file or empty to skip
 //
 // Returns:
 // error: An error if one occurred
-func SortByAproxFrequency(targetPATH string) error {
-	tempDir := "/data/temp_chunks"
-	err := os.MkdirAll(tempDir, 0755)
+func sortByFrequency(ctx context.Context, plainPATHs []string, countedPATHs []string, targetPATH string, countsPATH string) error {
+	progress := models.ProgressFromContext(ctx)
+	progress.SetPhase(models.PhaseSorting)
+
+	tempDir, err := os.MkdirTemp(models.SourceDirectory, "temp_chunks_")
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(tempDir)
 
-	LogInternalEvent("Processing file chunks", fmt.Sprintf("Processing file chunks for %s", targetPATH))
-	err = processFileChunksToTempFiles(targetPATH, tempDir)
+	var chunkPaths []string
+	for _, plainPATH := range plainPATHs {
+		LogInternalEvent("Processing file chunks", fmt.Sprintf("Processing file chunks for %s", plainPATH))
+		chunkPaths, err = processFileChunksToTempFiles(ctx, plainPATH, false, tempDir, chunkPaths)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, countedPATH := range countedPATHs {
+		LogInternalEvent("Processing previous counts", fmt.Sprintf("Merging counts from %s", countedPATH))
+		chunkPaths, err = processFileChunksToTempFiles(ctx, countedPATH, true, tempDir, chunkPaths)
+		if err != nil {
+			return err
+		}
+	}
+
+	LogInternalEvent("Counting sorted chunks", fmt.Sprintf("Counting %d sorted chunks for %s", len(chunkPaths), targetPATH))
+	runtime.GC()
+	progress.SetPhase(models.PhaseCounting)
+	countPaths, err := countSortedChunks(ctx, chunkPaths, tempDir)
 	if err != nil {
 		return err
 	}
 
-	LogInternalEvent("Merging sorted chunks", fmt.Sprintf("Merging sorted chunks for %s", targetPATH))
+	LogInternalEvent("Merging sorted chunks", fmt.Sprintf("Merging %d frequency runs for %s", len(countPaths), targetPATH))
 	runtime.GC()
-	err = mergeSortedChunks(tempDir, targetPATH)
+	progress.SetPhase(models.PhaseMerging)
+	err = mergeSortedChunks(countPaths, targetPATH, countsPATH, progress)
 	if err != nil {
 		return err
 	}
@@ -288,282 +442,578 @@ func SortByAproxFrequency(targetPATH string) error {
 // them to temporary files. Used in SortByAproxFrequency.
 //
 // Args:
+// ctx (context.Context): The context used to cancel the sort
 // path (string): The path to the file
+// counted (bool): True if the file holds "count\tline" records instead of lines
 // tempDir (string): The temporary directory for storing files
+// chunkPaths ([]string): Previously written chunk paths to append to
 //
 // Returns:
+// []string: The paths of the sorted chunk files in the order they were written
 // error: An error if one occurred
-func processFileChunksToTempFiles(path, tempDir string) error {
+func processFileChunksToTempFiles(ctx context.Context, path string, counted bool, tempDir string, chunkPaths []string) ([]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer file.Close()
 
-	// This is the number of lines to read at a time. The higher the number,
-	// the larger files are written to the disk. The lower the number, the more
-	// files are written to the disk. Adjust as needed. Because the file is
-	// read through a scanner, the memory usage is minimal. So this value is
-	// just to control the size of the files written to the disk and ensure
-	// inodes are not exhausted.
-	//
-	// Recommended: 25,000,000
-	//
-	chunkLineCount := 25000000
-	scanner := bufio.NewScanner(file)
-	chunkCounter := 0
-	lines := make([]string, 0, chunkLineCount)
+	scanner := NewLineScanner(file)
+	pairs := make([]freqPair, 0, 1024)
+	progress := models.ProgressFromContext(ctx)
 
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
-		if len(lines) >= chunkLineCount {
-			err := sortAndWriteChunk(lines, tempDir, chunkCounter)
+	for lineCount := 0; scanner.Scan(); lineCount++ {
+		if lineCount%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		pair := freqPair{str: line, count: 1}
+		if counted {
+			pair, err = parseCountLine(line)
 			if err != nil {
-				return err
+				return nil, err
+			}
+		}
+		pairs = append(pairs, pair)
+		if len(pairs) >= sortChunkLineCount {
+			chunkPath, err := sortAndWriteChunk(pairs, tempDir, len(chunkPaths))
+			if err != nil {
+				return nil, err
 			}
-			chunkCounter++
-			lines = lines[:0]
+			chunkPaths = append(chunkPaths, chunkPath)
+			progress.AddChunk()
+			metrics.SortChunks.Inc()
+			pairs = pairs[:0]
 			runtime.GC()
 		}
 	}
 
-	if len(lines) > 0 {
-		err := sortAndWriteChunk(lines, tempDir, chunkCounter)
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(pairs) > 0 {
+		chunkPath, err := sortAndWriteChunk(pairs, tempDir, len(chunkPaths))
 		if err != nil {
-			return err
+			return nil, err
 		}
+		chunkPaths = append(chunkPaths, chunkPath)
+		progress.AddChunk()
+		metrics.SortChunks.Inc()
 	}
 
-	if err := scanner.Err(); err != nil {
-		return err
+	return chunkPaths, nil
+}
+
+// sortAndWriteChunk sorts a chunk of counted lines and writes it to a
+// temporary file. Identical lines are collapsed into a single "count\tline"
+// record so the chunk files stay small. Used in SortByAproxFrequency.
+//
+// Args:
+// pairs ([]freqPair): The counted lines to sort and write
+// tempDir (string): The temporary directory for storing files
+// chunkCounter (int): The number of the chunk used to name the file
+//
+// Returns:
+// string: The path of the written chunk file
+// error: An error if one occurred
+func sortAndWriteChunk(pairs []freqPair, tempDir string, chunkCounter int) (string, error) {
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].str < pairs[j].str
+	})
+	tempFilePath := fmt.Sprintf("%s/chunk_%d.txt", tempDir, chunkCounter)
+	tempFile, err := os.Create(tempFilePath)
+	if err != nil {
+		return "", err
 	}
+	defer tempFile.Close()
 
-	return nil
+	writer := bufio.NewWriter(tempFile)
+	for i := 0; i < len(pairs); {
+		count := pairs[i].count
+		j := i + 1
+		for j < len(pairs) && pairs[j].str == pairs[i].str {
+			count += pairs[j].count
+			j++
+		}
+		if err := writeCountLine(writer, count, pairs[i].str); err != nil {
+			return "", err
+		}
+		i = j
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return "", err
+	}
+
+	return tempFilePath, nil
 }
 
-// mergeSortedChunks merges sorted chunks into the target file using a more
-// memory-efficient approach. Used in SortByAproxFFrequency.
+// countSortedChunks performs a k-way merge over the sorted chunk files and
+// sums the counts of identical lines across all chunks. The resulting
+// (count, line) pairs are buffered and written to frequency sorted run files
+// whenever the buffer is full, which keeps memory usage bounded. Used in
+// SortByAproxFrequency.
 //
 // Args:
-// tempDir (string): The temporary directory containing the sorted chunks
-// targetPATH (string): The path to the target file
+// ctx (context.Context): The context used to cancel the sort
+// chunkPaths ([]string): The paths of the sorted chunk files
+// tempDir (string): The temporary directory for storing files
 //
 // Returns:
+// []string: The paths of the frequency sorted run files
 // error: An error if one occurred
-func mergeSortedChunks(tempDir, targetPATH string) error {
-	files, err := os.ReadDir(tempDir)
+func countSortedChunks(ctx context.Context, chunkPaths []string, tempDir string) ([]string, error) {
+	merger, err := newChunkMerger(chunkPaths, func(a, b freqPair) bool {
+		return a.str < b.str
+	})
 	if err != nil {
-		return err
+		LogInternalError("Error opening chunk file", err.Error())
+		return nil, err
 	}
+	defer merger.Close()
 
-	chunkFiles := make([]*os.File, len(files))
-	scanners := make([]*bufio.Scanner, len(files))
-	itemsInTempDir := 0
+	var runPaths []string
+	pairs := make([]freqPair, 0, 1024)
+	progress := models.ProgressFromContext(ctx)
 
-	for i, file := range files {
-		chunkFilePath := fmt.Sprintf("%s/%s", tempDir, file.Name())
-		chunkFile, err := os.Open(chunkFilePath)
+	flush := func() error {
+		runPath, err := sortAndWriteCountRun(pairs, tempDir, len(runPaths))
 		if err != nil {
-			LogInternalEvent("Error opening chunk file", err.Error())
 			return err
 		}
-		chunkFiles[i] = chunkFile
-		scanners[i] = bufio.NewScanner(chunkFile)
-		itemsInTempDir++
+		runPaths = append(runPaths, runPath)
+		progress.AddRun()
+		metrics.SortRunFlushes.Inc()
+		pairs = pairs[:0]
+		runtime.GC()
+		return nil
+	}
+
+	var current freqPair
+	hasCurrent := false
+	for pairCount := 0; ; pairCount++ {
+		if pairCount%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		pair, ok, err := merger.Next()
+		if err != nil {
+			LogInternalError("Error during scanning", err.Error())
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		if hasCurrent && pair.str == current.str {
+			current.count += pair.count
+			continue
+		}
+		if hasCurrent {
+			pairs = append(pairs, current)
+			if len(pairs) >= sortRunPairCount {
+				LogInternalEvent("Flushing entries to file", fmt.Sprintf("Flushes: %d", len(runPaths)))
+				if err := flush(); err != nil {
+					LogInternalError("Error flushing entries to file", err.Error())
+					return nil, err
+				}
+			}
+		}
+		current = pair
+		hasCurrent = true
+	}
+
+	if hasCurrent {
+		pairs = append(pairs, current)
+	}
+	if len(pairs) > 0 {
+		if err := flush(); err != nil {
+			LogInternalError("Error flushing remaining entries to file", err.Error())
+			return nil, err
+		}
+	}
+
+	return runPaths, nil
+}
+
+// sortAndWriteCountRun sorts (count, line) pairs by descending count and
+// writes them to a temporary run file. Used in SortByAproxFrequency.
+//
+// Args:
+// pairs ([]freqPair): The counted lines to sort and write
+// tempDir (string): The temporary directory for storing files
+// runCounter (int): The number of the run used to name the file
+//
+// Returns:
+// string: The path of the written run file
+// error: An error if one occurred
+func sortAndWriteCountRun(pairs []freqPair, tempDir string, runCounter int) (string, error) {
+	sort.Slice(pairs, func(i, j int) bool {
+		return frequencyOrder(pairs[i], pairs[j])
+	})
+
+	runFilePath := fmt.Sprintf("%s/count_%d.txt", tempDir, runCounter)
+	runFile, err := os.Create(runFilePath)
+	if err != nil {
+		return "", err
+	}
+	defer runFile.Close()
+
+	writer := bufio.NewWriter(runFile)
+	for _, pair := range pairs {
+		if err := writeCountLine(writer, pair.count, pair.str); err != nil {
+			return "", err
+		}
 	}
 
-	outputFile, err := os.Create(targetPATH)
+	if err := writer.Flush(); err != nil {
+		return "", err
+	}
+
+	return runFilePath, nil
+}
+
+// mergeSortedChunks merges the frequency sorted run files into the target file
+// with a k-way merge on count. Each line appears in exactly one run, so the
+// output contains no duplicates. When countsPATH is set the count of every
+// line is written to it as a "count\tline" record in the same order. Used in
+// SortByAproxFrequency.
+//
+// Args:
+// runPaths ([]string): The paths of the frequency sorted run files
+// targetPATH (string): The path to the target file
+// countsPATH (string): The path to the companion counts file or empty to skip
+// progress (*models.GenerationProgress): The progress to report to or nil
+//
+// Returns:
+// error: An error if one occurred
+func mergeSortedChunks(runPaths []string, targetPATH string, countsPATH string, progress *models.GenerationProgress) error {
+	merger, err := newChunkMerger(runPaths, frequencyOrder)
 	if err != nil {
-		LogInternalEvent("Error creating output file", err.Error())
+		LogInternalError("Error opening chunk file", err.Error())
 		return err
 	}
-	defer outputFile.Close()
+	defer merger.Close()
 
+	// Both files are published only once the merge is complete so readers
+	// keep seeing the previous generation until then
+	outputFile, err := CreateAtomic(targetPATH, 0644)
+	if err != nil {
+		LogInternalError("Error creating output file", err.Error())
+		return err
+	}
+	defer outputFile.Abort()
 	writer := bufio.NewWriter(outputFile)
-	entries := make(map[string]int)
-	numberOfWrittenEntries := 0
 
-	for i, scanner := range scanners {
-		for scanner.Scan() {
-			line := scanner.Text()
-			entries[line]++
-			// Flush the entries to the file when the map reaches a certain
-			// size to avoid running out of memory. Because we are clearing the
-			// map in memory, the output will contain duplicates. The higher
-			// the threshold, the less duplicates, however, base memory usage
-			// will also rise.
-			//
-			// Adjust the threshold as needed
-			// Highest Approved: 250,000,000
-			// 8GB Recommended: 50,000,000
-			//
-			if len(entries) > 50000000 {
-				LogInternalEvent("Flushing entries to file", fmt.Sprintf("Flushes: %d", numberOfWrittenEntries))
-				if err := flushEntriesToFile(entries, writer); err != nil {
-					LogInternalEvent("Error flushing entries to file", err.Error())
-					return err
-				}
-				entries = make(map[string]int)
-				numberOfWrittenEntries++
-			}
-		}
-		if err := scanner.Err(); err != nil {
-			LogInternalEvent("Error during scanning", err.Error())
+	var countsFile *AtomicFile
+	var countsWriter *bufio.Writer
+	if countsPATH != "" {
+		countsFile, err = CreateAtomic(countsPATH, 0644)
+		if err != nil {
+			LogInternalError("Error creating counts file", err.Error())
 			return err
 		}
-		// Close file after processing
-		err = chunkFiles[i].Close()
+		defer countsFile.Abort()
+		countsWriter = bufio.NewWriter(countsFile)
+	}
+
+	numberOfWrittenEntries := 0
+	for {
+		pair, ok, err := merger.Next()
 		if err != nil {
-			LogInternalEvent("Error closing chunk file", err.Error())
+			LogInternalError("Error during scanning", err.Error())
 			return err
 		}
-	}
+		if !ok {
+			break
+		}
 
-	if len(entries) > 0 {
-		LogInternalEvent("Flushing remaining entries to file", fmt.Sprintf("Flushes: %d", numberOfWrittenEntries))
-		if err := flushEntriesToFile(entries, writer); err != nil {
-			LogInternalEvent("Error flushing remaining entries to file", err.Error())
+		if _, err := writer.WriteString(pair.str + "\n"); err != nil {
+			LogInternalError("Error writing entry to file", err.Error())
 			return err
 		}
+		if countsWriter != nil {
+			if err := writeCountLine(countsWriter, pair.count, pair.str); err != nil {
+				LogInternalError("Error writing entry to counts file", err.Error())
+				return err
+			}
+		}
+		numberOfWrittenEntries++
+		if numberOfWrittenEntries%cancelCheckInterval == 0 {
+			progress.AddLines(cancelCheckInterval)
+			metrics.MergedLines.Add(cancelCheckInterval)
+		}
 	}
+	progress.AddLines(int64(numberOfWrittenEntries % cancelCheckInterval))
+	metrics.MergedLines.Add(float64(numberOfWrittenEntries % cancelCheckInterval))
 
 	if err := writer.Flush(); err != nil {
-		LogInternalEvent("Error flushing writer", err.Error())
+		LogInternalError("Error flushing writer", err.Error())
+		return err
+	}
+	if countsWriter != nil {
+		if err := countsWriter.Flush(); err != nil {
+			LogInternalError("Error flushing counts writer", err.Error())
+			return err
+		}
+		if err := countsFile.Commit(); err != nil {
+			LogInternalError("Error publishing counts file", err.Error())
+			return err
+		}
+	}
+	if err := outputFile.Commit(); err != nil {
+		LogInternalError("Error publishing output file", err.Error())
 		return err
 	}
 
-	LogInternalEvent("Merge complete", fmt.Sprintf("Processed %d chunks", itemsInTempDir))
+	LogInternalEvent("Merge complete", fmt.Sprintf("Processed %d runs into %d unique entries", len(runPaths), numberOfWrittenEntries))
 	return nil
 }
 
-// flushEntriesToFile writes the entries to the file and clears the map to free
-// memory. Used in mergeSortedChunks which is used in SortByAproxFrequency.
+// freqPair is a line and the number of times it was seen
+type freqPair struct {
+	str   string
+	count int
+}
+
+// frequencyOrder orders pairs by descending count and then lexically so the
+// output is deterministic.
+//
+// Args:
+// a (freqPair): The first pair
+// b (freqPair): The second pair
+//
+// Returns:
+// bool: True if a sorts before b
+func frequencyOrder(a, b freqPair) bool {
+	if a.count != b.count {
+		return a.count > b.count
+	}
+	return a.str < b.str
+}
+
+// writeCountLine writes a "count\tline" record to the writer.
 //
 // Args:
-// entries (map[string]int): The entries to write
-// writer (*bufio.Writer): The writer to write to the file
+// writer (*bufio.Writer): The writer to write to
+// count (int): The number of occurrences
+// line (string): The line that was counted
 //
 // Returns:
 // error: An error if one occurred
-func flushEntriesToFile(entries map[string]int, writer *bufio.Writer) error {
-	type freqPair struct {
-		str   string
-		count int
+func writeCountLine(writer *bufio.Writer, count int, line string) error {
+	if _, err := writer.WriteString(strconv.Itoa(count)); err != nil {
+		return err
+	}
+	if err := writer.WriteByte('\t'); err != nil {
+		return err
 	}
+	_, err := writer.WriteString(line + "\n")
+	return err
+}
 
-	freqPairs := make([]freqPair, 0, len(entries))
-	for str, count := range entries {
-		freqPairs = append(freqPairs, freqPair{str, count})
+// parseCountLine parses a "count\tline" record.
+//
+// Args:
+// record (string): The record to parse
+//
+// Returns:
+// freqPair: The parsed pair
+// error: An error if the record is malformed
+func parseCountLine(record string) (freqPair, error) {
+	countText, line, found := strings.Cut(record, "\t")
+	if !found {
+		return freqPair{}, fmt.Errorf("malformed count record: %q", record)
+	}
+	count, err := strconv.Atoi(countText)
+	if err != nil {
+		return freqPair{}, fmt.Errorf("malformed count record: %q: %w", record, err)
 	}
+	return freqPair{str: line, count: count}, nil
+}
 
-	sort.Slice(freqPairs, func(i, j int) bool {
-		return freqPairs[i].count > freqPairs[j].count
-	})
+// chunkMerger performs a k-way merge over files of "count\tline" records that
+// are each sorted by the same ordering. Only one record per file is held in
+// memory at a time.
+type chunkMerger struct {
+	files    []*os.File
+	scanners []*bufio.Scanner
+	heap     *pairHeap
+}
 
-	for _, pair := range freqPairs {
-		line := strings.TrimSpace(strings.TrimSuffix(pair.str, fmt.Sprintf(" %d", pair.count)))
-		_, err := writer.WriteString(line + "\n")
+// newChunkMerger opens every file and primes the merge heap with the first
+// record of each file.
+//
+// Args:
+// paths ([]string): The paths of the sorted files to merge
+// less (func(a, b freqPair) bool): The ordering the files are sorted by
+//
+// Returns:
+// *chunkMerger: The merger
+// error: An error if one occurred
+func newChunkMerger(paths []string, less func(a, b freqPair) bool) (*chunkMerger, error) {
+	merger := &chunkMerger{heap: &pairHeap{less: less}}
+	for i, path := range paths {
+		file, err := os.Open(path)
 		if err != nil {
-			return err
+			merger.Close()
+			return nil, err
+		}
+		merger.files = append(merger.files, file)
+		merger.scanners = append(merger.scanners, NewLineScanner(file))
+		if err := merger.advance(i); err != nil {
+			merger.Close()
+			return nil, err
 		}
 	}
-
-	return nil
+	return merger, nil
 }
 
-// sortAndWriteChunk sorts a chunk of lines and writes it to a temporary file.
-// Used in SortByAproxFrequency.
+// advance reads the next record from the given source and pushes it onto the
+// heap.
 //
 // Args:
-// lines ([]string): The lines to sort and write
-// tempDir (string): The temporary directory for storing files
+// source (int): The index of the file to read from
 //
 // Returns:
 // error: An error if one occurred
-func sortAndWriteChunk(lines []string, tempDir string, chunkCounter int) error {
-	sort.Strings(lines)
-	tempFilePath := fmt.Sprintf("%s/chunk_%d.txt", tempDir, chunkCounter)
-	tempFile, err := os.Create(tempFilePath)
+func (m *chunkMerger) advance(source int) error {
+	scanner := m.scanners[source]
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+	pair, err := parseCountLine(scanner.Text())
 	if err != nil {
 		return err
 	}
-	defer tempFile.Close()
+	heap.Push(m.heap, heapItem{pair: pair, source: source})
+	return nil
+}
 
-	writer := bufio.NewWriter(tempFile)
-	for _, line := range lines {
-		_, err := writer.WriteString(line + "\n")
-		if err != nil {
-			return err
-		}
+// Next returns the next record in merge order.
+//
+// Returns:
+// freqPair: The next record
+// bool: False when every file has been exhausted
+// error: An error if one occurred
+func (m *chunkMerger) Next() (freqPair, bool, error) {
+	if m.heap.Len() == 0 {
+		return freqPair{}, false, nil
+	}
+	item := heap.Pop(m.heap).(heapItem)
+	if err := m.advance(item.source); err != nil {
+		return freqPair{}, false, err
 	}
+	return item.pair, true, nil
+}
 
-	err = writer.Flush()
-	if err != nil {
-		return err
+// Close closes every file opened by the merger.
+func (m *chunkMerger) Close() {
+	for _, file := range m.files {
+		file.Close()
 	}
+}
 
-	return nil
+// heapItem is a record and the index of the file it was read from
+type heapItem struct {
+	pair   freqPair
+	source int
+}
+
+// pairHeap implements heap.Interface over records using a custom ordering
+type pairHeap struct {
+	items []heapItem
+	less  func(a, b freqPair) bool
 }
 
-// LikelyContainsWords checks a string to see if there are atleast 5 characters
-// in a row that are not digits or special characters and ensures that there is
-// atleast one vowel in the string.
+func (h pairHeap) Len() int           { return len(h.items) }
+func (h pairHeap) Less(i, j int) bool { return h.less(h.items[i].pair, h.items[j].pair) }
+func (h pairHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *pairHeap) Push(x any) { h.items = append(h.items, x.(heapItem)) }
+
+func (h *pairHeap) Pop() any {
+	old := h.items
+	item := old[len(old)-1]
+	h.items = old[:len(old)-1]
+	return item
+}
+
+// LikelyContainsWords checks a string to see if there are enough windows of
+// consecutive characters that are mostly letters and contain a vowel. The
+// window size, the number of digits or special characters allowed per window,
+// and the number of windows required come from the word likeness rules. The
+// string is compared by character rather than by byte so multi-byte letters
+// count once.
 //
 // Args:
 // s (string): The string to check.
+// rules (models.WordLikeness): The word likeness thresholds.
 //
 // Returns:
 // bool: True if the string likely contains words, false otherwise.
-func LikelyContainsWords(s string) bool {
-	if len(s) < 5 {
+func LikelyContainsWords(s string, rules models.WordLikeness) bool {
+	chars := []rune(s)
+	if len(chars) < rules.WindowSize {
 		return false
 	}
 
 	vowelCount := 0
-	for i := 0; i < len(s)-4; i++ {
-		if isWordLike(s[i : i+5]) {
+	for i := 0; i <= len(chars)-rules.WindowSize; i++ {
+		if isWordLike(chars[i:i+rules.WindowSize], rules.MaxNonLetters) {
 			vowelCount++
+			if vowelCount >= rules.MinWindows {
+				return true
+			}
 		}
 	}
 
-	return vowelCount > 0
+	return false
 }
 
 // isWordLike checks if a substring contains at least one vowel and no more
-// than one digit or special character.
+// than maxNonLetters digits or special characters.
 //
 // Args:
-// s (string): The substring to check.
+// s ([]rune): The substring to check.
+// maxNonLetters (int): The number of digits or special characters allowed.
 //
 // Returns:
 // bool: True if the substring is likely a word, false otherwise.
-func isWordLike(s string) bool {
-	vowels := "aeiouAEIOU"
+func isWordLike(s []rune, maxNonLetters int) bool {
 	digitOrSpecialCount := 0
 	hasVowel := false
 
 	for _, char := range s {
-		if strings.ContainsRune(vowels, char) {
+		if isVowel(char) {
 			hasVowel = true
 		} else if !unicode.IsLetter(char) {
 			digitOrSpecialCount++
 		}
 	}
 
-	return hasVowel && digitOrSpecialCount <= 1
+	return hasVowel && digitOrSpecialCount <= maxNonLetters
 }
 
-// IsQualityCandidateCheck checks if a string meets the quality regex or not
+// IsQualityCandidateCheck checks if a string matches any of the blocklist
+// patterns or not
 //
 // Args:
 // s (string): The string to check
+// blocklist ([]*regexp.Regexp): Patterns matching strings that are likely not
+// quality candidates
 //
 // Returns:
 // bool: True if the string is a quality candidate, false otherwise
-func IsQualityCandidateCheck(s string) bool {
-	// This regex checks for common patterns that are likely not quality
-	// candidates.
-	qualityRegex := `(xiaonei|zomato|fbobh|fccdbbcdaa|yahoo|linkedin|gmail|yandex|hotmail)|http:\\/\\/|https:\\/\\/|\\@.*\\.net|<tr>|<div>|<a href|<p>|<img src|[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\\.[A-Za-z]{2,6}|^[0-9]+$|^.{0,5}$`
-	re := regexp.MustCompile(qualityRegex)
-	return !re.MatchString(s)
+func IsQualityCandidateCheck(s string, blocklist []*regexp.Regexp) bool {
+	for _, re := range blocklist {
+		if re.MatchString(s) {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"ponder/pkg/models"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// lineChunkSizes are the buffer sizes every ReadLineChunks test is run with
+var lineChunkSizes = []int{1, 7, 4096, 256 * 1024}
+
+// lineChunkInput returns lines of varying length that straddle every buffer
+// boundary, CRLF line endings, over-long lines, and a final line without a
+// trailing newline, along with the output ReadLineChunks should produce.
+func lineChunkInput() (string, string) {
+	var input, expected strings.Builder
+	for i := 0; i < 2000; i++ {
+		line := strings.Repeat(string(rune('a'+i%26)), i%300+1)
+		if i%3 == 0 {
+			line += "\r"
+		}
+		fmt.Fprintf(&input, "%s\n", line)
+		fmt.Fprintf(&expected, "%s\n", line)
+
+		if i == 500 || i == 1500 {
+			// Over-long lines are dropped wherever they fall
+			fmt.Fprintf(&input, "%s\n", strings.Repeat("x", MaxLineLength+i))
+		}
+	}
+	fmt.Fprintf(&input, "%s\n", strings.Repeat("y", MaxLineLength))
+	fmt.Fprintf(&expected, "%s\n", strings.Repeat("y", MaxLineLength))
+	input.WriteString("final line")
+	expected.WriteString("final line")
+	return input.String(), expected.String()
+}
+
+// readAllLineChunks concatenates every block passed to fn by ReadLineChunks
+// and checks that every block but the last ends with a newline.
+func readAllLineChunks(t *testing.T, r io.Reader, bufferSize int) string {
+	t.Helper()
+
+	var output bytes.Buffer
+	var blocks [][]byte
+	err := ReadLineChunks(r, bufferSize, func(chunk []byte) error {
+		blocks = append(blocks, append([]byte(nil), chunk...))
+		output.Write(chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadLineChunks with buffer size %d: %v", bufferSize, err)
+	}
+	for i, block := range blocks[:len(blocks)-1] {
+		if !bytes.HasSuffix(block, []byte("\n")) {
+			t.Fatalf("buffer size %d: block %d does not end with a newline", bufferSize, i)
+		}
+	}
+	return output.String()
+}
+
+func TestReadLineChunksIdenticalForEveryBufferSize(t *testing.T) {
+	input, expected := lineChunkInput()
+
+	for _, size := range lineChunkSizes {
+		if output := readAllLineChunks(t, strings.NewReader(input), size); output != expected {
+			t.Errorf("buffer size %d: output differs from the expected %d bytes (got %d bytes)", size, len(expected), len(output))
+		}
+		// Short reads split lines at other places than the buffer size
+		if output := readAllLineChunks(t, iotest.HalfReader(strings.NewReader(input)), size); output != expected {
+			t.Errorf("buffer size %d with short reads: output differs from the expected %d bytes (got %d bytes)", size, len(expected), len(output))
+		}
+	}
+}
+
+func TestReadLineChunksDropsInputWithoutNewlines(t *testing.T) {
+	// A reader that never ends a line must not be accumulated in memory
+	input := io.LimitReader(repeatReader('z'), 16*MaxLineLength)
+
+	calls := 0
+	err := ReadLineChunks(input, 4096, func(chunk []byte) error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadLineChunks: %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("fn was called %d times for an over-long line", calls)
+	}
+}
+
+func TestReadLineChunksKeepsLinesAfterOverLongLine(t *testing.T) {
+	input := strings.Repeat("z", 3*MaxLineLength) + "\nkept\n"
+
+	for _, size := range lineChunkSizes {
+		if output := readAllLineChunks(t, strings.NewReader(input), size); output != "kept\n" {
+			t.Errorf("buffer size %d: got %q, want %q", size, output, "kept\n")
+		}
+	}
+}
+
+func TestNewLineScannerReadsCountedLinesOfMaxLineLength(t *testing.T) {
+	line := strings.Repeat("x", MaxLineLength)
+	scanner := NewLineScanner(strings.NewReader("123456789\t" + line + "\nkept\n"))
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0] != "123456789\t"+line || lines[1] != "kept" {
+		t.Errorf("got %d lines", len(lines))
+	}
+
+	scanner = NewLineScanner(strings.NewReader(strings.Repeat("x", MaxRecordLength+1) + "\n"))
+	for scanner.Scan() {
+	}
+	if scanner.Err() == nil {
+		t.Error("a line longer than MaxRecordLength was scanned without an error")
+	}
+}
+
+// repeatReader is an endless reader of a single byte
+type repeatReader byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+// withSmallSortChunks shrinks the external sort so a few hundred lines are
+// spread over many chunks and run files, and keeps its temporary files in a
+// test directory.
+func withSmallSortChunks(t *testing.T) {
+	t.Helper()
+
+	chunkLines, runPairs, sourceDirectory := sortChunkLineCount, sortRunPairCount, models.SourceDirectory
+	sortChunkLineCount, sortRunPairCount, models.SourceDirectory = 37, 5, t.TempDir()
+	t.Cleanup(func() {
+		sortChunkLineCount, sortRunPairCount, models.SourceDirectory = chunkLines, runPairs, sourceDirectory
+	})
+}
+
+// readCounts reads a counts file into a map and checks that every line
+// appears once and the counts never increase.
+func readCounts(t *testing.T, countsPATH string) map[string]int {
+	t.Helper()
+
+	data, err := os.ReadFile(countsPATH)
+	if err != nil {
+		t.Fatalf("reading counts: %v", err)
+	}
+	counts := make(map[string]int)
+	previous := -1
+	for _, record := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		count, line, ok := strings.Cut(record, "\t")
+		if !ok {
+			t.Fatalf("invalid counts record %q", record)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			t.Fatalf("invalid count in %q: %v", record, err)
+		}
+		if _, seen := counts[line]; seen {
+			t.Errorf("line %q is listed more than once", line)
+		}
+		if previous != -1 && n > previous {
+			t.Errorf("line %q with count %d follows a count of %d", line, n, previous)
+		}
+		counts[line] = n
+		previous = n
+	}
+	return counts
+}
+
+func TestSortByAproxFrequencyCountsEveryLineExactlyOnce(t *testing.T) {
+	withSmallSortChunks(t)
+	dir := t.TempDir()
+	targetPATH := filepath.Join(dir, "wordlist.txt")
+	countsPATH := filepath.Join(dir, "wordlist.counts")
+	previousPATH := filepath.Join(dir, "previous.counts")
+
+	// Repeats of a line are spread across chunks so only a merge over every
+	// chunk counts them correctly
+	expected := make(map[string]int)
+	var input strings.Builder
+	for round := 0; round < 40; round++ {
+		for word := 0; word < 60; word++ {
+			if round < word%40+1 {
+				fmt.Fprintf(&input, "word%02d\n", word)
+				expected[fmt.Sprintf("word%02d", word)]++
+			}
+		}
+	}
+	if err := os.WriteFile(targetPATH, []byte(input.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(previousPATH, []byte("100\tword59\n3\tprevious\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected["word59"] += 100
+	expected["previous"] = 3
+
+	if err := SortByAproxFrequency(context.Background(), targetPATH, countsPATH, previousPATH); err != nil {
+		t.Fatalf("SortByAproxFrequency: %v", err)
+	}
+
+	counts := readCounts(t, countsPATH)
+	if len(counts) != len(expected) {
+		t.Errorf("got %d distinct lines, want %d", len(counts), len(expected))
+	}
+	for line, want := range expected {
+		if counts[line] != want {
+			t.Errorf("line %q: got count %d, want %d", line, counts[line], want)
+		}
+	}
+
+	target, err := os.ReadFile(targetPATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(target), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Errorf("target has %d lines, want %d", len(lines), len(expected))
+	}
+	if lines[0] != "word59" {
+		t.Errorf("first line is %q, want the most frequent line word59", lines[0])
+	}
+}